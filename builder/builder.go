@@ -9,6 +9,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime"
@@ -17,9 +18,13 @@ import (
 	"time"
 
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/containerruntime"
+	"github.com/Azure/acr-builder/pkg/execution"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/Azure/acr-builder/pkg/volume"
+	"github.com/Azure/acr-builder/secretmgmt"
 	"github.com/Azure/acr-builder/util"
 	"github.com/pkg/errors"
 )
@@ -34,29 +39,197 @@ var once sync.Once
 // Builder builds images.
 type Builder struct {
 	procManager  *procmanager.ProcManager
+	runtime      containerruntime.ContainerRuntime
+	cli          *containerruntime.CLIConfig
 	workspaceDir string
 	debug        bool
+
+	// checkpointRunID is the ID of the run checkpoints are persisted for, if they're enabled.
+	checkpointRunID string
+
+	// resumeCheckpoint is the checkpoint of the run being resumed, if any.
+	resumeCheckpoint *Checkpoint
+	resumeFromStep   string
+
+	// cacheRegistry is the repository cache entries are shared through, if any.
+	cacheRegistry string
+
+	// logSink receives the output of the steps.
+	logSink LogSink
+
+	// stopTimeout is how long running step containers are given to stop when the run is cancelled.
+	stopTimeout time.Duration
+
+	// redactor masks the values of secrets in the output of the steps and in logged arguments.
+	redactor *redact.Redactor
+
+	// secretCache re-resolves the registry credentials once they expire, if it's set.
+	secretCache   *secretmgmt.SecretCache
+	credentialsMu sync.Mutex
 }
 
 // NewBuilder creates a new Builder.
 func NewBuilder(pm *procmanager.ProcManager, debug bool, workspaceDir string) *Builder {
 	return &Builder{
 		procManager:  pm,
+		runtime:      newCLIRuntime(pm, containerruntime.DockerCLI),
+		cli:          containerruntime.DockerCLI,
 		debug:        debug,
 		workspaceDir: workspaceDir,
+		logSink:      NewConsoleLogSink(os.Stdout, os.Stderr, false, false),
+		stopTimeout:  DefaultStopTimeoutInSec * time.Second,
+		redactor:     redact.NewRedactor(),
 	}
 }
 
-// RunTask executes a Task.
+// SetSecretCache sets the cache the Task's registry credentials are re-resolved through once they expire,
+// before running each step, so that long runs log in again with fresh credentials, e.g. before a late push.
+func (b *Builder) SetSecretCache(cache *secretmgmt.SecretCache) {
+	b.secretCache = cache
+}
+
+// SetRedactor sets the redactor masking the values of secrets in the output of the steps and in logged arguments,
+// e.g. to share it with the ProcManager. The values of the secrets of the Tasks it runs are added to it.
+func (b *Builder) SetRedactor(redactor *redact.Redactor) {
+	b.redactor = redactor
+}
+
+// SetLogSink sets the sink the output of the steps is written to, instead of the console.
+func (b *Builder) SetLogSink(sink LogSink) {
+	b.logSink = sink
+}
+
+// SetContainerRuntime sets the runtime the steps' containers, images, networks and volumes
+// are managed with, along with the CLI the Builder's own helper containers are run with, instead of the docker CLI.
+func (b *Builder) SetContainerRuntime(runtime containerruntime.ContainerRuntime, cli *containerruntime.CLIConfig) {
+	b.runtime = runtime
+	b.cli = cli
+}
+
+// SetStopTimeout sets how long running step containers are given to stop gracefully
+// when the run is cancelled or times out, before being killed.
+func (b *Builder) SetStopTimeout(timeout time.Duration) {
+	b.stopTimeout = timeout
+}
+
+// RunTask executes a Task. If ctx is cancelled, the running steps' containers are stopped,
+// the steps which didn't complete are marked as cancelled and the finally steps are run.
+// The Task's timeout, if any, bounds the whole run, except for its finally steps.
 func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
+	b.redactor.Add(getSecretValues(task)...)
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(task.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	run := newTaskRun(task)
+	restored, err := b.setupTask(ctx, task)
+	task.StartTime = time.Now()
+	if err == nil {
+		run.restored = restored
+		run.checkpoint = b.newCheckpointer(restored)
+		err = b.runDag(ctx, run)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = run.timeoutErr(err)
+		}
+	} else {
+		// The steps are left skipped, but the finally steps still run.
+		for _, step := range task.Steps {
+			step.SkipReason = "the run's setup failed"
+		}
+	}
+	if len(task.Finally) > 0 {
+		status := RunSucceeded
+		if errors.Is(err, context.Canceled) {
+			status = RunCancelled
+		} else if err != nil {
+			status = RunFailed
+		}
+		log.Printf("Executing finally steps, run status: %s\n", status)
+
+		// Finally steps must run even if the run was cancelled or timed out.
+		finallyCtx := ctx
+		if ctx.Err() != nil {
+			finallyCtx = context.WithoutCancel(ctx)
+		}
+		if finallyErr := b.runDag(finallyCtx, newFinallyRun(task, status)); err == nil {
+			err = finallyErr
+		}
+	}
+	task.EndTime = time.Now()
+
+	for _, step := range task.AllSteps() {
+		if step.SkipReason != "" {
+			log.Printf("Step ID: %v marked as %v (%s)\n", step.ID, step.StepStatus, step.SkipReason)
+		} else if step.Resumed {
+			log.Printf("Step ID: %v marked as %v (restored from the checkpoint)\n", step.ID, step.StepStatus)
+		} else if step.CacheHit {
+			log.Printf("Step ID: %v marked as %v (restored from the cache)\n", step.ID, step.StepStatus)
+		} else {
+			log.Printf("Step ID: %v marked as %v (elapsed time in seconds: %f)\n", step.ID, step.StepStatus, step.EndTime.Sub(step.StartTime).Seconds())
+		}
+	}
+	var timing strings.Builder
+	if err := NewTimingReport(task).Print(&timing); err == nil {
+		log.Printf("Timing summary:\n%s", timing.String())
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return errors.Wrap(err, "the run was cancelled")
+	}
+	if err != nil {
+		return err
+	}
+
+	var deps []*image.Dependencies
+	for _, step := range task.Steps {
+		if len(step.ImageDependencies) > 0 {
+			log.Printf("Populating digests for step ID: %s...\n", step.ID)
+			timeout := time.Duration(digestsTimeoutInSec) * time.Second
+			digestCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			usingBuildkit := false
+			if (step.UseBuildCacheForBuildStep() && runtime.GOOS == util.LinuxOS) || step.UsesBuildkit {
+				log.Printf("Image was built using buildkit, fetching Digest from remote...")
+				usingBuildkit = true
+			}
+
+			if err := b.getPopulateDigests(digestCtx, step.ImageDependencies, usingBuildkit, task.RegistryLoginCredentials); err != nil {
+				return err
+			}
+			log.Printf("Successfully populated digests for step ID: %s\n", step.ID)
+			deps = append(deps, step.ImageDependencies...)
+		}
+	}
+
+	// Persist the populated digests.
+	b.saveCheckpoint(ctx, run.checkpoint, nil)
+
+	if len(deps) > 0 {
+		depBytes, err := json.Marshal(deps)
+		if err != nil {
+			return errors.Wrap(err, "failed to unmarshal image dependencies")
+		}
+		log.Println("The following dependencies were found:")
+		log.Println("\n" + string(depBytes))
+	}
+
+	return nil
+}
+
+// setupTask creates the Task's networks and volumes, sets up the Docker configuration, logs in to the Task's registries
+// and returns the checkpoints of the steps which completed in a previous run, if the run is resumed.
+func (b *Builder) setupTask(ctx context.Context, task *graph.Task) (map[string]*StepCheckpoint, error) {
 	for _, network := range task.Networks {
 		if network.SkipCreation {
 			log.Printf("Skip creating network: %s\n", network.Name)
 			continue
 		}
 		log.Printf("Creating Docker network: %s, driver: '%s'\n", network.Name, network.Driver)
-		if msg, err := network.Create(ctx, b.procManager); err != nil {
-			return fmt.Errorf("failed to create network: %s, err: %v, msg: %s", network.Name, err, msg)
+		if err := b.runtime.CreateNetwork(ctx, network.Options()); err != nil {
+			return nil, fmt.Errorf("failed to create network: %s, err: %v", network.Name, err)
 		}
 		log.Printf("Successfully set up Docker network: %s\n", network.Name)
 	}
@@ -66,7 +239,7 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 	configCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	if err := b.setupConfig(configCtx); err != nil {
-		return err
+		return nil, err
 	}
 	log.Println("Successfully set up Docker configuration")
 	if task.UsingRegistryCreds() {
@@ -76,18 +249,12 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 			defer cancel()
 			log.Printf("Logging in to registry: %s\n", registry)
 			if err := b.dockerLoginWithRetries(loginCtx, registry, cred.Username.ResolvedValue, cred.Password.ResolvedValue, 0); err != nil {
-				return err
+				return nil, err
 			}
 			log.Printf("Successfully logged into %s\n", registry)
 		}
 	}
 
-	var completedChans []chan bool
-	errorChan := make(chan error)
-	for _, node := range task.Dag.Nodes {
-		completedChans = append(completedChans, node.Value.CompletedChan)
-	}
-
 	if task.InitBuildkitContainer {
 		log.Println("Task will use build cache, initializing buildkitd container")
 		// --workdir = /workspace
@@ -111,7 +278,7 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 			buildxImg+" create --use",
 		)
 		if b.debug {
-			log.Printf("buildkitd container args: %v\n", strings.Join(args, ", "))
+			log.Printf("buildkitd container args: %v\n", strings.Join(b.redactor.RedactArgs(args), ", "))
 		}
 
 		timeout := time.Duration(buildkitdContainerRunTimeoutInSeconds) * time.Second
@@ -129,7 +296,8 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 			nil,
 			buildkitdContainerInitRetryDelay,
 			buildkitdContainerName,
-			buildkitdContainerInitRepeat)
+			buildkitdContainerInitRepeat,
+			nil)
 		if err != nil {
 			log.Printf("buildx create --use failed with error: '%v'", err)
 		}
@@ -138,74 +306,54 @@ func (b *Builder) RunTask(ctx context.Context, task *graph.Task) error {
 	for _, volMount := range task.Volumes {
 		// create and populate volume for specified source
 		if err := b.prepareVolumeSource(ctx, volMount); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	for _, child := range task.Dag.Root.Children() {
-		go b.processVertex(ctx, task, task.Dag.Root, child, errorChan)
+	return b.restoredSteps(task)
+}
+
+// runDag runs the steps of the run's graph and blocks until they've all been processed.
+func (b *Builder) runDag(ctx context.Context, run *taskRun) error {
+	var completedChans []chan bool
+	for _, node := range run.dag.Nodes {
+		completedChans = append(completedChans, node.Value.CompletedChan)
+	}
+
+	for _, child := range run.dag.Root.Children() {
+		go b.processVertex(ctx, run, run.dag.Root, child)
 	}
 
 	// Block until either:
 	// - The global context expires
-	// - A step has an error
+	// - The graph can't be processed
 	// - All steps have been processed
-	for _, ch := range completedChans {
+	// If the run is cancelled or times out, the steps are still waited on, since they're stopped
+	// and the steps which didn't run are marked accordingly.
+	done := ctx.Done()
+	for i := 0; i < len(completedChans); {
 		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ch:
-			continue
-		case err := <-errorChan:
+		case <-done:
+			done = nil
+		case <-completedChans[i]:
+			i++
+		case err := <-run.errorChan:
 			return err
 		}
 	}
 
-	var deps []*image.Dependencies
-	for _, step := range task.Steps {
-		log.Printf("Step ID: %v marked as %v (elapsed time in seconds: %f)\n", step.ID, step.StepStatus, step.EndTime.Sub(step.StartTime).Seconds())
-
-		if len(step.ImageDependencies) > 0 {
-			log.Printf("Populating digests for step ID: %s...\n", step.ID)
-			timeout := time.Duration(digestsTimeoutInSec) * time.Second
-			digestCtx, cancel := context.WithTimeout(ctx, timeout)
-			defer cancel()
-
-			usingBuildkit := false
-			if (step.UseBuildCacheForBuildStep() && runtime.GOOS == util.LinuxOS) || step.UsesBuildkit {
-				log.Printf("Image was built using buildkit, fetching Digest from remote...")
-				usingBuildkit = true
-			}
-
-			if err := b.getPopulateDigests(digestCtx, step.ImageDependencies, usingBuildkit, task.RegistryLoginCredentials); err != nil {
-				return err
-			}
-			log.Printf("Successfully populated digests for step ID: %s\n", step.ID)
-			deps = append(deps, step.ImageDependencies...)
-		}
-	}
-
-	if len(deps) > 0 {
-		depBytes, err := json.Marshal(deps)
-		if err != nil {
-			return errors.Wrap(err, "failed to unmarshal image dependencies")
-		}
-		log.Println("The following dependencies were found:")
-		log.Println("\n" + string(depBytes))
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-
-	return nil
+	return run.err()
 }
 
 // CleanTask iterates through all build steps and removes
 // their corresponding containers.
 func (b *Builder) CleanTask(ctx context.Context, task *graph.Task) {
-	args := []string{"docker", "rm", "-f"}
-	for _, n := range task.Dag.Nodes {
-		step := n.Value
+	for _, step := range task.AllSteps() {
 		if step.StepStatus != graph.Skipped {
-			killArgs := append(args, step.ID)
-			_ = b.procManager.Run(ctx, killArgs, nil, nil, nil, "")
+			_ = b.runtime.Remove(ctx, step.ID)
 		}
 	}
 
@@ -214,47 +362,131 @@ func (b *Builder) CleanTask(ctx context.Context, task *graph.Task) {
 			log.Printf("Skip deleting network: %s\n", network.Name)
 			continue
 		}
-		if msg, err := network.Delete(ctx, b.procManager); err != nil {
-			log.Printf("Failed to delete network: %s, err: %v, msg: %s\n", network.Name, err, msg)
+		if err := b.runtime.RemoveNetwork(ctx, network.Name); err != nil {
+			log.Printf("Failed to delete network: %s, err: %v\n", network.Name, err)
 		}
 	}
 
 	_ = b.procManager.Stop()
 }
 
-func (b *Builder) processVertex(ctx context.Context, task *graph.Task, parent *graph.Node, child *graph.Node, errorChan chan error) {
-	err := task.Dag.RemoveEdge(parent.Name, child.Name)
+// processVertex removes the edge between parent and child and, once all of the child's dependencies
+// have been processed, either runs or skips the child's step before processing its own children.
+func (b *Builder) processVertex(ctx context.Context, run *taskRun, parent *graph.Node, child *graph.Node) {
+	degree, err := run.dag.RemoveEdgeAndGetDegree(parent.Name, child.Name)
 	if err != nil {
-		errorChan <- errors.Wrap(err, "failed to remove edge")
+		run.errorChan <- errors.Wrap(err, "failed to remove edge")
 		return
 	}
+	if degree != 0 {
+		return
+	}
+
+	step := child.Value
+	childSkipReason := ""
+	childDependencyFailed := false
+	if cp, ok := run.restored[step.ID]; ok {
+		log.Printf("Step ID: %s completed in a previous run, restoring it from the checkpoint\n", step.ID)
+		restoreStep(step, cp)
+	} else {
+		childSkipReason, childDependencyFailed = b.executeVertex(ctx, run, step)
+	}
+
+	for _, c := range child.Children() {
+		if childSkipReason != "" {
+			run.markSkipped(c.Name, childSkipReason, childDependencyFailed)
+		}
+		go b.processVertex(ctx, run, child, c)
+	}
+
+	// Step must always be marked as complete.
+	step.CompletedChan <- true
+}
+
+// executeVertex either runs or skips the step and returns the reason its children must be skipped, if any,
+// along with whether or not the skip was caused by a failure.
+func (b *Builder) executeVertex(ctx context.Context, run *taskRun, step *graph.Step) (string, bool) {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		log.Printf("Cancelling step ID: %s, the run was cancelled\n", step.ID)
+		step.StepStatus = graph.Cancelled
+		step.SkipReason = "the run was cancelled"
+		return fmt.Sprintf("dependency %s was cancelled", step.ID), true
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("Skipping step ID: %s, the run timed out\n", step.ID)
+		step.StepStatus = graph.Skipped
+		step.SkipReason = "the run timed out"
+		return fmt.Sprintf("dependency %s was skipped", step.ID), true
+	}
+
+	var err error
+	reason, dependencyFailed := run.skipReason(step.ID)
+	shouldRun := reason == ""
+	if step.HasCondition() {
+		shouldRun, err = step.EvaluateCondition(run.conditionContext(step, reason != "", dependencyFailed))
+		if err != nil {
+			err = errors.Wrapf(err, "failed to evaluate the condition of step ID: %s", step.ID)
+		} else if !shouldRun && reason == "" {
+			reason = fmt.Sprintf("condition %q evaluated to false", step.If)
+		}
+	}
 
-	degree := child.GetDegree()
-	if degree == 0 {
-		step := child.Value
-		err := b.runStep(ctx, step, task.Credentials)
-		if err != nil && step.IgnoreErrors {
+	childSkipReason := ""
+	childDependencyFailed := false
+	if err == nil && !shouldRun {
+		log.Printf("Skipping step ID: %s, %s\n", step.ID, reason)
+		step.StepStatus = graph.Skipped
+		step.SkipReason = reason
+		childSkipReason = fmt.Sprintf("dependency %s was skipped", step.ID)
+		childDependencyFailed = dependencyFailed
+	} else {
+		if err == nil {
+			err = step.ResolveOutputReferences(run.steps)
+		}
+		var release func()
+		if err == nil {
+			release, err = run.acquire(ctx, step)
+		}
+		if err == nil {
+			// Expired registry credentials are refreshed right before running the step, since long runs can outlive them.
+			if err = b.refreshRegistryCredentials(ctx, run.task); err == nil {
+				err = b.runStepWithCache(ctx, run, step)
+			}
+			release()
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				run.interrupt(step.ID)
+			}
+			if err != nil && step.ExitCode == 0 {
+				step.ExitCode = procmanager.ExitCode(err)
+			}
+		}
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			step.StepStatus = graph.Cancelled
+			childSkipReason = fmt.Sprintf("dependency %s was cancelled", step.ID)
+			childDependencyFailed = true
+		} else if err != nil && step.IgnoreErrors {
 			log.Printf("Step ID: %s encountered an error: %v, but is set to ignore errors. Continuing...\n", step.ID, err)
 			step.StepStatus = graph.Successful
-			for _, c := range child.Children() {
-				go b.processVertex(ctx, task, child, c, errorChan)
-			}
 		} else if err != nil {
 			step.StepStatus = graph.Failed
-			errorChan <- errors.Wrapf(err, "failed to run step ID: %s", step.ID)
+			run.fail(step.ID, errors.Wrapf(err, "failed to run step ID: %s", step.ID))
+			childSkipReason = fmt.Sprintf("dependency %s failed", step.ID)
+			childDependencyFailed = true
 		} else {
 			step.StepStatus = graph.Successful
-			for _, c := range child.Children() {
-				go b.processVertex(ctx, task, child, c, errorChan)
-			}
 		}
-		// Step must always be marked as complete.
-		step.CompletedChan <- true
 	}
+
+	if step.StepStatus == graph.Successful {
+		b.saveCheckpoint(ctx, run.checkpoint, step)
+	}
+	return childSkipReason, childDependencyFailed
 }
 
-func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*graph.RegistryCredential) error {
-	log.Printf("Executing step ID: %s. Timeout(sec): %d, Working directory: '%s', Network: '%s'\n", step.ID, step.Timeout, step.WorkingDirectory, step.Network)
+// runStep runs the step, adding the specified environment variables to its container.
+func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*graph.RegistryCredential, envs []string) error {
+	timeout := getStepTimeout(ctx, step)
+	log.Printf("Executing step ID: %s. Timeout(sec): %d, Working directory: '%s', Network: '%s'\n", step.ID, int(timeout.Seconds()), step.WorkingDirectory, step.Network)
 	if step.StartDelay > 0 {
 		log.Printf("Waiting %d seconds before executing step ID: %s\n", step.StartDelay, step.ID)
 		time.Sleep(time.Duration(step.StartDelay) * time.Second)
@@ -273,11 +505,16 @@ func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*
 		step.EndTime = time.Now()
 	}()
 
-	var args []string
+	// The output of each attempt is split into lines for the log sink, so that the output of parallel steps stays readable.
+	output := newStepOutput(b.logSink, b.redactor, step.ID)
+	record := recordAttempt(step)
+	onAttempt := func(attempt execution.Attempt) {
+		output.endAttempt()
+		record(attempt)
+	}
 
 	if step.IsBuildStep() {
 		dockerfile, target, dockerContext := parseDockerBuildCmd(step.Build)
-		volName := b.workspaceDir
 
 		// Print out a warning message if a remote context doesn't appear to be valid, i.e. doesn't end with .git.
 		validateDockerContext(dockerContext)
@@ -286,53 +523,24 @@ func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*
 		timeout := time.Duration(scrapeTimeoutInSec) * time.Second
 		scrapeCtx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
-		deps, err := b.scrapeDependencies(scrapeCtx, volName, step.WorkingDirectory, step.ID, dockerfile, dockerContext, step.Tags, step.BuildArgs, target, credentials)
+		deps, err := b.scrapeDependencies(scrapeCtx, b.workspaceDir, step.WorkingDirectory, step.ID, dockerfile, dockerContext, step.Tags, step.BuildArgs, target, credentials)
 		if err != nil {
 			return errors.Wrap(err, "failed to scan dependencies")
 		}
 		log.Println("Successfully scanned dependencies")
 		step.ImageDependencies = deps
-
-		workingDirectory := step.WorkingDirectory
-		// Modify the Run command if it's a tar or a git URL.
-		if !util.IsLocalContext(dockerContext) {
-			// NB: use step.ID as the working directory if the context is remote,
-			// since we obtained the source code from the scanner and put it in this location.
-			// If the remote context also has additional context specified, we have to append it
-			// to the working directory.
-			if util.IsSourceControlURL(dockerContext) {
-				workingDirectory = step.ID + "/" + getContextFromGitURL(dockerContext)
-			} else {
-				workingDirectory = step.ID
-			}
-			step.Build = replacePositionalContext(step.Build, ".")
-		}
-		step.UpdateBuildStepWithDefaults()
-
-		if step.UseBuildCacheForBuildStep() {
-			args = b.getDockerRunArgsForStep(volName, workingDirectory, step, "", buildxImg+" build "+step.Build)
-		} else {
-			if !step.UsesBuildkit {
-				// Moby v23 and above has enabled BuildKit by default but it breaks the base image digest inspection.
-				// Disable BuildKit to avoid this issue for now.
-				step.Envs = append(step.Envs, "DOCKER_BUILDKIT=0")
-			}
-			args = b.getDockerRunArgsForStep(volName, workingDirectory, step, "", dockerImg+" build "+step.Build)
-		}
 	} else if step.IsPushStep() {
-		timeout := time.Duration(step.Timeout) * time.Second
 		pushCtx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
-		return b.pushWithRetries(pushCtx, step.Push)
-	} else {
-		args = b.getDockerRunArgsForStep(b.workspaceDir, step.WorkingDirectory, step, step.EntryPoint, step.Cmd)
+		return b.pushWithRetries(pushCtx, step.Push, output.writer(Stdout), output.writer(Stderr), onAttempt)
 	}
 
+	opts := b.getStepRunOptions(step)
+	opts.Envs = append(opts.Envs, envs...)
 	if b.debug {
-		log.Printf("Step args: %v\n", strings.Join(args, ", "))
+		log.Printf("Step args: %v\n", strings.Join(b.redactor.RedactArgs(b.cli.RunArgs(opts)), ", "))
 	}
 
-	timeout := time.Duration(step.Timeout) * time.Second
 	stepCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
@@ -345,23 +553,105 @@ func (b *Builder) runStep(ctx context.Context, step *graph.Step, credentials []*
 		}
 	}
 
-	return b.procManager.RunRepeatWithRetries(
+	// Capture the standard output if any output needs to be extracted from it.
+	stdout := output.writer(Stdout)
+	var stdoutBuf bytes.Buffer
+	if step.HasOutputRegex() {
+		stdout = io.MultiWriter(stdout, &stdoutBuf)
+	}
+
+	run := func(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+		return b.runtime.Run(ctx, opts, stdout, stderr)
+	}
+	err := b.procManager.RunFuncRepeatWithRetryPolicy(
 		stepCtx,
-		args,
-		nil,
-		os.Stdout,
-		os.Stderr,
-		"",
-		step.Retries,
-		step.RetryOnErrors,
-		step.RetryDelayInSeconds,
+		run,
+		stdout,
+		output.writer(Stderr),
+		step.GetRetryPolicy(),
 		step.ID,
-		step.Repeat)
+		step.Repeat,
+		onAttempt)
+	if ctx.Err() != nil {
+		// Cancelling the run or reaching its deadline only stops waiting for the container, it must be stopped separately.
+		b.stopContainer(ctx, step.ID)
+	}
+	if err != nil || !step.HasOutputs() || b.procManager.DryRun {
+		return err
+	}
+	return b.collectOutputs(stepCtx, step, stdoutBuf.String())
+}
+
+// getStepTimeout returns the step's timeout, bounded by the time remaining before ctx's deadline, if any.
+func getStepTimeout(ctx context.Context, step *graph.Step) time.Duration {
+	timeout := time.Duration(step.Timeout) * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = max(remaining, 0)
+		}
+	}
+	return timeout
+}
+
+// stopContainer stops the specified container, giving it the Builder's stop timeout to exit gracefully before it's killed.
+func (b *Builder) stopContainer(ctx context.Context, containerName string) {
+	log.Printf("Stopping container: %s, timeout: %v\n", containerName, b.stopTimeout)
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), b.stopTimeout+time.Duration(stopCommandTimeoutInSec)*time.Second)
+	defer cancel()
+	if err := b.runtime.Stop(stopCtx, containerName, b.stopTimeout); err != nil && b.debug {
+		// The container may have already exited.
+		log.Printf("Failed to stop container: %s, err: %v\n", containerName, err)
+	}
+}
+
+// getStepRunOptions returns the options to run a cmd or build step, updating the step with the settings it runs with.
+func (b *Builder) getStepRunOptions(step *graph.Step) *containerruntime.RunOptions {
+	if !step.IsBuildStep() {
+		if step.HasOutputFile() {
+			step.Envs = append(step.Envs, outputFileEnvVar+"="+getOutputFilePath(step.ID))
+		}
+		return b.getRunOptionsForStep(b.workspaceDir, step.WorkingDirectory, step, step.EntryPoint, step.Cmd)
+	}
+
+	_, _, dockerContext := parseDockerBuildCmd(step.Build)
+	workingDirectory := step.WorkingDirectory
+	// Modify the Run command if it's a tar or a git URL.
+	if !util.IsLocalContext(dockerContext) {
+		// NB: use step.ID as the working directory if the context is remote,
+		// since we obtained the source code from the scanner and put it in this location.
+		// If the remote context also has additional context specified, we have to append it
+		// to the working directory.
+		if util.IsSourceControlURL(dockerContext) {
+			workingDirectory = step.ID + "/" + getContextFromGitURL(dockerContext)
+		} else {
+			workingDirectory = step.ID
+		}
+		step.Build = replacePositionalContext(step.Build, ".")
+	}
+	step.UpdateBuildStepWithDefaults()
+
+	if step.UseBuildCacheForBuildStep() {
+		return b.getRunOptionsForStep(b.workspaceDir, workingDirectory, step, "", buildxImg+" build "+step.Build)
+	}
+	if !step.UsesBuildkit {
+		// Moby v23 and above has enabled BuildKit by default but it breaks the base image digest inspection.
+		// Disable BuildKit to avoid this issue for now.
+		step.Envs = append(step.Envs, "DOCKER_BUILDKIT=0")
+	}
+	return b.getRunOptionsForStep(b.workspaceDir, workingDirectory, step, "", dockerImg+" build "+step.Build)
+}
+
+// recordAttempt returns a procmanager.AttemptFunc which records each attempt on the step.
+func recordAttempt(step *graph.Step) procmanager.AttemptFunc {
+	return func(attempt execution.Attempt) {
+		step.Attempts = append(step.Attempts, attempt)
+		step.ExitCode = attempt.ExitCode
+	}
 }
 
 // getPopulateDigests populates digests on dependencies
 func (b *Builder) getPopulateDigests(ctx context.Context, dependencies []*image.Dependencies, usingBuildkit bool, registryCreds graph.RegistryLoginCredentials) error {
-	dockerStoreDigester := newDockerStoreDigest(b.procManager, b.debug)
+	dockerStoreDigester := newDockerStoreDigest(b.procManager, b.cli, b.debug)
 
 	var baseImgDigester DigestHelper
 	baseImgDigester = dockerStoreDigester
@@ -397,19 +687,17 @@ func validateDockerContext(sourceContext string) {
 
 func (b *Builder) pullImageBeforeRun(ctx context.Context, cmdArgs string, retries, retryDelayInSeconds int) error {
 	imageName := parseImageNameFromArgs(cmdArgs)
-	args := []string{
-		"docker",
-		"run",
-		"--rm",
-		"--volume", util.DockerSocketVolumeMapping,
-		"docker",
-		"pull",
-		imageName,
-	}
 	if b.debug {
-		log.Printf("pull image args: %v\n", args)
+		log.Printf("pull image: %s\n", imageName)
+	}
+	policy := &procmanager.RetryPolicy{
+		Retries: retries,
+		Delay:   time.Duration(retryDelayInSeconds) * time.Second,
+	}
+	pull := func(ctx context.Context, stdout io.Writer, stderr io.Writer) error {
+		return b.runtime.Pull(ctx, imageName, stdout, stderr)
 	}
-	return b.procManager.RunWithRetries(ctx, args, nil, os.Stdout, os.Stdout, "", retries, nil, retryDelayInSeconds, "")
+	return b.procManager.RunFuncWithRetryPolicy(ctx, pull, os.Stdout, os.Stdout, policy, "", nil)
 }
 
 // parseImageNameFromArgs parses an image's name from a command step's arguments.
@@ -424,7 +712,7 @@ func parseImageNameFromArgs(cmdArgs string) string {
 // preRunWindowsContainer runs a Windows Server 2019 Hyper-V container to ensure subsequent runs succeed.
 func (b *Builder) preRunWindowsContainer(ctx context.Context, step *graph.Step) {
 	preRunArgs := []string{
-		"docker",
+		b.cli.Binary,
 		"run",
 		"--rm",
 		"--name", step.ID + "_prerun",
@@ -512,11 +800,11 @@ func (b *Builder) populateSecretVolume(ctx context.Context, volMount *volume.Vol
 	var dataSB strings.Builder
 	dataContainerArgs = getShell()
 	if runtime.GOOS == util.WindowsOS {
-		dataSB.WriteString("docker run --rm -v " + b.workspaceDir + ":c:\\source -v ")
+		dataSB.WriteString(b.cli.Binary + " run --rm -v " + b.workspaceDir + ":c:\\source -v ")
 		dataSB.WriteString(volMount.Name + ":c:\\dest -w c:\\source ")
 		dataSB.WriteString(configImageName + " cmd.exe /c copy c:\\source\\" + volMount.Name + " c:\\dest")
 	} else {
-		dataSB.WriteString("docker run --rm -v " + b.workspaceDir + ":/source -v ")
+		dataSB.WriteString(b.cli.Binary + " run --rm -v " + b.workspaceDir + ":/source -v ")
 		dataSB.WriteString(volMount.Name + ":/dest -w /source " + configImageName + " cp ")
 		for k := range volMount.Source.Secret {
 			dataSB.WriteString(volMount.Name + "/" + k)
@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/containerruntime"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/pkg/procmanager"
 	"github.com/Azure/acr-builder/pkg/volume"
@@ -209,7 +210,7 @@ func TestBuildStepDisablesBuildkitWhenNotExplicitlyEnabled(t *testing.T) {
 				step.Envs = append(step.Envs, "DOCKER_BUILDKIT=0")
 			}
 
-			builder := &Builder{}
+			builder := &Builder{cli: containerruntime.DockerCLI}
 			args := builder.getDockerRunArgsForStep("volName", "workDir", step, "", "docker build -f Dockerfile .")
 			argsStr := strings.Join(args, " ")
 
@@ -0,0 +1,580 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/containerruntime"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/util"
+	"github.com/google/uuid"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/file"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+const (
+	// cacheDir is the directory of the workspace volume cache entries are stored in.
+	cacheDir = ".acb_cache"
+
+	// cacheEntryFile is the file of a cache entry describing the step's results. It's written last,
+	// so an entry is only complete once it exists.
+	cacheEntryFile = "entry.json"
+
+	// cacheHitMarker is printed when a cache entry is restored, followed by the entry file.
+	cacheHitMarker = "acb_cache_hit"
+
+	cacheArtifactType  = "application/vnd.azure.acb.cache.v1"
+	cacheLayerMedia    = "application/vnd.azure.acb.cache.file.v1"
+	cacheTimeoutInSec  = 600
+	cacheRestoreLogFmt = "Restored step ID: %s from the cache, key: %s\n"
+)
+
+// cacheEntry describes the results of a cached step.
+type cacheEntry struct {
+	Outputs           map[string]string     `json:"outputs,omitempty"`
+	ImageDependencies []*image.Dependencies `json:"imageDependencies,omitempty"`
+}
+
+// SetCacheRegistry sets the repository cache entries are pushed to and pulled from when they
+// aren't found in the workspace volume, e.g. oci://myregistry.azurecr.io/acb-cache.
+func (b *Builder) SetCacheRegistry(repository string) {
+	b.cacheRegistry = util.TrimArtifactPrefix(repository)
+}
+
+// getCacheEntryDir returns the directory of a cache entry inside of a container.
+func getCacheEntryDir(key string) string {
+	return path.Join(containerWorkspaceDir, cacheDir, key)
+}
+
+// runStepWithCache runs the step, unless its results can be restored from the cache.
+// Caching problems never fail the step, it's run instead.
+func (b *Builder) runStepWithCache(ctx context.Context, run *taskRun, step *graph.Step) error {
+	task := run.task
+	if step.CacheKey == nil || step.Detach {
+		return b.runStep(ctx, step, task.Credentials, run.envs)
+	}
+	if runtime.GOOS == util.WindowsOS {
+		log.Println("step caching is not supported on windows. Will run the step")
+		return b.runStep(ctx, step, task.Credentials, run.envs)
+	}
+
+	cacheCtx, cancel := context.WithTimeout(ctx, time.Duration(cacheTimeoutInSec)*time.Second)
+	defer cancel()
+	key, err := b.computeCacheKey(cacheCtx, task, step)
+	if err != nil {
+		log.Printf("Failed to compute the cache key of step ID: %s, err: %v. Will run the step\n", step.ID, err)
+		return b.runStep(ctx, step, task.Credentials, run.envs)
+	}
+
+	start := time.Now()
+	hit, err := b.restoreFromCache(cacheCtx, task, step, key)
+	if err != nil {
+		log.Printf("Failed to restore step ID: %s from the cache, err: %v. Will run the step\n", step.ID, err)
+	} else if hit {
+		step.CacheHit = true
+		step.StartTime = start
+		step.EndTime = time.Now()
+		log.Printf(cacheRestoreLogFmt, step.ID, key)
+		return nil
+	} else {
+		log.Printf("No cache entry found for step ID: %s, key: %s\n", step.ID, key)
+	}
+
+	if err := b.runStep(ctx, step, task.Credentials, run.envs); err != nil {
+		return err
+	}
+
+	saveCtx, cancel := context.WithTimeout(ctx, time.Duration(cacheTimeoutInSec)*time.Second)
+	defer cancel()
+	if err := b.saveToCache(saveCtx, task, step, key); err != nil {
+		log.Printf("Failed to save step ID: %s to the cache, err: %v\n", step.ID, err)
+	}
+	return nil
+}
+
+// computeCacheKey computes the step's cache key from the files and environment variables of its
+// cache key and the ID of the image a cmd step runs, or the digests of the base images of a build step.
+func (b *Builder) computeCacheKey(ctx context.Context, task *graph.Task, step *graph.Step) (string, error) {
+	filesHash := ""
+	if len(step.CacheKey.Files) > 0 {
+		var buf bytes.Buffer
+		script := fmt.Sprintf(
+			`shopt -s globstar nullglob dotglob; for f in %s; do if [ -f "$f" ]; then sha256sum -- "$f"; fi; done | sort -u -k 2 | sha256sum`,
+			quoteGlobs(step.CacheKey.Files))
+		args := getCacheHelperArgs(b.cli, b.workspaceDir, step.WorkingDirectory, false, script)
+		if b.debug {
+			log.Printf("Cache key files hash args: %v\n", args)
+		}
+		if err := b.procManager.Run(ctx, args, nil, &buf, os.Stderr, ""); err != nil {
+			return "", errors.Wrap(err, "failed to hash the files of the cache key")
+		}
+		if fields := strings.Fields(buf.String()); len(fields) > 0 {
+			filesHash = fields[0]
+		}
+	}
+
+	imageID := ""
+	var err error
+	if step.IsCmdStep() {
+		imageID, err = b.getImageID(ctx, step)
+	} else if step.IsBuildStep() {
+		imageID, err = b.getBaseImageDigests(ctx, task, step)
+	}
+	if err != nil {
+		return "", err
+	}
+	return step.ComputeCacheKey(filesHash, imageID), nil
+}
+
+// getBaseImageDigests returns the digests of the images the build step's Dockerfile is based on, as found
+// by the dependency scanner. The digests are resolved from their registries, so that the key changes
+// when a tag such as alpine:latest moves even if the image is cached on the host.
+func (b *Builder) getBaseImageDigests(ctx context.Context, task *graph.Task, step *graph.Step) (string, error) {
+	dockerfile, target, dockerContext := parseDockerBuildCmd(step.Build)
+	deps, err := b.scrapeDependencies(ctx, b.workspaceDir, step.WorkingDirectory, step.ID, dockerfile, dockerContext, step.Tags, step.BuildArgs, target, task.Credentials)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to scan the base images")
+	}
+	digester := newRemoteDigest(task.RegistryLoginCredentials)
+	for _, dep := range deps {
+		for _, ref := range append([]*image.Reference{dep.Runtime}, dep.Buildtime...) {
+			if err := digester.PopulateDigest(ctx, ref); err != nil {
+				return "", errors.Wrapf(err, "failed to resolve the digest of base image %s", ref.Reference)
+			}
+		}
+	}
+	return formatBaseImageDigests(deps), nil
+}
+
+// formatBaseImageDigests returns the sorted, deduplicated references and digests of the base images of the dependencies.
+func formatBaseImageDigests(deps []*image.Dependencies) string {
+	seen := make(map[string]bool)
+	var digests []string
+	for _, dep := range deps {
+		for _, ref := range append([]*image.Reference{dep.Runtime}, dep.Buildtime...) {
+			if ref == nil {
+				continue
+			}
+			digest := ref.Reference + "@" + ref.Digest
+			if !seen[digest] {
+				seen[digest] = true
+				digests = append(digests, digest)
+			}
+		}
+	}
+	sort.Strings(digests)
+	return strings.Join(digests, ",")
+}
+
+// quoteGlobs quotes the globs for a shell, so that only their wildcards and bracket expressions are expanded.
+func quoteGlobs(globs []string) string {
+	quoted := make([]string, len(globs))
+	for i, glob := range globs {
+		var sb, literal strings.Builder
+		flush := func() {
+			if literal.Len() > 0 {
+				sb.WriteString("'" + strings.ReplaceAll(literal.String(), "'", `'\''`) + "'")
+				literal.Reset()
+			}
+		}
+		inBrackets := false
+		for _, r := range glob {
+			switch {
+			case inBrackets && (r == ']' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)):
+				// Ranges such as [a-z] only work unquoted.
+				flush()
+				sb.WriteRune(r)
+				inBrackets = r != ']'
+			case r == '*' || r == '?' || r == '[':
+				flush()
+				sb.WriteRune(r)
+				inBrackets = inBrackets || r == '['
+			default:
+				literal.WriteRune(r)
+			}
+		}
+		flush()
+		quoted[i] = sb.String()
+	}
+	return strings.Join(quoted, " ")
+}
+
+// getImageID returns the ID of the image the cmd step runs, pulling it if it isn't available locally.
+// The progress of the pull is written to the step's output.
+func (b *Builder) getImageID(ctx context.Context, step *graph.Step) (string, error) {
+	img := parseImageNameFromArgs(step.Cmd)
+	if info, err := b.runtime.InspectImage(ctx, img); err == nil {
+		return info.ID, nil
+	}
+	output := newStepOutput(b.logSink, b.redactor, step.ID)
+	err := b.runtime.Pull(ctx, img, output.writer(Stdout), output.writer(Stderr))
+	output.flush()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to pull image %s", img)
+	}
+	info, err := b.runtime.InspectImage(ctx, img)
+	if err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
+
+// restoreFromCache restores the step's results from the cache entry with the specified key, pulling it
+// from the cache registry if it isn't in the workspace volume. It returns false if no entry was found.
+func (b *Builder) restoreFromCache(ctx context.Context, task *graph.Task, step *graph.Step, key string) (bool, error) {
+	hit, err := b.restoreFromVolume(ctx, step, key)
+	if err != nil || hit || b.cacheRegistry == "" {
+		return hit, err
+	}
+	found, err := b.pullCacheEntry(ctx, task.RegistryLoginCredentials, key)
+	if err != nil || !found {
+		return false, err
+	}
+	return b.restoreFromVolume(ctx, step, key)
+}
+
+// restoreFromVolume restores the step's results from the cache entry with the specified key in the workspace volume.
+// The cached paths of cmd steps are extracted to their working directory, and the images of build steps are loaded.
+func (b *Builder) restoreFromVolume(ctx context.Context, step *graph.Step, key string) (bool, error) {
+	restore := `if [ -f "$d/paths.tar" ]; then tar -xf "$d/paths.tar"; fi`
+	if step.IsBuildStep() {
+		restore = ":"
+	}
+	script := fmt.Sprintf(`d='%s'; if [ -f "$d/%s" ]; then %s && echo %s && cat "$d/%s"; fi`,
+		getCacheEntryDir(key), cacheEntryFile, restore, cacheHitMarker, cacheEntryFile)
+
+	var buf bytes.Buffer
+	args := getCacheHelperArgs(b.cli, b.workspaceDir, step.WorkingDirectory, false, script)
+	if b.debug {
+		log.Printf("Cache restore args: %v\n", args)
+	}
+	if err := b.procManager.Run(ctx, args, nil, &buf, os.Stderr, ""); err != nil {
+		return false, err
+	}
+
+	out := buf.String()
+	i := strings.Index(out, cacheHitMarker)
+	if i < 0 {
+		return false, nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal([]byte(out[i+len(cacheHitMarker):]), entry); err != nil {
+		return false, errors.Wrap(err, "invalid cache entry")
+	}
+	if step.IsBuildStep() {
+		// The images are loaded by the runtime's CLI on the host, since the helper containers can't drive every runtime.
+		script := fmt.Sprintf(`cat '%s/image.tar'`, getCacheEntryDir(key))
+		if err := b.pipeArgs(ctx, getCacheHelperArgs(b.cli, b.workspaceDir, "", false, script), b.cli.LoadArgs()); err != nil {
+			return false, errors.Wrap(err, "failed to load the cached images")
+		}
+	}
+	step.OutputValues = entry.Outputs
+	step.ImageDependencies = entry.ImageDependencies
+	return true, nil
+}
+
+// saveToCache saves the step's results to the cache entry with the specified key in the workspace volume,
+// and pushes it to the cache registry if there's one.
+func (b *Builder) saveToCache(ctx context.Context, task *graph.Task, step *graph.Step, key string) error {
+	data, err := json.Marshal(&cacheEntry{Outputs: step.OutputValues, ImageDependencies: step.ImageDependencies})
+	if err != nil {
+		return err
+	}
+
+	// The entry is written to a temporary directory first, so that incomplete entries are never restored.
+	dir := getCacheEntryDir(key)
+	prepare := `rm -rf "$d.tmp"; mkdir -p "$d.tmp"`
+	save := ":"
+	if step.IsBuildStep() {
+		// The images are saved by the runtime's CLI on the host, since the helper containers can't drive every runtime.
+		script := fmt.Sprintf(`set -e; d='%s'; %s; cat > "$d.tmp/image.tar"`, dir, prepare)
+		if err := b.pipeArgs(ctx, b.cli.SaveArgs(step.Tags), getCacheHelperArgs(b.cli, b.workspaceDir, "", true, script)); err != nil {
+			return errors.Wrap(err, "failed to save the step's images")
+		}
+		prepare = ":"
+	} else if len(step.CachePaths) > 0 {
+		save = fmt.Sprintf(`tar -cf "$d.tmp/paths.tar" -- %s`, quoteGlobs(step.CachePaths))
+	}
+	script := fmt.Sprintf(`set -e; d='%s'; %s; %s; cat > "$d.tmp/%s"; rm -rf "$d"; mv "$d.tmp" "$d"`,
+		dir, prepare, save, cacheEntryFile)
+
+	args := getCacheHelperArgs(b.cli, b.workspaceDir, step.WorkingDirectory, true, script)
+	if b.debug {
+		log.Printf("Cache save args: %v\n", args)
+	}
+	if err := b.procManager.Run(ctx, args, bytes.NewReader(data), nil, os.Stderr, ""); err != nil {
+		return err
+	}
+	log.Printf("Saved step ID: %s to the cache, key: %s\n", step.ID, key)
+
+	if b.cacheRegistry != "" {
+		return b.pushCacheEntry(ctx, task.RegistryLoginCredentials, key)
+	}
+	return nil
+}
+
+// pullCacheEntry pulls the cache entry with the specified key from the cache registry to the workspace volume.
+// It returns false if the registry doesn't have the entry.
+func (b *Builder) pullCacheEntry(ctx context.Context, credentials graph.RegistryLoginCredentials, key string) (bool, error) {
+	repo, err := newCacheRepository(b.cacheRegistry, credentials)
+	if err != nil {
+		return false, err
+	}
+	dir, err := os.MkdirTemp("", "acb_cache_")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := file.New(dir)
+	if err != nil {
+		return false, err
+	}
+	defer store.Close()
+
+	log.Printf("Pulling cache entry %s:%s...\n", b.cacheRegistry, key)
+	if _, err := oras.Copy(ctx, repo, key, store, key, oras.DefaultCopyOptions); err != nil {
+		if errors.Is(err, errdef.ErrNotFound) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to pull the cache entry")
+	}
+
+	// Stream the entry's files to the workspace volume as a tarball.
+	archive, err := os.CreateTemp("", "acb_cache_*.tar")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	if err := writeTar(archive, dir); err != nil {
+		return false, err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	script := fmt.Sprintf(`set -e; d='%s'; rm -rf "$d"; mkdir -p "$d"; tar -xf - -C "$d"`, getCacheEntryDir(key))
+	args := getCacheHelperArgs(b.cli, b.workspaceDir, "", true, script)
+	if err := b.procManager.Run(ctx, args, archive, nil, os.Stderr, ""); err != nil {
+		return false, errors.Wrap(err, "failed to copy the cache entry to the workspace volume")
+	}
+	return true, nil
+}
+
+// pushCacheEntry pushes the cache entry with the specified key from the workspace volume to the cache registry.
+func (b *Builder) pushCacheEntry(ctx context.Context, credentials graph.RegistryLoginCredentials, key string) error {
+	repo, err := newCacheRepository(b.cacheRegistry, credentials)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "acb_cache_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Stream the entry's files from the workspace volume as a tarball, without buffering
+	// them in memory since they include the saved images of build steps.
+	pr, pw := io.Pipe()
+	readErr := make(chan error, 1)
+	go func() {
+		script := fmt.Sprintf(`tar -cf - -C '%s' .`, getCacheEntryDir(key))
+		args := getCacheHelperArgs(b.cli, b.workspaceDir, "", false, script)
+		err := b.procManager.Run(ctx, args, nil, pw, os.Stderr, "")
+		if err != nil {
+			err = errors.Wrap(err, "failed to read the cache entry from the workspace volume")
+		}
+		_ = pw.CloseWithError(err)
+		readErr <- err
+	}()
+	names, err := extractTar(pr, dir)
+	if err == nil {
+		// Drain the padding following the end of the tarball.
+		_, err = io.Copy(io.Discard, pr)
+	}
+	// Unblock the helper if extracting failed before reaching the end of the tarball.
+	_ = pr.CloseWithError(err)
+	if runErr := <-readErr; runErr != nil {
+		return runErr
+	}
+	if err != nil {
+		return err
+	}
+
+	store, err := file.New(dir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var layers []ocispec.Descriptor
+	for _, name := range names {
+		desc, err := store.Add(ctx, name, cacheLayerMedia, "")
+		if err != nil {
+			return err
+		}
+		layers = append(layers, desc)
+	}
+	manifest, err := oras.PackManifest(ctx, store, oras.PackManifestVersion1_1, cacheArtifactType, oras.PackManifestOptions{Layers: layers})
+	if err != nil {
+		return err
+	}
+	if err := store.Tag(ctx, manifest, key); err != nil {
+		return err
+	}
+
+	log.Printf("Pushing cache entry %s:%s...\n", b.cacheRegistry, key)
+	if _, err := oras.Copy(ctx, store, key, repo, key, oras.DefaultCopyOptions); err != nil {
+		return errors.Wrap(err, "failed to push the cache entry")
+	}
+	return nil
+}
+
+// newCacheRepository creates a client for the cache registry's repository.
+func newCacheRepository(repository string, credentials graph.RegistryLoginCredentials) (*remote.Repository, error) {
+	repo, err := remote.NewRepository(repository)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse cache repository %s", repository)
+	}
+	repo.Client = &auth.Client{
+		Header: http.Header{
+			"User-Agent":           {"oras-go"},
+			"X-Meta-Source-Client": {"azure/acr/tasks"},
+		},
+		Cache: auth.DefaultCache,
+		Credential: func(_ context.Context, registry string) (auth.Credential, error) {
+			// If no matching credential found, attempt an anonymous pull
+			if credentials[registry] == nil {
+				return auth.EmptyCredential, nil
+			}
+			return auth.Credential{
+				Username: credentials[registry].Username.ResolvedValue,
+				Password: credentials[registry].Password.ResolvedValue,
+			}, nil
+		},
+	}
+	return repo, nil
+}
+
+// getCacheHelperArgs returns the args to run a script in a container with the workspace volume mounted.
+func getCacheHelperArgs(cli *containerruntime.CLIConfig, volName string, workDir string, interactive bool, script string) []string {
+	args := []string{
+		cli.Binary,
+		"run",
+		"--name", fmt.Sprintf("acb_cache_%s", uuid.New()),
+		"--rm",
+	}
+	if interactive {
+		args = append(args, "--interactive")
+	}
+	args = append(args,
+		"--volume", volName+":"+containerWorkspaceDir,
+		"--workdir", normalizeWorkDir(workDir),
+	)
+	return append(args, "--entrypoint", "bash", configImageName, "-c", script)
+}
+
+// pipeArgs runs both args, piping the standard output of src to the standard input of dst.
+func (b *Builder) pipeArgs(ctx context.Context, src []string, dst []string) error {
+	if b.debug {
+		log.Printf("Cache pipe args: %v | %v\n", src, dst)
+	}
+	pr, pw := io.Pipe()
+	srcErr := make(chan error, 1)
+	go func() {
+		err := b.procManager.Run(ctx, src, nil, pw, os.Stderr, "")
+		pw.CloseWithError(err)
+		srcErr <- err
+	}()
+	err := b.procManager.Run(ctx, dst, pr, os.Stderr, os.Stderr, "")
+	// Unblock src if dst exited without reading all of its output.
+	pr.CloseWithError(err)
+	if err := <-srcErr; err != nil {
+		return err
+	}
+	return err
+}
+
+// writeTar writes the regular files of the directory to a tarball.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: entry.Name(), Mode: 0600, Size: info.Size()}); err != nil {
+			return err
+		}
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// extractTar extracts the regular files at the root of a tarball to the directory and returns their names.
+func extractTar(r io.Reader, dir string) ([]string, error) {
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || strings.Contains(name, "/") {
+			continue
+		}
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+}
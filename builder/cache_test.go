@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+)
+
+func TestQuoteGlobs(t *testing.T) {
+	tests := []struct {
+		globs    []string
+		expected string
+	}{
+		{[]string{"go.sum"}, `'go.sum'`},
+		{[]string{"src/**/*.go", "bin"}, `'src/'**'/'*'.go' 'bin'`},
+		{[]string{"file[0-9].txt"}, `'file'[0-9]'.txt'`},
+		{[]string{"a b; rm -rf /"}, `'a b; rm -rf /'`},
+		{[]string{"it's"}, `'it'\''s'`},
+	}
+	for _, test := range tests {
+		if actual := quoteGlobs(test.globs); actual != test.expected {
+			t.Errorf("expected %v to be quoted as %s but got %s", test.globs, test.expected, actual)
+		}
+	}
+}
+
+func TestFormatBaseImageDigests(t *testing.T) {
+	alpine := &image.Reference{Reference: "alpine:latest", Digest: "sha256:a"}
+	golang := &image.Reference{Reference: "golang:1.22", Digest: "sha256:b"}
+	deps := []*image.Dependencies{
+		{Runtime: alpine, Buildtime: []*image.Reference{golang, alpine}},
+		{Runtime: golang},
+	}
+	if actual, expected := formatBaseImageDigests(deps), "alpine:latest@sha256:a,golang:1.22@sha256:b"; actual != expected {
+		t.Errorf("expected %s but got %s", expected, actual)
+	}
+
+	moved := []*image.Dependencies{{Runtime: &image.Reference{Reference: "alpine:latest", Digest: "sha256:c"}}}
+	if formatBaseImageDigests(moved) == formatBaseImageDigests(deps[:1]) {
+		t.Error("expected a moved base image to change the digests")
+	}
+}
+
+func TestPipeArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test requires a POSIX shell")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	b := NewBuilder(procmanager.NewProcManager(false), false, "")
+
+	if err := b.pipeArgs(context.Background(), []string{"sh", "-c", "echo image"}, []string{"sh", "-c", "cat > " + out}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "image\n" {
+		t.Errorf("expected the output of src to be piped to dst but got %q, err: %v", data, err)
+	}
+	if err := b.pipeArgs(context.Background(), []string{"sh", "-c", "exit 3"}, []string{"sh", "-c", "cat > " + out}); err == nil {
+		t.Error("expected the failure of src to be returned")
+	}
+	if err := b.pipeArgs(context.Background(), []string{"sh", "-c", "echo image"}, []string{"sh", "-c", "exit 3"}); err == nil {
+		t.Error("expected the failure of dst to be returned")
+	}
+}
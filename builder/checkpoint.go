@@ -0,0 +1,167 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/pkg/errors"
+)
+
+// checkpointDir is the directory of the workspace volume checkpoints are persisted to.
+const checkpointDir = ".acb_checkpoints"
+
+// runIDRE matches the IDs of the runs which can be checkpointed. Run IDs are part of the checkpoint's
+// file path and of the scripts reading and writing it, so they're restricted to characters safe in both.
+var runIDRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidateRunID returns an error if the run ID can't be used to checkpoint or resume a run.
+func ValidateRunID(runID string) error {
+	if !runIDRE.MatchString(runID) || strings.Contains(runID, "..") {
+		return fmt.Errorf("invalid run ID %q, run IDs may only contain letters, digits and the characters ._- without ..", runID)
+	}
+	return nil
+}
+
+// Checkpoint records the steps of a run which completed successfully, so that the run can be resumed.
+type Checkpoint struct {
+	RunID string                     `json:"runId"`
+	Steps map[string]*StepCheckpoint `json:"steps"`
+}
+
+// StepCheckpoint records the results of a step which completed successfully.
+type StepCheckpoint struct {
+	Outputs           map[string]string     `json:"outputs,omitempty"`
+	ImageDependencies []*image.Dependencies `json:"imageDependencies,omitempty"`
+}
+
+// checkpointer persists the checkpoint of a run as its steps complete.
+type checkpointer struct {
+	mu         sync.Mutex
+	checkpoint *Checkpoint
+}
+
+// getCheckpointFilePath returns the path of the run's checkpoint inside of a container.
+func getCheckpointFilePath(runID string) string {
+	return fmt.Sprintf("%s%c%s%c%s.json", containerWorkspaceDir, containerPathSeparator, checkpointDir, containerPathSeparator, runID)
+}
+
+// EnableCheckpoint persists a checkpoint of the run with the specified ID to the workspace volume
+// as the steps of the Tasks it runs complete.
+func (b *Builder) EnableCheckpoint(runID string) {
+	b.checkpointRunID = runID
+}
+
+// Resume loads the checkpoint of the specified run, so that the steps which completed during that run
+// aren't run again by RunTask. If fromStep is specified, the step and the steps depending on it are run again
+// even if they had completed.
+func (b *Builder) Resume(ctx context.Context, runID string, fromStep string) error {
+	if err := ValidateRunID(runID); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	args := getReadCheckpointArgs(b.cli, b.workspaceDir, getCheckpointFilePath(runID))
+	if b.debug {
+		log.Printf("Read checkpoint args: %v\n", args)
+	}
+	if err := b.procManager.Run(ctx, args, nil, &buf, os.Stderr, ""); err != nil {
+		return errors.Wrapf(err, "failed to read the checkpoint of run %s", runID)
+	}
+	if buf.Len() == 0 {
+		return fmt.Errorf("no checkpoint found for run %s", runID)
+	}
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(buf.Bytes(), checkpoint); err != nil {
+		return errors.Wrapf(err, "invalid checkpoint for run %s", runID)
+	}
+	b.resumeCheckpoint = checkpoint
+	b.resumeFromStep = fromStep
+	return nil
+}
+
+// restoredSteps returns the checkpoints of the Task's steps which don't need to run again.
+func (b *Builder) restoredSteps(task *graph.Task) (map[string]*StepCheckpoint, error) {
+	restored := make(map[string]*StepCheckpoint)
+	if b.resumeCheckpoint == nil {
+		return restored, nil
+	}
+
+	rerun := make(map[string]bool)
+	if b.resumeFromStep != "" {
+		if _, ok := task.Dag.Nodes[b.resumeFromStep]; !ok {
+			return nil, fmt.Errorf("unable to resume from unknown step %s", b.resumeFromStep)
+		}
+		rerun[b.resumeFromStep] = true
+		for _, id := range task.Dag.Descendants(b.resumeFromStep) {
+			rerun[id] = true
+		}
+	}
+
+	for _, step := range task.Steps {
+		if cp, ok := b.resumeCheckpoint.Steps[step.ID]; ok && !rerun[step.ID] {
+			restored[step.ID] = cp
+		}
+	}
+	return restored, nil
+}
+
+// newCheckpointer returns a checkpointer for the run if checkpoints are enabled, nil otherwise.
+// The checkpoint initially contains the restored steps.
+func (b *Builder) newCheckpointer(restored map[string]*StepCheckpoint) *checkpointer {
+	if b.checkpointRunID == "" {
+		return nil
+	}
+	steps := make(map[string]*StepCheckpoint, len(restored))
+	for id, cp := range restored {
+		steps[id] = cp
+	}
+	return &checkpointer{checkpoint: &Checkpoint{RunID: b.checkpointRunID, Steps: steps}}
+}
+
+// saveCheckpoint records the completion of the step, if specified, and persists the checkpoint.
+// Failing to persist the checkpoint doesn't fail the run.
+func (b *Builder) saveCheckpoint(ctx context.Context, c *checkpointer, step *graph.Step) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if step != nil {
+		c.checkpoint.Steps[step.ID] = &StepCheckpoint{
+			Outputs:           step.OutputValues,
+			ImageDependencies: step.ImageDependencies,
+		}
+	}
+	data, err := json.Marshal(c.checkpoint)
+	if err != nil {
+		log.Printf("Failed to marshal the checkpoint of run %s: %v\n", c.checkpoint.RunID, err)
+		return
+	}
+	args := getWriteCheckpointArgs(b.cli, b.workspaceDir, getCheckpointFilePath(c.checkpoint.RunID))
+	if b.debug {
+		log.Printf("Write checkpoint args: %v\n", args)
+	}
+	if err := b.procManager.Run(ctx, args, bytes.NewReader(data), nil, os.Stderr, ""); err != nil {
+		log.Printf("Failed to write the checkpoint of run %s: %v\n", c.checkpoint.RunID, err)
+	}
+}
+
+// restoreStep marks the step as completed using its checkpoint.
+func restoreStep(step *graph.Step, cp *StepCheckpoint) {
+	step.StepStatus = graph.Successful
+	step.Resumed = true
+	step.OutputValues = cp.Outputs
+	step.ImageDependencies = cp.ImageDependencies
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import "testing"
+
+func TestValidateRunID(t *testing.T) {
+	tests := []struct {
+		runID       string
+		shouldError bool
+	}{
+		{"run1", false},
+		{"cf1a.2024-01-01_build", false},
+		{"", true},
+		{"run'; rm -rf /; '", true},
+		{"../run1", true},
+		{"runs/run1", true},
+		{"run..1", true},
+		{"run 1", true},
+	}
+	for _, test := range tests {
+		err := ValidateRunID(test.runID)
+		if test.shouldError && err == nil {
+			t.Errorf("expected run ID %q to error but it didn't", test.runID)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("run ID %q shouldn't have errored, but it did; err: %v", test.runID, err)
+		}
+	}
+}
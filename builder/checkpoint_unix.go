@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+//go:build linux || darwin
+
+package builder
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/Azure/acr-builder/pkg/containerruntime"
+	"github.com/google/uuid"
+)
+
+// getWriteCheckpointArgs returns the args to write a checkpoint, read from the standard input, to the workspace volume.
+func getWriteCheckpointArgs(cli *containerruntime.CLIConfig, volName string, file string) []string {
+	return []string{
+		cli.Binary,
+		"run",
+		"--name", fmt.Sprintf("acb_write_checkpoint_%s", uuid.New()),
+		"--rm",
+		"--interactive",
+		"--volume", volName + ":" + containerWorkspaceDir,
+		"--entrypoint", "bash",
+		configImageName,
+		"-c", fmt.Sprintf("mkdir -p '%s' && cat > '%s'", path.Dir(file), file),
+	}
+}
+
+// getReadCheckpointArgs returns the args to print a checkpoint from the workspace volume.
+func getReadCheckpointArgs(cli *containerruntime.CLIConfig, volName string, file string) []string {
+	return []string{
+		cli.Binary,
+		"run",
+		"--name", fmt.Sprintf("acb_read_checkpoint_%s", uuid.New()),
+		"--rm",
+		"--volume", volName + ":" + containerWorkspaceDir,
+		"--entrypoint", "bash",
+		configImageName,
+		"-c", fmt.Sprintf("if [ -f '%[1]s' ]; then cat '%[1]s'; fi", file),
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/acr-builder/pkg/containerruntime"
+	"github.com/google/uuid"
+)
+
+// getWriteCheckpointArgs returns the args to write a checkpoint, read from the standard input, to the workspace volume.
+func getWriteCheckpointArgs(cli *containerruntime.CLIConfig, volName string, file string) []string {
+	dir := file[:strings.LastIndex(file, string(containerPathSeparator))]
+	return []string{
+		cli.Binary,
+		"run",
+		"--name", fmt.Sprintf("acb_write_checkpoint_%s", uuid.New()),
+		"--rm",
+		"--interactive",
+		"--volume", volName + ":" + containerWorkspaceDir,
+		"--entrypoint", "powershell",
+		getConfigImageName(),
+		fmt.Sprintf("New-Item -ItemType Directory -Force -Path '%s' | Out-Null; [Console]::In.ReadToEnd() | Set-Content -NoNewline -Path '%s'", dir, file),
+	}
+}
+
+// getReadCheckpointArgs returns the args to print a checkpoint from the workspace volume.
+func getReadCheckpointArgs(cli *containerruntime.CLIConfig, volName string, file string) []string {
+	return []string{
+		cli.Binary,
+		"run",
+		"--name", fmt.Sprintf("acb_read_checkpoint_%s", uuid.New()),
+		"--rm",
+		"--volume", volName + ":" + containerWorkspaceDir,
+		"--entrypoint", "powershell",
+		getConfigImageName(),
+		fmt.Sprintf("if (Test-Path '%[1]s') { Get-Content -Raw '%[1]s' }", file),
+	}
+}
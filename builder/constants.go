@@ -23,6 +23,14 @@ const (
 	digestsTimeoutInSec = 60 * 5  // 5 minutes
 	scrapeTimeoutInSec  = 60 * 15 // 15 minutes
 
+	// DefaultStopTimeoutInSec is how long running step containers are given to stop when the run is cancelled, before being killed.
+	DefaultStopTimeoutInSec = 10
+	stopCommandTimeoutInSec = 30
+
+	// DefaultCredentialTTLInSec is how long registry credentials resolved from a vault or MSI are used
+	// before they're resolved again, which is shorter than the lifetime of ACR refresh tokens.
+	DefaultCredentialTTLInSec = 60 * 60
+
 	// build cache constants
 	buildkitdContainerRunTimeoutInSeconds = 60 * 2 // 2 minutes
 	buildkitdContainerInitRetries         = 3
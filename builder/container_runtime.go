@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"fmt"
+
+	"github.com/Azure/acr-builder/pkg/containerruntime"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+)
+
+// NewContainerRuntime creates the ContainerRuntime of the specified kind, either one of the supported CLIs
+// or containerruntime.EngineRuntime, along with the CLI the Builder's helper containers are run with.
+// If socket is specified, it overrides the Docker compatible API socket mounted into the docker CLI containers.
+func NewContainerRuntime(kind string, socket string, pm *procmanager.ProcManager) (containerruntime.ContainerRuntime, *containerruntime.CLIConfig, error) {
+	if kind == containerruntime.EngineRuntime {
+		engine, err := containerruntime.NewEngine("")
+		if err != nil {
+			return nil, nil, err
+		}
+		return engine, withSocket(containerruntime.DockerCLI, socket), nil
+	}
+
+	cli, err := containerruntime.GetCLIConfig(kind)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unsupported container runtime: %s, must be %s, %s, %s or %s", kind,
+			containerruntime.DockerRuntime, containerruntime.PodmanRuntime, containerruntime.NerdctlRuntime, containerruntime.EngineRuntime)
+	}
+	cli = withSocket(cli, socket)
+	return newCLIRuntime(pm, cli), cli, nil
+}
+
+// newCLIRuntime creates a CLI runtime whose docker CLI containers share the home volume,
+// so that they use the credentials of the logged in registries.
+func newCLIRuntime(pm *procmanager.ProcManager, cli *containerruntime.CLIConfig) *containerruntime.CLI {
+	toolMounts := append(cli.SocketMounts(), containerruntime.Mount{Source: homeVol, Target: homeWorkDir})
+	return containerruntime.NewCLI(pm, cli, toolMounts, []string{homeEnv})
+}
+
+func withSocket(cli *containerruntime.CLIConfig, socket string) *containerruntime.CLIConfig {
+	if socket == "" {
+		return cli
+	}
+	return cli.WithSocket(socket)
+}
@@ -12,9 +12,11 @@ import (
 	"path"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/containerruntime"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/util"
 	"github.com/google/uuid"
@@ -25,7 +27,7 @@ var (
 	dependenciesRE = regexp.MustCompile(`(\[{"image.*?\])$`)
 )
 
-// getDockerRunArgs populates the args for running a Docker container.
+// getDockerRunArgs populates the args for running a container with the Builder's CLI.
 func (b *Builder) getDockerRunArgs(
 	volMounts map[string]string,
 	volName string,
@@ -44,84 +46,85 @@ func (b *Builder) getDockerRunArgs(
 	entrypoint string,
 	containerName string,
 	cmd string) []string {
-	var args []string
-	var sb strings.Builder
-	// Run user commands from a shell instance in order to mirror the shell's field splitting algorithms,
-	// so we don't have to write our own argv parser for exec.Command.
-	if runtime.GOOS == util.WindowsOS {
-		args = []string{"powershell.exe", "-Command"}
-	} else {
-		args = []string{"/bin/sh", "-c"}
-	}
+	return b.cli.RunArgs(b.getRunOptions(volMounts, volName, workDir, disableWorkDirOverride, remove, detach,
+		envs, ports, expose, privilaged, user, network, isolation, cpus, entrypoint, containerName, cmd))
+}
 
-	sb.WriteString("docker run")
-	if remove {
-		sb.WriteString(" --rm")
-	}
-	if detach {
-		sb.WriteString(" --detach")
-	}
-	for _, port := range ports {
-		sb.WriteString(" -p " + port)
-	}
-	for _, exp := range expose {
-		sb.WriteString(" --expose " + exp)
-	}
-	if privilaged {
-		sb.WriteString(" --privileged")
-	}
-	if user != "" {
-		sb.WriteString(" --user " + user)
-	}
-	if network != "" {
-		sb.WriteString(" --network " + network)
-	}
-	if isolation != "" {
-		sb.WriteString(" --isolation " + isolation)
-	}
-	if cpus != "" {
-		sb.WriteString(" --cpus " + cpus)
-	}
-	if entrypoint != "" {
-		sb.WriteString(" --entrypoint " + entrypoint)
+// getRunOptions populates the options for running a container.
+func (b *Builder) getRunOptions(
+	volMounts map[string]string,
+	volName string,
+	workDir string,
+	disableWorkDirOverride bool,
+	remove bool,
+	detach bool,
+	envs []string,
+	ports []string,
+	expose []string,
+	privilaged bool,
+	user string,
+	network string,
+	isolation string,
+	cpus string,
+	entrypoint string,
+	containerName string,
+	cmd string) *containerruntime.RunOptions {
+	opts := &containerruntime.RunOptions{
+		Name:       containerName,
+		Command:    cmd,
+		Entrypoint: entrypoint,
+		Remove:     remove,
+		Detach:     detach,
+		Privileged: privilaged,
+		Ports:      ports,
+		Expose:     expose,
+		User:       user,
+		Network:    network,
+		Isolation:  isolation,
+		CPUs:       cpus,
+
+		// User environment variables come after any defaults.
+		// This allows overriding the HOME environment variable for a step.
+		Envs: append([]string{homeEnv}, envs...),
 	}
-	sb.WriteString(" --name " + containerName)
-	sb.WriteString(" --volume " + volName + ":" + containerWorkspaceDir)
-	sb.WriteString(" --volume " + util.DockerSocketVolumeMapping)
-	sb.WriteString(" --volume " + homeVol + ":" + homeWorkDir)
-	if len(volMounts) > 0 {
-		for key, val := range volMounts {
-			sb.WriteString(" --volume " + key + ":" + val)
-		}
+
+	opts.Mounts = append(opts.Mounts, containerruntime.Mount{Source: volName, Target: containerWorkspaceDir})
+	opts.Mounts = append(opts.Mounts, b.cli.SocketMounts()...)
+	opts.Mounts = append(opts.Mounts, containerruntime.Mount{Source: homeVol, Target: homeWorkDir})
+
+	// Sort the mounts so that the args are stable.
+	names := make([]string, 0, len(volMounts))
+	for name := range volMounts {
+		names = append(names, name)
 	}
-	sb.WriteString(" --env " + homeEnv)
-
-	// User environment variables come after any defaults.
-	// This allows overriding the HOME environment variable for a step.
-	// NB: this has the assumption that the underlying runtime handles the case of duplicated
-	// environment variables by only keeping the last specified.
-	for _, env := range envs {
-		sb.WriteString(" --env " + env)
+	sort.Strings(names)
+	for _, name := range names {
+		opts.Mounts = append(opts.Mounts, containerruntime.Mount{Source: name, Target: volMounts[name]})
 	}
 
 	if !disableWorkDirOverride {
-		sb.WriteString(" --workdir " + normalizeWorkDir(workDir))
+		opts.WorkingDir = normalizeWorkDir(workDir)
 	}
-	sb.WriteString(" " + cmd)
-
-	args = append(args, sb.String())
-	return args
+	return opts
 }
 
-// getDockerRunArgsForStep populates the args for running a Docker container for the step.
+// getDockerRunArgsForStep populates the args for running a container for the step with the Builder's CLI.
 func (b *Builder) getDockerRunArgsForStep(
 	volName string,
 	stepWorkDir string,
 	step *graph.Step,
 	entrypoint string,
 	cmd string) []string {
-	// Run user commands from a shell instance in order to mirror the shell's field splitting algorithms,
-	// so we don't have to write our own argv parser for exec.Command.
+	return b.cli.RunArgs(b.getRunOptionsForStep(volName, stepWorkDir, step, entrypoint, cmd))
+}
+
+// getRunOptionsForStep populates the options for running a container for the step.
+func (b *Builder) getRunOptionsForStep(
+	volName string,
+	stepWorkDir string,
+	step *graph.Step,
+	entrypoint string,
+	cmd string) *containerruntime.RunOptions {
 	if runtime.GOOS == util.WindowsOS && step.Isolation == "" && !step.IsBuildStep() {
 		// Use hyperv isolation for non-build steps.
 		// Use default isolation for build step to improve performance. It assumes the docker-cli image is compatible with the host os.
@@ -138,7 +141,7 @@ func (b *Builder) getDockerRunArgsForStep(
 		volMounts[mount.Name] = mount.MountPath
 	}
 
-	return b.getDockerRunArgs(
+	return b.getRunOptions(
 		volMounts,
 		volName,
 		stepWorkDir,
@@ -173,6 +176,7 @@ func (b *Builder) scrapeDependencies(
 	containerName := fmt.Sprintf("acb_dep_scanner_%s", uuid.New())
 
 	args, censoredArgs, err := getScanArgs(
+		b.cli,
 		containerName,
 		volName,
 		containerWorkspaceDir,
@@ -205,6 +209,7 @@ func (b *Builder) scrapeDependencies(
 }
 
 func getScanArgs(
+	cli *containerruntime.CLIConfig,
 	containerName string,
 	volName string,
 	containerWorkspaceDir string,
@@ -217,7 +222,7 @@ func getScanArgs(
 	sourceContext string,
 	credentials []*graph.RegistryCredential) ([]string, []string, error) {
 	args := []string{
-		"docker",
+		cli.Binary,
 		"run",
 		"--rm",
 		"--name", containerName,
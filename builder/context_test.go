@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/containerruntime"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/util"
 )
@@ -79,7 +80,7 @@ func TestGetImageDependencies(t *testing.T) {
 }
 
 func TestGetBuildDockerRunArgs(t *testing.T) {
-	builder := &Builder{}
+	builder := &Builder{cli: containerruntime.DockerCLI}
 	actualCmds := builder.getDockerRunArgsForStep("volName", "stepWorkDir", &graph.Step{ID: "id", Build: "-f Dockerfile .", Envs: []string{"foo=bar", "HOME=qux"}}, "", "docker build -f Dockerfile .")
 
 	var expectedCmds []string
@@ -103,8 +104,44 @@ func TestGetBuildDockerRunArgs(t *testing.T) {
 	}
 }
 
+func TestGetRunArgsPerRuntime(t *testing.T) {
+	if runtime.GOOS == util.WindowsOS {
+		t.Skip("only the docker CLI is supported on Windows")
+	}
+	tests := []struct {
+		cli      *containerruntime.CLIConfig
+		expected string
+	}{
+		{
+			containerruntime.DockerCLI,
+			"docker run --rm --name id --volume volName:/workspace --volume /var/run/docker.sock:/var/run/docker.sock --volume home:/acb/home --env HOME=/acb/home --workdir /workspace/stepWorkDir docker build .",
+		},
+		{
+			containerruntime.PodmanCLI,
+			"podman run --rm --name id --volume volName:/workspace --volume /run/podman/podman.sock:/var/run/docker.sock --volume home:/acb/home --env HOME=/acb/home --workdir /workspace/stepWorkDir docker build .",
+		},
+		{
+			containerruntime.NerdctlCLI,
+			"nerdctl run --rm --name id --volume volName:/workspace --volume home:/acb/home --env HOME=/acb/home --workdir /workspace/stepWorkDir docker build .",
+		},
+		{
+			containerruntime.NerdctlCLI.WithSocket("/run/docker.sock"),
+			"nerdctl run --rm --name id --volume volName:/workspace --volume /run/docker.sock:/var/run/docker.sock --volume home:/acb/home --env HOME=/acb/home --workdir /workspace/stepWorkDir docker build .",
+		},
+	}
+
+	for _, test := range tests {
+		builder := &Builder{cli: test.cli}
+		actual := builder.getDockerRunArgsForStep("volName", "stepWorkDir", &graph.Step{ID: "id", Build: "."}, "", "docker build .")
+		expected := []string{"/bin/sh", "-c", test.expected}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("invalid %s run args, expected %v but got %v", test.cli.Name, expected, actual)
+		}
+	}
+}
+
 func TestGetNonBuildDockerRunArgs(t *testing.T) {
-	builder := &Builder{}
+	builder := &Builder{cli: containerruntime.DockerCLI}
 	actualCmds := builder.getDockerRunArgsForStep("volName", "stepWorkDir", &graph.Step{ID: "id", Envs: []string{"foo=bar"}}, "", "hello-world")
 
 	var expectedCmds []string
@@ -170,6 +207,7 @@ func TestGetScanArgs(t *testing.T) {
 
 	for _, test := range tests {
 		args, _, err := getScanArgs(
+			containerruntime.DockerCLI,
 			test.containerName,
 			test.volName,
 			test.containerWorkspaceDir,
@@ -10,20 +10,22 @@ import (
 	"log"
 	"strings"
 
+	"github.com/Azure/acr-builder/pkg/containerruntime"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/pkg/procmanager"
-	"github.com/Azure/acr-builder/util"
 	"github.com/pkg/errors"
 )
 
 type dockerStoreDigest struct {
 	procManager *procmanager.ProcManager
+	cli         *containerruntime.CLIConfig
 	debug       bool
 }
 
-func newDockerStoreDigest(procManager *procmanager.ProcManager, debug bool) *dockerStoreDigest {
+func newDockerStoreDigest(procManager *procmanager.ProcManager, cli *containerruntime.CLIConfig, debug bool) *dockerStoreDigest {
 	return &dockerStoreDigest{
 		procManager: procManager,
+		cli:         cli,
 		debug:       debug,
 	}
 }
@@ -43,22 +45,21 @@ func (d *dockerStoreDigest) PopulateDigest(ctx context.Context, reference *image
 	if reference.Reference == NoBaseImageSpecifierLatest {
 		return nil
 	}
-	args := []string{
-		"docker",
-		"run",
-		"--rm",
-
+	args := []string{d.cli.Binary, "run", "--rm"}
+	for _, mount := range d.cli.SocketMounts() {
+		args = append(args, "--volume", mount.Source+":"+mount.Target)
+	}
+	args = append(args,
 		// Mount home
-		"--volume", util.DockerSocketVolumeMapping,
-		"--volume", homeVol + ":" + homeWorkDir,
+		"--volume", homeVol+":"+homeWorkDir,
 		"--env", homeEnv,
 
-		"docker",
+		dockerCLIImageName,
 		"inspect",
 		"--format",
 		"\"{{json .RepoDigests}}\"",
 		reference.Reference,
-	}
+	)
 	if d.debug {
 		log.Printf("query digest args: %v\n", args)
 	}
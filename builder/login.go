@@ -7,9 +7,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/secretmgmt"
 	"github.com/Azure/acr-builder/util"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -22,17 +25,20 @@ const (
 // dockerLogin performs a docker login
 func (b *Builder) dockerLogin(ctx context.Context, registry string, user string, pw string) error {
 	args := []string{
-		"docker",
+		b.cli.Binary,
 		"run",
 		"--name", fmt.Sprintf("acb_docker_login_%s", uuid.New()),
 		"--rm",
 
 		// Interactive mode for --password-stdin
 		"-i",
-
+	}
+	for _, mount := range b.cli.SocketMounts() {
+		args = append(args, "--volume", mount.Source+":"+mount.Target)
+	}
+	args = append(args,
 		// Mount home
-		"--volume", util.DockerSocketVolumeMapping,
-		"--volume", homeVol + ":" + homeWorkDir,
+		"--volume", homeVol+":"+homeWorkDir,
 		"--env", homeEnv,
 
 		dockerCLIImageName,
@@ -40,7 +46,7 @@ func (b *Builder) dockerLogin(ctx context.Context, registry string, user string,
 		"--username", user,
 		"--password-stdin",
 		registry,
-	}
+	)
 
 	stdIn := strings.NewReader(pw + "\n")
 
@@ -49,7 +55,8 @@ func (b *Builder) dockerLogin(ctx context.Context, registry string, user string,
 		return errors.Wrap(err, fmt.Sprintf("failed to set docker credentials: %s", buf.String()))
 	}
 
-	return nil
+	// Runtimes which pull and push images themselves rather than from the docker CLI containers need the credentials too.
+	return b.runtime.Login(ctx, registry, user, pw)
 }
 
 // dockerLoginWithRetries performs a Docker login with retries.
@@ -66,3 +73,41 @@ func (b *Builder) dockerLoginWithRetries(ctx context.Context, registry string, u
 
 	return nil
 }
+
+// refreshRegistryCredentials resolves the Task's registry credentials which expired in the Builder's secret cache again,
+// e.g. MSI-derived refresh tokens, and logs in to their registries with the fresh credentials.
+func (b *Builder) refreshRegistryCredentials(ctx context.Context, task *graph.Task) error {
+	if b.secretCache == nil {
+		return nil
+	}
+	b.credentialsMu.Lock()
+	defer b.credentialsMu.Unlock()
+	for registry, cred := range task.RegistryLoginCredentials {
+		if cred == nil {
+			continue
+		}
+		var expired []*secretmgmt.Secret
+		for _, secret := range []*secretmgmt.Secret{cred.Username, cred.Password} {
+			if b.secretCache.Expired(secret) {
+				expired = append(expired, secret)
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		log.Printf("Refreshing the expired credentials of registry: %s\n", registry)
+		if err := b.secretCache.Refresh(ctx, expired); err != nil {
+			return errors.Wrapf(err, "failed to refresh the credentials of registry: %s", registry)
+		}
+		b.redactor.Add(cred.Password.ResolvedValue)
+		loginCtx, cancel := context.WithTimeout(ctx, time.Duration(loginTimeoutInSec)*time.Second)
+		err := b.dockerLoginWithRetries(loginCtx, registry, cred.Username.ResolvedValue, cred.Password.ResolvedValue, 0)
+		cancel()
+		if err != nil {
+			return err
+		}
+		log.Printf("Successfully logged into %s with the refreshed credentials\n", registry)
+	}
+	return nil
+}
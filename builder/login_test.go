@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/secretmgmt"
+)
+
+// rotatingProvider resolves secrets to a new token every time.
+type rotatingProvider struct {
+	count int
+}
+
+func (p *rotatingProvider) Validate(*secretmgmt.Secret) error {
+	return nil
+}
+
+func (p *rotatingProvider) GetValue(context.Context, *secretmgmt.Secret) (string, error) {
+	p.count++
+	return fmt.Sprintf("token-%d", p.count), nil
+}
+
+func TestRefreshRegistryCredentials(t *testing.T) {
+	provider := &rotatingProvider{}
+	secretmgmt.RegisterVaultProvider("rotating", provider)
+
+	password := &secretmgmt.Secret{ID: "registry", Provider: "rotating", ResolvedValue: "token-0", ResolvedTime: time.Now()}
+	task := &graph.Task{
+		RegistryLoginCredentials: graph.RegistryLoginCredentials{
+			"foo.azurecr.io": &graph.ResolvedRegistryCred{
+				Username: &secretmgmt.Secret{ID: "registry", ResolvedValue: "00000000-0000-0000-0000-000000000000"},
+				Password: password,
+			},
+		},
+	}
+
+	b := NewBuilder(procmanager.NewProcManager(true), false, "home")
+	// Without a secret cache, credentials are never refreshed.
+	if err := b.refreshRegistryCredentials(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resolver, err := secretmgmt.NewSecretResolver(nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetSecretCache(secretmgmt.NewSecretCache(resolver, time.Hour))
+	if err := b.refreshRegistryCredentials(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if password.ResolvedValue != "token-0" {
+		t.Fatalf("expected the credentials not to be refreshed before they expire, got %s", password.ResolvedValue)
+	}
+
+	password.ResolvedTime = time.Now().Add(-2 * time.Hour)
+	if err := b.refreshRegistryCredentials(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if password.ResolvedValue != "token-1" {
+		t.Errorf("expected the expired credentials to be refreshed, got %s", password.ResolvedValue)
+	}
+	if redacted := b.redactor.Redact("login with token-1"); !strings.Contains(redacted, "***") {
+		t.Errorf("expected the refreshed credentials to be redacted, got %s", redacted)
+	}
+}
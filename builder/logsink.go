@@ -0,0 +1,316 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Azure/acr-builder/pkg/redact"
+)
+
+// LogStream identifies the stream a line of output was written to.
+type LogStream string
+
+// The streams of a step's output.
+const (
+	Stdout LogStream = "stdout"
+	Stderr LogStream = "stderr"
+)
+
+// LogLine is a line of the output of a step.
+type LogLine struct {
+	StepID string
+	// Attempt is the 0-based index of the step's attempt, counting retries and repetitions.
+	Attempt int
+	Stream  LogStream
+	// Text is the line without its line ending.
+	Text string
+}
+
+// LogSink receives the output of the steps of a run. Steps run in parallel,
+// so implementations must be safe for concurrent use.
+type LogSink interface {
+	// WriteLine writes a line of the output of a step.
+	WriteLine(line LogLine) error
+
+	// EndAttempt is called once an attempt of a step has completed and all of its output has been written.
+	EndAttempt(stepID string, attempt int) error
+
+	// Close releases the sink's resources once the run has completed.
+	Close() error
+}
+
+// ansiColors are the colors step ID prefixes are written in.
+var ansiColors = []string{"\x1b[36m", "\x1b[32m", "\x1b[33m", "\x1b[35m", "\x1b[34m", "\x1b[31m"}
+
+const ansiReset = "\x1b[0m"
+
+// maxLineLength is the length of output without a line ending, e.g. progress bars or binary output,
+// past which it's written as a line instead of being buffered until the line is completed.
+const maxLineLength = 64 * 1024
+
+// consoleLogSink writes the output of steps to the console.
+type consoleLogSink struct {
+	mu     sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+	prefix bool
+	color  bool
+}
+
+// NewConsoleLogSink creates a LogSink which writes the output of steps to stdout and stderr.
+// If prefix is true, lines are prefixed with the ID of their step, which is colorized if color is true.
+func NewConsoleLogSink(stdout io.Writer, stderr io.Writer, prefix bool, color bool) LogSink {
+	return &consoleLogSink{stdout: stdout, stderr: stderr, prefix: prefix, color: color}
+}
+
+// WriteLine implements LogSink.
+func (s *consoleLogSink) WriteLine(line LogLine) error {
+	w := s.stdout
+	if line.Stream == Stderr {
+		w = s.stderr
+	}
+	text := line.Text + "\n"
+	if s.prefix && s.color {
+		text = fmt.Sprintf("%s[%s]%s %s", stepColor(line.StepID), line.StepID, ansiReset, text)
+	} else if s.prefix {
+		text = fmt.Sprintf("[%s] %s", line.StepID, text)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(w, text)
+	return err
+}
+
+// EndAttempt implements LogSink.
+func (s *consoleLogSink) EndAttempt(string, int) error {
+	return nil
+}
+
+// Close implements LogSink.
+func (s *consoleLogSink) Close() error {
+	return nil
+}
+
+// stepColor returns the color of the step's prefix, which is the same for every line of the step.
+func stepColor(stepID string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(stepID))
+	return ansiColors[h.Sum32()%uint32(len(ansiColors))]
+}
+
+// fileLogSink writes the output of each attempt of a step to its own file.
+type fileLogSink struct {
+	mu    sync.Mutex
+	dir   string
+	files map[string]*os.File
+}
+
+// NewFileLogSink creates a LogSink which writes the output of each attempt of a step
+// to <dir>/<step ID>/attempt-<attempt>.log.
+func NewFileLogSink(dir string) (LogSink, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &fileLogSink{dir: dir, files: make(map[string]*os.File)}, nil
+}
+
+// GetLogFilePath returns the path of the log file of a step's attempt written by the LogSink created by NewFileLogSink.
+func GetLogFilePath(dir string, stepID string, attempt int) string {
+	// Step IDs can't contain spaces, but they aren't restricted to valid file names.
+	name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(stepID)
+	return filepath.Join(dir, name, fmt.Sprintf("attempt-%d.log", attempt))
+}
+
+// WriteLine implements LogSink.
+func (s *fileLogSink) WriteLine(line LogLine) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := GetLogFilePath(s.dir, line.StepID, line.Attempt)
+	f, ok := s.files[path]
+	if !ok {
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			return err
+		}
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return err
+		}
+		s.files[path] = f
+	}
+	_, err := io.WriteString(f, line.Text+"\n")
+	return err
+}
+
+// EndAttempt implements LogSink.
+func (s *fileLogSink) EndAttempt(stepID string, attempt int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := GetLogFilePath(s.dir, stepID, attempt)
+	f, ok := s.files[path]
+	if !ok {
+		return nil
+	}
+	delete(s.files, path)
+	return f.Close()
+}
+
+// Close implements LogSink.
+func (s *fileLogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for path, f := range s.files {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(s.files, path)
+	}
+	return errors.Join(errs...)
+}
+
+// multiLogSink writes the output of steps to multiple sinks.
+type multiLogSink []LogSink
+
+// NewMultiLogSink creates a LogSink which writes the output of steps to every specified sink.
+func NewMultiLogSink(sinks ...LogSink) LogSink {
+	return multiLogSink(sinks)
+}
+
+// WriteLine implements LogSink.
+func (m multiLogSink) WriteLine(line LogLine) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.WriteLine(line); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EndAttempt implements LogSink.
+func (m multiLogSink) EndAttempt(stepID string, attempt int) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.EndAttempt(stepID, attempt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close implements LogSink.
+func (m multiLogSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stepOutput splits the output of a step's attempts into lines and writes them to a LogSink.
+type stepOutput struct {
+	mu       sync.Mutex
+	sink     LogSink
+	redactor *redact.Redactor
+	stepID   string
+	attempt  int
+	partial  map[LogStream][]byte
+	failed   bool
+}
+
+// newStepOutput creates the output of the step, written to the sink with the secrets known to the redactor masked.
+func newStepOutput(sink LogSink, redactor *redact.Redactor, stepID string) *stepOutput {
+	return &stepOutput{sink: sink, redactor: redactor, stepID: stepID, partial: make(map[LogStream][]byte)}
+}
+
+// writer returns a writer for the specified stream of the step's output.
+func (o *stepOutput) writer(stream LogStream) io.Writer {
+	return &stepOutputWriter{output: o, stream: stream}
+}
+
+// write writes the complete lines of p to the sink, keeping the last incomplete line until it's completed
+// or reaches maxLineLength.
+func (o *stepOutput) write(stream LogStream, p []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	data := append(o.partial[stream], p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		o.writeLine(stream, data[:i])
+		data = data[i+1:]
+	}
+	for len(data) >= maxLineLength {
+		o.writeLine(stream, data[:maxLineLength])
+		data = data[maxLineLength:]
+	}
+	o.partial[stream] = append([]byte(nil), data...)
+}
+
+// writeLine writes a line to the sink. Failing to write the output doesn't fail the step, it's only logged once.
+func (o *stepOutput) writeLine(stream LogStream, line []byte) {
+	err := o.sink.WriteLine(LogLine{
+		StepID:  o.stepID,
+		Attempt: o.attempt,
+		Stream:  stream,
+		Text:    o.redactor.Redact(string(bytes.TrimSuffix(line, []byte("\r")))),
+	})
+	if err != nil && !o.failed {
+		o.failed = true
+		log.Printf("Failed to write the output of step ID: %s, err: %v\n", o.stepID, err)
+	}
+}
+
+// flush writes the incomplete lines of the current attempt.
+func (o *stepOutput) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.flushLocked()
+}
+
+func (o *stepOutput) flushLocked() {
+	for _, stream := range []LogStream{Stdout, Stderr} {
+		if len(o.partial[stream]) > 0 {
+			o.writeLine(stream, o.partial[stream])
+			o.partial[stream] = nil
+		}
+	}
+}
+
+// endAttempt writes the incomplete lines of the current attempt and ends it.
+func (o *stepOutput) endAttempt() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.flushLocked()
+	if err := o.sink.EndAttempt(o.stepID, o.attempt); err != nil {
+		log.Printf("Failed to end attempt %d of step ID: %s, err: %v\n", o.attempt, o.stepID, err)
+	}
+	o.attempt++
+}
+
+// stepOutputWriter is an io.Writer for a stream of a step's output.
+type stepOutputWriter struct {
+	output *stepOutput
+	stream LogStream
+}
+
+// Write implements io.Writer.
+func (w *stepOutputWriter) Write(p []byte) (int, error) {
+	w.output.write(w.stream, p)
+	return len(p), nil
+}
@@ -0,0 +1,132 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/pkg/redact"
+)
+
+func TestConsoleLogSink(t *testing.T) {
+	tests := []struct {
+		prefix   bool
+		color    bool
+		expected string
+	}{
+		{false, false, "hello\n"},
+		{true, false, "[build] hello\n"},
+		{true, true, stepColor("build") + "[build]" + ansiReset + " hello\n"},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		sink := NewConsoleLogSink(&stdout, &stderr, test.prefix, test.color)
+		if err := sink.WriteLine(LogLine{StepID: "build", Stream: Stdout, Text: "hello"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := sink.WriteLine(LogLine{StepID: "build", Stream: Stderr, Text: "hello"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stdout.String() != test.expected || stderr.String() != test.expected {
+			t.Errorf("expected %q but got %q and %q", test.expected, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestStepOutput(t *testing.T) {
+	dir := t.TempDir()
+	fileSink, err := NewFileLogSink(dir)
+	if err != nil {
+		t.Fatalf("failed to create the file sink: %v", err)
+	}
+	var stdout bytes.Buffer
+	sink := NewMultiLogSink(NewConsoleLogSink(&stdout, &stdout, true, false), fileSink)
+
+	output := newStepOutput(sink, nil, "build")
+	_, _ = output.writer(Stdout).Write([]byte("first\r\nsec"))
+	_, _ = output.writer(Stdout).Write([]byte("ond\nincomplete"))
+	output.endAttempt()
+	_, _ = output.writer(Stderr).Write([]byte("retried\n"))
+	output.endAttempt()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := "[build] first\n[build] second\n[build] incomplete\n[build] retried\n"; stdout.String() != expected {
+		t.Errorf("expected %q but got %q", expected, stdout.String())
+	}
+	for attempt, expected := range []string{"first\nsecond\nincomplete\n", "retried\n"} {
+		data, err := os.ReadFile(GetLogFilePath(dir, "build", attempt))
+		if err != nil {
+			t.Fatalf("failed to read the log of attempt %d: %v", attempt, err)
+		}
+		if string(data) != expected {
+			t.Errorf("expected attempt %d to log %q but got %q", attempt, expected, string(data))
+		}
+	}
+}
+
+func TestStepOutputLongLines(t *testing.T) {
+	var stdout bytes.Buffer
+	output := newStepOutput(NewConsoleLogSink(&stdout, &stdout, false, false), nil, "build")
+
+	// Output without line endings is written once it reaches the maximum line length.
+	progress := bytes.Repeat([]byte("#"), maxLineLength/2+1)
+	_, _ = output.writer(Stdout).Write(progress)
+	if stdout.Len() != 0 {
+		t.Fatalf("expected the incomplete line to be buffered but got %d bytes", stdout.Len())
+	}
+	_, _ = output.writer(Stdout).Write(progress)
+	if expected := maxLineLength + 1; stdout.Len() != expected {
+		t.Errorf("expected %d bytes to be written but got %d", expected, stdout.Len())
+	}
+	if len(output.partial[Stdout]) != 2 {
+		t.Errorf("expected the remaining 2 bytes to be buffered but got %d", len(output.partial[Stdout]))
+	}
+}
+
+func TestStepOutputRedactsSecrets(t *testing.T) {
+	var stdout bytes.Buffer
+	redactor := redact.NewRedactor()
+	output := newStepOutput(NewConsoleLogSink(&stdout, &stdout, false, false), redactor, "build")
+
+	// Secrets added while the step runs are masked, even when split across writes.
+	redactor.Add("s3cr3t")
+	_, _ = output.writer(Stdout).Write([]byte("token: s3c"))
+	_, _ = output.writer(Stdout).Write([]byte("r3t\nencoded: czNjcjN0\n"))
+	output.endAttempt()
+
+	if expected := "token: ***\nencoded: ***\n"; stdout.String() != expected {
+		t.Errorf("expected %q but got %q", expected, stdout.String())
+	}
+}
+
+func TestRunTask_LogSink(t *testing.T) {
+	useFakeDocker(t)
+	dir := t.TempDir()
+	fileSink, err := NewFileLogSink(dir)
+	if err != nil {
+		t.Fatalf("failed to create the file sink: %v", err)
+	}
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	builder.SetLogSink(fileSink)
+	task := newTestTask(t, false, &graph.Step{ID: "a", Cmd: "version", Repeat: 1})
+	if err := builder.RunTask(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		data, err := os.ReadFile(GetLogFilePath(dir, "a", attempt))
+		if err != nil {
+			t.Fatalf("failed to read the log of attempt %d: %v", attempt, err)
+		}
+		if string(data) != "version: 1.2.3\n" {
+			t.Errorf("unexpected log of attempt %d: %q", attempt, string(data))
+		}
+	}
+}
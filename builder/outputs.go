@@ -0,0 +1,45 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/pkg/errors"
+)
+
+// outputFileEnvVar is the environment variable containing the path of a step's outputs file.
+const outputFileEnvVar = "ACB_OUTPUT"
+
+// getOutputFilePath returns the path of the step's outputs file inside of its container.
+func getOutputFilePath(stepID string) string {
+	return fmt.Sprintf("%s%c.acb_outputs_%s", containerWorkspaceDir, containerPathSeparator, stepID)
+}
+
+// collectOutputs collects the step's outputs from its standard output and its outputs file.
+func (b *Builder) collectOutputs(ctx context.Context, step *graph.Step, stdout string) error {
+	var outputFile string
+	if step.HasOutputFile() {
+		var buf bytes.Buffer
+		args := getReadOutputFileArgs(b.cli, b.workspaceDir, getOutputFilePath(step.ID))
+		if b.debug {
+			log.Printf("Read outputs file args: %v\n", args)
+		}
+		if err := b.procManager.Run(ctx, args, nil, &buf, os.Stderr, ""); err != nil {
+			return errors.Wrapf(err, "failed to read the outputs file of step ID: %s", step.ID)
+		}
+		outputFile = buf.String()
+	}
+
+	if err := step.CollectOutputs(stdout, outputFile); err != nil {
+		return err
+	}
+	log.Printf("Collected %d output(s) for step ID: %s\n", len(step.OutputValues), step.ID)
+	return nil
+}
@@ -0,0 +1,29 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+//go:build linux || darwin
+
+package builder
+
+import (
+	"fmt"
+
+	"github.com/Azure/acr-builder/pkg/containerruntime"
+	"github.com/google/uuid"
+)
+
+const containerPathSeparator = '/'
+
+// getReadOutputFileArgs returns the args to print and remove an outputs file from the workspace volume.
+func getReadOutputFileArgs(cli *containerruntime.CLIConfig, volName string, file string) []string {
+	return []string{
+		cli.Binary,
+		"run",
+		"--name", fmt.Sprintf("acb_read_outputs_%s", uuid.New()),
+		"--rm",
+		"--volume", volName + ":" + containerWorkspaceDir,
+		"--entrypoint", "bash",
+		configImageName,
+		"-c", fmt.Sprintf("if [ -f '%[1]s' ]; then cat '%[1]s' && rm -f '%[1]s'; fi", file),
+	}
+}
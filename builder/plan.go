@@ -0,0 +1,246 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/redact"
+)
+
+// Plan describes what running a Task would do, without running it.
+type Plan struct {
+	// Task is the rendered task file, if any.
+	Task string `json:"task,omitempty"`
+
+	// Networks are the args of the commands creating the Task's networks.
+	Networks [][]string `json:"networks"`
+
+	Volumes []*PlannedVolume `json:"volumes"`
+
+	// Waves are the IDs of the steps in topological order: every step only depends on steps of previous waves,
+	// so the steps of a wave can run in parallel. Finally steps are part of the last waves.
+	Waves [][]string `json:"waves"`
+
+	Steps []*PlannedStep `json:"steps"`
+}
+
+// PlannedVolume is a volume which would be created and populated before running the steps.
+type PlannedVolume struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"`
+}
+
+// PlannedStep describes how a step would run.
+type PlannedStep struct {
+	ID        string   `json:"id"`
+	Type      string   `json:"type"`
+	Wave      int      `json:"wave"`
+	Finally   bool     `json:"finally,omitempty"`
+	DependsOn []string `json:"dependsOn"`
+	If        string   `json:"if,omitempty"`
+
+	// Args are the args running the step's container, for cmd and build steps.
+	Args []string `json:"args,omitempty"`
+
+	// Push are the images pushed by push steps.
+	Push []string `json:"push,omitempty"`
+}
+
+// NewPlan creates the Plan of running the Task. rendered is the rendered task file, if any.
+// The values of the Task's secrets and registry credentials are redacted.
+func (b *Builder) NewPlan(task *graph.Task, rendered string) *Plan {
+	plan := &Plan{
+		Task:     rendered,
+		Networks: [][]string{},
+		Volumes:  []*PlannedVolume{},
+		Waves:    [][]string{},
+		Steps:    []*PlannedStep{},
+	}
+
+	for _, network := range task.Networks {
+		if !network.SkipCreation {
+			plan.Networks = append(plan.Networks, b.cli.NetworkCreateArgs(network.Options()))
+		}
+	}
+	for _, v := range task.Volumes {
+		files := make([]string, 0, len(v.Source.Secret))
+		for name := range v.Source.Secret {
+			files = append(files, name)
+		}
+		sort.Strings(files)
+		plan.Volumes = append(plan.Volumes, &PlannedVolume{Name: v.Name, Files: files})
+	}
+
+	b.addPlannedSteps(plan, task.Dag, task.Steps, false)
+	b.addPlannedSteps(plan, task.FinallyDag, task.Finally, true)
+
+	redactor := b.redactor
+	if redactor == nil {
+		redactor = redact.NewRedactor()
+	}
+	redactor.Add(getSecretValues(task)...)
+	plan.redact(redactor)
+	return plan
+}
+
+// addPlannedSteps adds the steps of the Dag to the plan, in waves following the plan's existing waves.
+func (b *Builder) addPlannedSteps(plan *Plan, dag *graph.Dag, steps []*graph.Step, finally bool) {
+	if len(steps) == 0 {
+		return
+	}
+	firstWave := len(plan.Waves)
+	waves := make(map[string]int, len(steps))
+	var waveOf func(id string) int
+	waveOf = func(id string) int {
+		if wave, ok := waves[id]; ok {
+			return wave
+		}
+		wave := 0
+		if dag != nil {
+			for _, dep := range dag.Dependencies(id) {
+				if w := waveOf(dep) + 1; w > wave {
+					wave = w
+				}
+			}
+		}
+		waves[id] = wave
+		return wave
+	}
+
+	for _, step := range steps {
+		planned := &PlannedStep{
+			ID:        step.ID,
+			Type:      step.Type(),
+			Wave:      firstWave + waveOf(step.ID),
+			Finally:   finally,
+			DependsOn: []string{},
+			If:        step.If,
+		}
+		if dag != nil {
+			planned.DependsOn = append(planned.DependsOn, dag.Dependencies(step.ID)...)
+		}
+		if step.IsPushStep() {
+			planned.Push = step.Push
+		} else {
+			// The args are computed on a copy, since computing them updates the step with the settings it runs with.
+			s := *step
+			s.Envs = append([]string(nil), step.Envs...)
+			planned.Args = b.cli.RunArgs(b.getStepRunOptions(&s))
+		}
+		for len(plan.Waves) <= planned.Wave {
+			plan.Waves = append(plan.Waves, []string{})
+		}
+		plan.Waves[planned.Wave] = append(plan.Waves[planned.Wave], step.ID)
+		plan.Steps = append(plan.Steps, planned)
+	}
+}
+
+// getSecretValues returns the resolved values of the Task's secrets, registry credentials and secret volumes.
+func getSecretValues(task *graph.Task) []string {
+	var values []string
+	for _, secret := range task.Secrets {
+		if secret != nil && secret.ResolvedValue != "" {
+			values = append(values, secret.ResolvedValue)
+		}
+	}
+	for _, cred := range task.RegistryLoginCredentials {
+		if cred != nil && cred.Password != nil && cred.Password.ResolvedValue != "" {
+			values = append(values, cred.Password.ResolvedValue)
+		}
+	}
+	for _, v := range task.Volumes {
+		if v == nil {
+			continue
+		}
+		for _, encoded := range v.Source.Secret {
+			// Secret volume values are base64 encoded, the encoded forms of secrets are masked along with their values.
+			if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(decoded) > 0 {
+				values = append(values, string(decoded))
+			}
+		}
+	}
+	return values
+}
+
+// redact masks the secret values in the plan.
+func (p *Plan) redact(redactor *redact.Redactor) {
+	p.Task = redactor.Redact(p.Task)
+	for _, step := range p.Steps {
+		if step.Args != nil {
+			step.Args = redactor.RedactArgs(step.Args)
+		}
+	}
+}
+
+// Print writes the Plan in a human readable format.
+func (p *Plan) Print(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("Execution plan\n")
+
+	if len(p.Networks) > 0 {
+		sb.WriteString("\nNetworks:\n")
+		for _, args := range p.Networks {
+			fmt.Fprintf(&sb, "  %s\n", formatArgs(args))
+		}
+	}
+	if len(p.Volumes) > 0 {
+		sb.WriteString("\nVolumes:\n")
+		for _, v := range p.Volumes {
+			fmt.Fprintf(&sb, "  %s (files: %s)\n", v.Name, strings.Join(v.Files, ", "))
+		}
+	}
+
+	steps := make(map[string]*PlannedStep, len(p.Steps))
+	for _, step := range p.Steps {
+		steps[step.ID] = step
+	}
+	for i, wave := range p.Waves {
+		fmt.Fprintf(&sb, "\nWave %d:\n", i)
+		for _, id := range wave {
+			step := steps[id]
+			fmt.Fprintf(&sb, "  Step ID: %s (%s)", step.ID, step.Type)
+			if step.Finally {
+				sb.WriteString(", finally")
+			}
+			if len(step.DependsOn) > 0 {
+				fmt.Fprintf(&sb, ", depends on: %s", strings.Join(step.DependsOn, ", "))
+			}
+			sb.WriteString("\n")
+			if step.If != "" {
+				fmt.Fprintf(&sb, "    if: %s\n", step.If)
+			}
+			if len(step.Args) > 0 {
+				fmt.Fprintf(&sb, "    %s\n", formatArgs(step.Args))
+			}
+			for _, img := range step.Push {
+				fmt.Fprintf(&sb, "    push %s\n", img)
+			}
+		}
+	}
+
+	if p.Task != "" {
+		fmt.Fprintf(&sb, "\nRendered task:\n%s\n", strings.TrimRight(p.Task, "\n"))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// formatArgs formats args as a command line, quoting the args containing whitespace or quotes.
+func formatArgs(args []string) string {
+	formatted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			formatted[i] = fmt.Sprintf("%q", arg)
+		} else {
+			formatted[i] = arg
+		}
+	}
+	return strings.Join(formatted, " ")
+}
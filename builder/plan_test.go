@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/secretmgmt"
+)
+
+func TestNewPlan(t *testing.T) {
+	build := &graph.Step{ID: "build", Build: "-t app ."}
+	test := &graph.Step{ID: "test", Cmd: "app test", Envs: []string{"TOKEN=s3cr3t"}}
+	lint := &graph.Step{ID: "lint", Cmd: "golint", When: []string{graph.ImmediateExecutionToken}}
+	push := &graph.Step{ID: "push", Push: []string{"app"}, When: []string{"test", "lint"}}
+	task := newTestTask(t, false, build, test, lint, push)
+	task.Secrets = []*secretmgmt.Secret{{ID: "token", ResolvedValue: "s3cr3t"}}
+
+	b := NewBuilder(procmanager.NewProcManager(true), false, "home")
+	plan := b.NewPlan(task, "steps:\n  - cmd: app test --token s3cr3t\n")
+
+	expectedWaves := [][]string{{"build", "lint"}, {"test"}, {"push"}}
+	if len(plan.Waves) != len(expectedWaves) {
+		t.Fatalf("expected %d waves but got %v", len(expectedWaves), plan.Waves)
+	}
+	for i, wave := range expectedWaves {
+		if strings.Join(plan.Waves[i], ",") != strings.Join(wave, ",") {
+			t.Errorf("expected wave %d to be %v but got %v", i, wave, plan.Waves[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := plan.Print(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "s3cr3t") {
+		t.Errorf("expected the secret to be redacted but got:\n%s", out)
+	}
+	for _, expected := range []string{
+		"--volume home:/workspace",
+		"--env TOKEN=*** --workdir /workspace app test",
+		"docker build -t app .",
+		"Step ID: push (push), depends on: test, lint",
+		"push app",
+		"--token ***",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected the plan to contain %q but got:\n%s", expected, out)
+		}
+	}
+
+	// Planning doesn't update the steps.
+	if len(test.Envs) != 1 || strings.Contains(build.Build, "DOCKER_BUILDKIT") {
+		t.Errorf("expected the steps not to be updated: %v", test.Envs)
+	}
+}
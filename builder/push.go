@@ -6,45 +6,53 @@ package builder
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"time"
 
+	"github.com/Azure/acr-builder/pkg/execution"
+	"github.com/Azure/acr-builder/pkg/procmanager"
 	"github.com/Azure/acr-builder/util"
-	"github.com/google/uuid"
 )
 
 const (
 	maxPushRetries = 3
 )
 
-func (b *Builder) pushWithRetries(ctx context.Context, images []string) error {
+// pushWithRetries pushes the images with retries, writing the output of the pushes to stdout and stderr.
+// If onAttempt is specified, it's invoked after each push attempt.
+func (b *Builder) pushWithRetries(ctx context.Context, images []string, stdout io.Writer, stderr io.Writer, onAttempt procmanager.AttemptFunc) error {
 	if len(images) == 0 {
 		return nil
 	}
 
 	for _, img := range images {
-		args := []string{
-			"docker",
-			"run",
-			"--name", fmt.Sprintf("acb_docker_push_%s", uuid.New()),
-			"--rm",
-
-			// Mount home
-			"--volume", util.DockerSocketVolumeMapping,
-			"--volume", homeVol + ":" + homeWorkDir,
-			"--env", homeEnv,
-
-			dockerCLIImageName,
-			"push",
-			img,
-		}
-
 		attempt := 0
 		for attempt < maxPushRetries {
 			log.Printf("Pushing image: %s, attempt %d\n", img, attempt+1)
-			if err := b.procManager.Run(ctx, args, nil, os.Stdout, os.Stderr, ""); err != nil {
-				time.Sleep(util.GetExponentialBackoff(attempt))
+			startTime := time.Now()
+			err := b.runtime.Push(ctx, img, stdout, stderr)
+			if onAttempt != nil {
+				pushAttempt := execution.Attempt{
+					Retry:     attempt,
+					StartTime: startTime,
+					EndTime:   time.Now(),
+					ExitCode:  procmanager.ExitCode(err),
+				}
+				if err != nil {
+					pushAttempt.Error = err.Error()
+				}
+				onAttempt(pushAttempt)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(util.GetExponentialBackoff(attempt)):
+				}
 				attempt++
 			} else {
 				log.Printf("Successfully pushed image: %s\n", img)
@@ -0,0 +1,55 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/execution"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+)
+
+func TestPushWithRetries_Cancel(t *testing.T) {
+	useFakeDocker(t)
+	stops := filepath.Join(t.TempDir(), "stops")
+	t.Setenv("ACB_TEST_STOPS", stops)
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+
+	tests := []struct {
+		image            string
+		expectedAttempts int
+		expectedRemoval  bool
+	}{
+		// The cancellation interrupts the backoff after the failed attempt.
+		{"fail", 1, false},
+		// The cancellation interrupts the push, whose container must be removed.
+		{"slow", 1, true},
+	}
+	for _, test := range tests {
+		_ = os.Remove(stops)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		attempts := 0
+		onAttempt := func(execution.Attempt) { attempts++ }
+		err := builder.pushWithRetries(ctx, []string{test.image}, nil, nil, onAttempt)
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected the push to be cancelled but got: %v", test.image, err)
+		}
+		if attempts != test.expectedAttempts {
+			t.Errorf("%s: expected %d attempt(s) but got %d", test.image, test.expectedAttempts, attempts)
+		}
+		data, _ := os.ReadFile(stops)
+		if removed := strings.HasPrefix(string(data), "rm -f acb_docker_push_"); removed != test.expectedRemoval {
+			t.Errorf("%s: expected the removal of the push container to be %v but got %q", test.image, test.expectedRemoval, data)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/execution"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	// RunSucceeded is the status of a run which completed successfully.
+	RunSucceeded = "succeeded"
+
+	// RunFailed is the status of a run which failed.
+	RunFailed = "failed"
+
+	// RunCancelled is the status of a run which was cancelled, e.g. by a signal.
+	RunCancelled = "cancelled"
+)
+
+// RunReport is a machine-readable summary of a Task's execution.
+type RunReport struct {
+	TaskName     string                `json:"taskName"`
+	Status       string                `json:"status"`
+	Error        string                `json:"error,omitempty"`
+	Steps        []*StepReport         `json:"steps"`
+	Dependencies []*image.Dependencies `json:"dependencies"`
+	Timing       *TimingReport         `json:"timing"`
+}
+
+// StepReport describes the execution of a single Step.
+type StepReport struct {
+	ID           string              `json:"id"`
+	Type         string              `json:"type"`
+	Status       graph.StepStatus    `json:"status"`
+	SkipReason   string              `json:"skipReason,omitempty"`
+	StartTime    time.Time           `json:"startTime"`
+	EndTime      time.Time           `json:"endTime"`
+	ExitCode     int                 `json:"exitCode"`
+	Retries      int                 `json:"retries"`
+	Repeat       int                 `json:"repeat"`
+	Attempts     []execution.Attempt `json:"attempts"`
+	DependsOn    []string            `json:"dependsOn"`
+	PushedImages []*image.Reference  `json:"pushedImages,omitempty"`
+	Outputs      map[string]string   `json:"outputs,omitempty"`
+	MatrixGroup  string              `json:"matrixGroup,omitempty"`
+	Matrix       map[string]string   `json:"matrix,omitempty"`
+	Finally      bool                `json:"finally,omitempty"`
+	Resumed      bool                `json:"resumed,omitempty"`
+	CacheHit     bool                `json:"cacheHit,omitempty"`
+}
+
+// NewRunReport creates a RunReport for a Task which has been run.
+// runErr is the error returned by RunTask, if any.
+func NewRunReport(task *graph.Task, runErr error) *RunReport {
+	report := &RunReport{
+		TaskName:     task.TaskName,
+		Status:       RunSucceeded,
+		Steps:        []*StepReport{},
+		Dependencies: []*image.Dependencies{},
+		Timing:       NewTimingReport(task),
+	}
+	if runErr != nil {
+		report.Status = RunFailed
+		if errors.Is(runErr, context.Canceled) {
+			report.Status = RunCancelled
+		}
+		report.Error = runErr.Error()
+	}
+
+	for _, step := range task.Steps {
+		report.Dependencies = append(report.Dependencies, step.ImageDependencies...)
+	}
+
+	finally := make(map[string]bool, len(task.Finally))
+	for _, step := range task.Finally {
+		finally[step.ID] = true
+	}
+
+	for _, step := range task.AllSteps() {
+		stepReport := &StepReport{
+			ID:          step.ID,
+			Type:        step.Type(),
+			Status:      step.StepStatus,
+			SkipReason:  step.SkipReason,
+			StartTime:   step.StartTime,
+			EndTime:     step.EndTime,
+			ExitCode:    step.ExitCode,
+			Retries:     step.Retries,
+			Repeat:      step.Repeat,
+			Attempts:    step.Attempts,
+			DependsOn:   []string{},
+			Outputs:     step.OutputValues,
+			MatrixGroup: step.MatrixGroup(),
+			Matrix:      step.MatrixValues,
+			Finally:     finally[step.ID],
+			Resumed:     step.Resumed,
+			CacheHit:    step.CacheHit,
+		}
+		if stepReport.Attempts == nil {
+			stepReport.Attempts = []execution.Attempt{}
+		}
+		dag := task.Dag
+		if finally[step.ID] {
+			dag = task.FinallyDag
+		}
+		if dag != nil {
+			stepReport.DependsOn = append(stepReport.DependsOn, dag.Dependencies(step.ID)...)
+		}
+		if step.IsPushStep() && step.StepStatus == graph.Successful {
+			stepReport.PushedImages = getPushedImages(step.Push, report.Dependencies)
+		}
+		report.Steps = append(report.Steps, stepReport)
+	}
+
+	return report
+}
+
+// WriteToFile writes the RunReport as JSON to the specified file.
+func (r *RunReport) WriteToFile(file string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal run report")
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write run report to %s", file)
+	}
+	return nil
+}
+
+// ReadRunReportFromFile reads a RunReport written by WriteToFile.
+func ReadRunReportFromFile(file string) (*RunReport, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read run report from %s", file)
+	}
+	report := &RunReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, errors.Wrapf(err, "invalid run report %s", file)
+	}
+	return report, nil
+}
+
+// getPushedImages resolves the pushed images to references, using the digests
+// found in the dependencies if the image was built during the run.
+func getPushedImages(images []string, deps []*image.Dependencies) []*image.Reference {
+	var pushed []*image.Reference
+	for _, img := range images {
+		ref := &image.Reference{Reference: img}
+		for _, dep := range deps {
+			if dep.Image != nil && dep.Image.Reference == util.NormalizeImageTag(img) {
+				ref = dep.Image
+				break
+			}
+		}
+		pushed = append(pushed, ref)
+	}
+	return pushed
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/execution"
+	"github.com/Azure/acr-builder/pkg/image"
+	"github.com/Azure/acr-builder/secretmgmt"
+)
+
+func TestNewRunReport(t *testing.T) {
+	buildStep := &graph.Step{
+		ID:    "build",
+		Build: "-t foo.azurecr.io/bar:v1 .",
+		ImageDependencies: []*image.Dependencies{
+			{
+				Image: &image.Reference{
+					Registry:   "foo.azurecr.io",
+					Repository: "bar",
+					Tag:        "v1",
+					Digest:     "sha256:abc",
+					Reference:  "foo.azurecr.io/bar:v1",
+				},
+			},
+		},
+	}
+	pushStep := &graph.Step{
+		ID:   "push",
+		Push: []string{"foo.azurecr.io/bar:v1", "foo.azurecr.io/baz"},
+		When: []string{"build"},
+	}
+	task, err := graph.NewTask(context.Background(), []*graph.Step{buildStep, pushStep}, []*secretmgmt.Secret{}, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("Unexpected err creating task: %v", err)
+	}
+	buildStep.StepStatus = graph.Successful
+	buildStep.Attempts = []execution.Attempt{{ExitCode: 0}}
+	pushStep.StepStatus = graph.Successful
+
+	report := NewRunReport(task, nil)
+	if report.Status != RunSucceeded {
+		t.Errorf("expected status %s but got %s", RunSucceeded, report.Status)
+	}
+	if len(report.Steps) != 2 {
+		t.Fatalf("expected 2 steps but got %d", len(report.Steps))
+	}
+	if report.Steps[0].Type != graph.BuildStepType || len(report.Steps[0].Attempts) != 1 {
+		t.Errorf("unexpected build step report: %+v", report.Steps[0])
+	}
+	push := report.Steps[1]
+	if len(push.DependsOn) != 1 || push.DependsOn[0] != "build" {
+		t.Errorf("expected push to depend on build but got %v", push.DependsOn)
+	}
+	if len(push.PushedImages) != 2 {
+		t.Fatalf("expected 2 pushed images but got %d", len(push.PushedImages))
+	}
+	if push.PushedImages[0].Digest != "sha256:abc" {
+		t.Errorf("expected the digest of the built image but got %q", push.PushedImages[0].Digest)
+	}
+	if push.PushedImages[1].Reference != "foo.azurecr.io/baz:latest" || push.PushedImages[1].Digest != "" {
+		t.Errorf("unexpected pushed image: %+v", push.PushedImages[1])
+	}
+
+	failed := NewRunReport(task, errors.New("boom"))
+	if failed.Status != RunFailed || failed.Error != "boom" {
+		t.Errorf("expected a failed report with an error but got status: %s, error: %s", failed.Status, failed.Error)
+	}
+
+	file := filepath.Join(t.TempDir(), "report.json")
+	if err := report.WriteToFile(file); err != nil {
+		t.Fatalf("Unexpected err writing report: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Unexpected err reading report: %v", err)
+	}
+	var actual RunReport
+	if err := json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("Unexpected err unmarshaling report: %v", err)
+	}
+	if len(actual.Steps) != 2 || actual.Steps[0].ID != "build" {
+		t.Errorf("unexpected report written: %s", data)
+	}
+}
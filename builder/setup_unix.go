@@ -24,7 +24,7 @@ const (
 // setupConfig initializes ~/.docker/config.json
 func (b *Builder) setupConfig(ctx context.Context) error {
 	args := []string{
-		"docker",
+		b.cli.Binary,
 		"run",
 		"--name", fmt.Sprintf("acb_init_config_%s", uuid.New()),
 		"--rm",
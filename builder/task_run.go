@@ -0,0 +1,261 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/util"
+)
+
+// runStatusEnvVar is the environment variable providing the status of the run to finally steps.
+const runStatusEnvVar = "ACB_RUN_STATUS"
+
+// taskRun tracks the state of the execution of one of a Task's graphs, which is shared
+// across the goroutines processing its steps.
+type taskRun struct {
+	task *graph.Task
+
+	// dag is the graph being executed, either the Task's steps or its finally steps.
+	dag *graph.Dag
+
+	// steps contains every step which can be referenced by the executed steps, by ID.
+	steps map[string]*graph.Step
+
+	// metadata describes the run, which the steps' conditions can reference.
+	metadata graph.RunMetadata
+
+	// envs are added to the environment of the steps' containers, after the steps' own.
+	envs []string
+
+	// continueOnFailure is true if steps run even if other steps have failed.
+	continueOnFailure bool
+
+	// slots limits how many steps run at once, nil if unlimited.
+	slots chan struct{}
+
+	// groupSlots limits how many instances of each matrix run at once, keyed by matrix group.
+	groupSlots map[string]chan struct{}
+
+	// locks ensures that steps sharing a lock never run at the same time, keyed by lock name.
+	locks map[string]chan struct{}
+
+	// restored contains the checkpoints of the steps which completed in a previous run, by step ID.
+	restored map[string]*StepCheckpoint
+
+	// checkpoint persists the checkpoint of the run, nil if checkpoints are disabled.
+	checkpoint *checkpointer
+
+	// errorChan is used to report errors which prevent the Task's graph from being processed.
+	errorChan chan error
+
+	mu          sync.Mutex
+	stepErrors  util.Errors
+	failedStep  string
+	skipReasons map[string]stepSkip
+
+	// interrupted contains the IDs of the steps which were running when the run's deadline was exceeded.
+	interrupted []string
+}
+
+// stepSkip describes why a step must be skipped.
+type stepSkip struct {
+	reason string
+
+	// dependencyFailed is true if the skip was caused by a failure rather than by a condition.
+	dependencyFailed bool
+}
+
+// newTaskRun creates a taskRun for the Task's steps.
+func newTaskRun(task *graph.Task) *taskRun {
+	return newDagRun(task, task.Dag, task.Steps)
+}
+
+// newFinallyRun creates a taskRun for the Task's finally steps, given the status of its steps.
+// Finally steps always run, regardless of whether other finally steps have failed,
+// and can reference any of the Task's steps.
+func newFinallyRun(task *graph.Task, status string) *taskRun {
+	run := newDagRun(task, task.FinallyDag, task.Finally)
+	for _, s := range task.Steps {
+		run.steps[s.ID] = s
+	}
+	run.envs = []string{runStatusEnvVar + "=" + status}
+	run.metadata.Status = status
+	run.continueOnFailure = true
+	return run
+}
+
+func newDagRun(task *graph.Task, dag *graph.Dag, dagSteps []*graph.Step) *taskRun {
+	steps := make(map[string]*graph.Step, len(dagSteps))
+	groupSlots := make(map[string]chan struct{})
+	locks := make(map[string]chan struct{})
+	for _, s := range dagSteps {
+		steps[s.ID] = s
+		if group := s.MatrixGroup(); group != "" && s.MaxParallel > 0 && groupSlots[group] == nil {
+			groupSlots[group] = make(chan struct{}, s.MaxParallel)
+		}
+		for _, lock := range s.Locks {
+			if locks[lock] == nil {
+				locks[lock] = make(chan struct{}, 1)
+			}
+		}
+	}
+	var slots chan struct{}
+	if task.MaxParallel > 0 {
+		slots = make(chan struct{}, task.MaxParallel)
+	}
+	return &taskRun{
+		task:              task,
+		dag:               dag,
+		steps:             steps,
+		metadata:          task.Run,
+		continueOnFailure: task.ContinueOnFailure,
+		slots:             slots,
+		groupSlots:        groupSlots,
+		locks:             locks,
+		errorChan:         make(chan error, len(dag.Nodes)),
+		skipReasons:       make(map[string]stepSkip),
+	}
+}
+
+// acquire blocks until the step is allowed to run and returns a function which must be called once it has completed.
+// The step's locks are acquired first, in alphabetical order, followed by a slot of its matrix and a slot of the Task,
+// so that steps waiting on each other can't deadlock.
+func (r *taskRun) acquire(ctx context.Context, step *graph.Step) (func(), error) {
+	var acquired []chan struct{}
+	release := func() {
+		for i := len(acquired) - 1; i >= 0; i-- {
+			<-acquired[i]
+		}
+	}
+
+	var semaphores []chan struct{}
+	var names []string
+	locks := append([]string{}, step.Locks...)
+	sort.Strings(locks)
+	for i, lock := range locks {
+		if i > 0 && lock == locks[i-1] {
+			continue
+		}
+		semaphores = append(semaphores, r.locks[lock])
+		names = append(names, fmt.Sprintf("lock %s", lock))
+	}
+	if slots, ok := r.groupSlots[step.MatrixGroup()]; ok {
+		semaphores = append(semaphores, slots)
+		names = append(names, fmt.Sprintf("a slot of matrix %s", step.MatrixGroup()))
+	}
+	if r.slots != nil {
+		semaphores = append(semaphores, r.slots)
+		names = append(names, "a slot of the task")
+	}
+
+	for i, sem := range semaphores {
+		select {
+		case sem <- struct{}{}:
+		default:
+			log.Printf("Step ID: %s is waiting for %s\n", step.ID, names[i])
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			}
+		}
+		acquired = append(acquired, sem)
+	}
+	return release, nil
+}
+
+// fail records the failure of a step.
+func (r *taskRun) fail(stepID string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failedStep == "" {
+		r.failedStep = stepID
+	}
+	r.stepErrors = append(r.stepErrors, err)
+}
+
+// interrupt records that the step was running when the run's deadline was exceeded.
+func (r *taskRun) interrupt(stepID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interrupted = append(r.interrupted, stepID)
+}
+
+// timeoutErr wraps the error of a run whose deadline was exceeded with the steps which were running.
+func (r *taskRun) timeoutErr(err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.interrupted) == 0 {
+		return fmt.Errorf("the run timed out: %w", err)
+	}
+	steps := append([]string{}, r.interrupted...)
+	sort.Strings(steps)
+	return fmt.Errorf("the run timed out while running step ID(s): %s: %w", strings.Join(steps, ", "), err)
+}
+
+// markSkipped marks a step to be skipped once all of its dependencies have been processed.
+// The first reason provided for a step is kept, but a failure always takes precedence.
+func (r *taskRun) markSkipped(stepID string, reason string, dependencyFailed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if skip, ok := r.skipReasons[stepID]; !ok || (dependencyFailed && !skip.dependencyFailed) {
+		r.skipReasons[stepID] = stepSkip{reason: reason, dependencyFailed: dependencyFailed}
+	}
+}
+
+// skipReason returns the reason the step must be skipped, or an empty string if it should run,
+// along with whether or not the skip was caused by a failure.
+// Steps are skipped if any of their dependencies failed or were skipped, or, unless the Task continues
+// on failure, if any other step has failed. A step's if condition may still decide to run it.
+func (r *taskRun) skipReason(stepID string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if skip, ok := r.skipReasons[stepID]; ok {
+		return skip.reason, skip.dependencyFailed
+	}
+	if r.failedStep != "" && !r.continueOnFailure {
+		return fmt.Sprintf("step %s failed and the task doesn't continue on failure", r.failedStep), true
+	}
+	return "", false
+}
+
+// conditionContext returns the context to evaluate the step's if condition against.
+func (r *taskRun) conditionContext(step *graph.Step, dependencySkipped bool, dependencyFailed bool) *graph.ConditionContext {
+	env := make(map[string]string, len(step.Envs))
+	for _, e := range step.Envs {
+		if pair := strings.SplitN(e, "=", 2); len(pair) == 2 {
+			env[pair[0]] = pair[1]
+		}
+	}
+	return &graph.ConditionContext{
+		Steps:             r.steps,
+		Run:               r.metadata,
+		Env:               env,
+		DependencySkipped: dependencySkipped,
+		DependencyFailed:  dependencyFailed,
+	}
+}
+
+// err returns the aggregated errors of all failed steps, or nil if no step failed.
+func (r *taskRun) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch len(r.stepErrors) {
+	case 0:
+		return nil
+	case 1:
+		return r.stepErrors[0]
+	default:
+		return errors.New(r.stepErrors.String())
+	}
+}
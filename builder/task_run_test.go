@@ -0,0 +1,565 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/secretmgmt"
+)
+
+// fakeDockerScript emulates the docker CLI: commands ending with an "args" argument print their args,
+// commands containing a "fail" argument exit with 3,
+// commands containing "slow" take a second, commands containing "version" print a version,
+// reading an outputs file prints a digest, checkpoints are stored in the file named by $ACB_TEST_CHECKPOINT,
+// cache entries are stored in the file named by $ACB_TEST_CACHE, stopped containers are appended to the file
+// named by $ACB_TEST_STOPS along with the removed docker CLI containers, and everything else succeeds.
+const fakeDockerScript = `#!/bin/sh
+case "$*" in
+  "stop "*|"rm -f acb_docker_"*) echo "$*" >> "$ACB_TEST_STOPS" ;;
+  *" args") echo "$*" ;;
+  *acb_write_checkpoint*) cat > "$ACB_TEST_CHECKPOINT" ;;
+  *acb_read_checkpoint*) cat "$ACB_TEST_CHECKPOINT" 2>/dev/null ;;
+  *sha256sum*) echo "abc  -" ;;
+  *"image inspect"*) echo '[{"Id": "sha256:def"}]' ;;
+  *acb_cache_hit*) [ -f "$ACB_TEST_CACHE" ] && echo acb_cache_hit && cat "$ACB_TEST_CACHE" ;;
+  *acb_cache_*) cat > "$ACB_TEST_CACHE" ;;
+  *" fail"|*" fail "*) exit 3 ;;
+  *slow*) sleep 1 ;;
+  *acb_read_outputs*) echo "digest=sha256:abc" ;;
+  *version*) echo "version: 1.2.3" ;;
+esac
+exit 0
+`
+
+// useFakeDocker puts a fake docker CLI at the front of the PATH for the duration of the test.
+func useFakeDocker(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake docker CLI requires a POSIX shell")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(fakeDockerScript), 0700); err != nil {
+		t.Fatalf("failed to write the fake docker CLI: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func newTestTask(t *testing.T, continueOnFailure bool, steps ...*graph.Step) *graph.Task {
+	t.Helper()
+	task, err := graph.NewTask(context.Background(), steps, []*secretmgmt.Secret{}, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	task.ContinueOnFailure = continueOnFailure
+	return task
+}
+
+func TestRunTask_ContinueOnFailure(t *testing.T) {
+	useFakeDocker(t)
+	a := &graph.Step{ID: "a", Cmd: "fail"}
+	b := &graph.Step{ID: "b", Cmd: "ok", When: []string{"a"}}
+	c := &graph.Step{ID: "c", Cmd: "ok", When: []string{graph.ImmediateExecutionToken}}
+	d := &graph.Step{ID: "d", Cmd: "ok", When: []string{"b", "c"}}
+	e := &graph.Step{ID: "e", Cmd: "fail", When: []string{"c"}}
+	task := newTestTask(t, true, a, b, c, d, e)
+
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	err := builder.RunTask(context.Background(), task)
+	if err == nil {
+		t.Fatal("expected the run to fail")
+	}
+	if !strings.Contains(err.Error(), "step ID: a") || !strings.Contains(err.Error(), "step ID: e") {
+		t.Errorf("expected the errors of both failed steps to be aggregated but got: %v", err)
+	}
+
+	expected := map[*graph.Step]graph.StepStatus{
+		a: graph.Failed,
+		b: graph.Skipped,
+		c: graph.Successful,
+		d: graph.Skipped,
+		e: graph.Failed,
+	}
+	for step, status := range expected {
+		if step.StepStatus != status {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, status, step.StepStatus)
+		}
+	}
+	if a.ExitCode != 3 {
+		t.Errorf("expected exit code 3 for step a but got %d", a.ExitCode)
+	}
+	if b.SkipReason != "dependency a failed" {
+		t.Errorf("unexpected skip reason for step b: %q", b.SkipReason)
+	}
+	if d.SkipReason != "dependency b was skipped" {
+		t.Errorf("unexpected skip reason for step d: %q", d.SkipReason)
+	}
+}
+
+func TestRunTask_FailFast(t *testing.T) {
+	useFakeDocker(t)
+	a := &graph.Step{ID: "a", Cmd: "fail"}
+	slow := &graph.Step{ID: "slow", Cmd: "slow", When: []string{graph.ImmediateExecutionToken}}
+	c := &graph.Step{ID: "c", Cmd: "ok", When: []string{"slow"}}
+	task := newTestTask(t, false, a, slow, c)
+
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	if err := builder.RunTask(context.Background(), task); err == nil {
+		t.Fatal("expected the run to fail")
+	}
+	if slow.StepStatus != graph.Successful {
+		t.Errorf("expected the in-flight step to finish but got %s", slow.StepStatus)
+	}
+	if c.StepStatus != graph.Skipped || !strings.Contains(c.SkipReason, "step a failed") {
+		t.Errorf("expected step c to be skipped because of step a but got %s (%s)", c.StepStatus, c.SkipReason)
+	}
+}
+
+func TestRunTask_Conditions(t *testing.T) {
+	useFakeDocker(t)
+	a := &graph.Step{ID: "a", Cmd: "fail"}
+	cleanup := &graph.Step{ID: "cleanup", Cmd: "ok", When: []string{"a"}, If: "failure() && steps.a.exitCode == 3"}
+	next := &graph.Step{ID: "next", Cmd: "ok", When: []string{"a"}, If: "env.FOO == 'bar'", Envs: []string{"FOO=bar"}}
+	always := &graph.Step{ID: "always", Cmd: "ok", When: []string{"next"}, If: "always()"}
+	push := &graph.Step{ID: "push", Cmd: "ok", When: []string{graph.ImmediateExecutionToken}, If: "run.branch == 'main'"}
+	afterPush := &graph.Step{ID: "afterPush", Cmd: "ok", When: []string{"push"}, If: "failure()"}
+	task := newTestTask(t, true, a, cleanup, next, always, push, afterPush)
+	task.Run = graph.RunMetadata{Branch: "dev"}
+
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	if err := builder.RunTask(context.Background(), task); err == nil {
+		t.Fatal("expected the run to fail")
+	}
+
+	expected := map[*graph.Step]graph.StepStatus{
+		a:         graph.Failed,
+		cleanup:   graph.Successful,
+		next:      graph.Skipped,
+		always:    graph.Successful,
+		push:      graph.Skipped,
+		afterPush: graph.Skipped,
+	}
+	for step, status := range expected {
+		if step.StepStatus != status {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, status, step.StepStatus)
+		}
+	}
+	if next.SkipReason != "dependency a failed" {
+		t.Errorf("unexpected skip reason for step next: %q", next.SkipReason)
+	}
+	if push.SkipReason != `condition "run.branch == 'main'" evaluated to false` {
+		t.Errorf("unexpected skip reason for step push: %q", push.SkipReason)
+	}
+}
+
+func TestRunTask_Outputs(t *testing.T) {
+	useFakeDocker(t)
+	producer := &graph.Step{
+		ID:  "producer",
+		Cmd: "version",
+		Outputs: []*graph.Output{
+			{Name: "version", Regex: `version: (\S+)`},
+			{Name: "digest"},
+		},
+	}
+	consumer := &graph.Step{
+		ID:   "consumer",
+		Cmd:  "use {{.Steps.producer.Outputs.version}}",
+		Envs: []string{"DIGEST={{.Steps.producer.Outputs.digest}}"},
+		If:   "steps.producer.outputs.version == '1.2.3'",
+	}
+	task := newTestTask(t, false, producer, consumer)
+
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	if err := builder.RunTask(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if producer.OutputValues["version"] != "1.2.3" || producer.OutputValues["digest"] != "sha256:abc" {
+		t.Errorf("unexpected outputs: %v", producer.OutputValues)
+	}
+	if consumer.StepStatus != graph.Successful {
+		t.Errorf("expected step consumer to be successful but got %s (%s)", consumer.StepStatus, consumer.SkipReason)
+	}
+	if consumer.Cmd != "use 1.2.3" {
+		t.Errorf("expected the output reference to be resolved but got %q", consumer.Cmd)
+	}
+	if consumer.Envs[0] != "DIGEST=sha256:abc" {
+		t.Errorf("expected the output reference to be resolved but got %q", consumer.Envs[0])
+	}
+}
+
+func TestRunTask_MatrixMaxParallel(t *testing.T) {
+	useFakeDocker(t)
+	build := &graph.Step{ID: "build", Cmd: "slow", MaxParallel: 1, Matrix: graph.Matrix{"v": {"1", "2"}}}
+	task := newTestTask(t, false, build)
+
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	if err := builder.RunTask(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(task.Steps) != 2 {
+		t.Fatalf("expected 2 instances but got %d", len(task.Steps))
+	}
+	assertNoOverlap(t, task.Steps[0], task.Steps[1])
+}
+
+func TestRunTask_Concurrency(t *testing.T) {
+	useFakeDocker(t)
+	tests := []struct {
+		name        string
+		maxParallel int
+		locks       []string
+	}{
+		{"max parallel", 1, nil},
+		{"shared lock", 0, []string{"daemon-heavy"}},
+	}
+	for _, test := range tests {
+		a := &graph.Step{ID: "a", Cmd: "slow", When: []string{graph.ImmediateExecutionToken}, Locks: test.locks}
+		b := &graph.Step{ID: "b", Cmd: "slow", When: []string{graph.ImmediateExecutionToken}, Locks: test.locks}
+		task := newTestTask(t, false, a, b)
+		task.MaxParallel = test.maxParallel
+
+		builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+		if err := builder.RunTask(context.Background(), task); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		assertNoOverlap(t, a, b)
+	}
+}
+
+// assertNoOverlap ensures that the executions of the steps didn't overlap.
+func assertNoOverlap(t *testing.T, first *graph.Step, second *graph.Step) {
+	t.Helper()
+	if second.StartTime.Before(first.StartTime) {
+		first, second = second, first
+	}
+	if second.StartTime.Before(first.EndTime) {
+		t.Errorf("expected steps to run one at a time, but %s started before %s ended", second.ID, first.ID)
+	}
+}
+
+func TestRunTask_Finally(t *testing.T) {
+	useFakeDocker(t)
+	task, err := graph.UnmarshalTaskFromString(context.Background(), `
+steps:
+  - id: a
+    cmd: fail
+  - id: b
+    cmd: ok
+finally:
+  - id: cleanup
+    cmd: args
+    if: run.status == 'failed' && steps.a.status == 'failed'
+  - id: report
+    cmd: fail
+    when: ["-"]
+  - id: notify
+    cmd: ok
+    when: ["report"]
+    if: run.status == 'succeeded'
+  - id: teardown
+    cmd: ok
+    when: ["report"]
+    if: always()
+`, &graph.TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+
+	var output bytes.Buffer
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	builder.SetLogSink(NewConsoleLogSink(&output, &output, false, false))
+	err = builder.RunTask(context.Background(), task)
+	if err == nil {
+		t.Fatal("expected the run to fail")
+	}
+	if !strings.Contains(err.Error(), "step ID: a") {
+		t.Errorf("expected the error of the failed step but got: %v", err)
+	}
+
+	statuses := make(map[string]graph.StepStatus)
+	for _, step := range task.AllSteps() {
+		statuses[step.ID] = step.StepStatus
+	}
+	expected := map[string]graph.StepStatus{
+		"a":        graph.Failed,
+		"b":        graph.Skipped,
+		"cleanup":  graph.Successful,
+		"report":   graph.Failed,
+		"notify":   graph.Skipped,
+		"teardown": graph.Successful,
+	}
+	for id, status := range expected {
+		if statuses[id] != status {
+			t.Errorf("expected step %s to be %s but got %s", id, status, statuses[id])
+		}
+	}
+
+	if !strings.Contains(output.String(), "--env "+runStatusEnvVar+"="+RunFailed) {
+		t.Errorf("expected the run status to be provided to finally steps but got %q", output.String())
+	}
+	if cleanup := task.Finally[0]; len(cleanup.Envs) != 0 {
+		t.Errorf("expected the finally step to be left unmodified but got envs %v", cleanup.Envs)
+	}
+}
+
+func TestRunTask_FinallyAfterSetupFailure(t *testing.T) {
+	useFakeDocker(t)
+	task, err := graph.UnmarshalTaskFromString(context.Background(), `
+steps:
+  - id: a
+    cmd: ok
+finally:
+  - id: cleanup
+    cmd: args
+    if: run.status == 'failed'
+networks:
+  - name: fail
+`, &graph.TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+
+	var output bytes.Buffer
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	builder.SetLogSink(NewConsoleLogSink(&output, &output, false, false))
+	err = builder.RunTask(context.Background(), task)
+	if err == nil || !strings.Contains(err.Error(), "failed to create network: fail") {
+		t.Fatalf("expected the network creation to fail but got: %v", err)
+	}
+
+	a, cleanup := task.Steps[0], task.Finally[0]
+	if a.StepStatus != graph.Skipped || a.SkipReason != "the run's setup failed" {
+		t.Errorf("expected step a to be skipped because of the setup but got %s (%s)", a.StepStatus, a.SkipReason)
+	}
+	if cleanup.StepStatus != graph.Successful {
+		t.Errorf("expected the finally step to run but got %s", cleanup.StepStatus)
+	}
+	if !strings.Contains(output.String(), "--env "+runStatusEnvVar+"="+RunFailed) {
+		t.Errorf("expected the failed run status to be provided to finally steps but got %q", output.String())
+	}
+}
+
+func TestRunTask_Resume(t *testing.T) {
+	useFakeDocker(t)
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	t.Setenv("ACB_TEST_CHECKPOINT", checkpointFile)
+
+	newSteps := func(bCmd string) []*graph.Step {
+		return []*graph.Step{
+			{ID: "a", Cmd: "version", Outputs: []*graph.Output{{Name: "version", Regex: `version: (\S+)`}}},
+			{ID: "b", Cmd: bCmd},
+			{ID: "c", Cmd: "use {{.Steps.a.Outputs.version}}"},
+		}
+	}
+
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "home")
+	builder.EnableCheckpoint("run1")
+	if err := builder.RunTask(context.Background(), newTestTask(t, false, newSteps("fail")...)); err == nil {
+		t.Fatal("expected the run to fail")
+	}
+
+	tests := []struct {
+		fromStep        string
+		expectedResumed map[string]bool
+	}{
+		{"", map[string]bool{"a": true, "b": false, "c": false}},
+		{"a", map[string]bool{"a": false, "b": false, "c": false}},
+	}
+	for _, test := range tests {
+		builder := NewBuilder(procmanager.NewProcManager(false), false, "home")
+		if err := builder.Resume(context.Background(), "run1", test.fromStep); err != nil {
+			t.Fatalf("failed to resume: %v", err)
+		}
+		task := newTestTask(t, false, newSteps("ok")...)
+		if err := builder.RunTask(context.Background(), task); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, step := range task.Steps {
+			if step.StepStatus != graph.Successful {
+				t.Errorf("from step %q: expected step %s to be successful but got %s", test.fromStep, step.ID, step.StepStatus)
+			}
+			if step.Resumed != test.expectedResumed[step.ID] {
+				t.Errorf("from step %q: expected step %s resumed to be %v", test.fromStep, step.ID, test.expectedResumed[step.ID])
+			}
+		}
+		if task.Steps[2].Cmd != "use 1.2.3" {
+			t.Errorf("from step %q: expected the restored output to be resolved but got %q", test.fromStep, task.Steps[2].Cmd)
+		}
+	}
+
+	builder = NewBuilder(procmanager.NewProcManager(false), false, "home")
+	if err := builder.Resume(context.Background(), "run1", "x"); err != nil {
+		t.Fatalf("failed to resume: %v", err)
+	}
+	if err := builder.RunTask(context.Background(), newTestTask(t, false, newSteps("ok")...)); err == nil {
+		t.Error("expected resuming from an unknown step to fail")
+	}
+}
+
+func TestRunTask_Cache(t *testing.T) {
+	useFakeDocker(t)
+	t.Setenv("ACB_TEST_CACHE", filepath.Join(t.TempDir(), "entry.json"))
+
+	newSteps := func() []*graph.Step {
+		return []*graph.Step{
+			{
+				ID:         "a",
+				Cmd:        "version",
+				CacheKey:   &graph.CacheKey{Files: []string{"go.sum"}},
+				CachePaths: []string{"bin"},
+				Outputs:    []*graph.Output{{Name: "version", Regex: `version: (\S+)`}},
+			},
+			{ID: "b", Cmd: "use {{.Steps.a.Outputs.version}}"},
+		}
+	}
+
+	for i, expectedHit := range []bool{false, true} {
+		builder := NewBuilder(procmanager.NewProcManager(false), false, "home")
+		task := newTestTask(t, false, newSteps()...)
+		if err := builder.RunTask(context.Background(), task); err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, err)
+		}
+		a := task.Steps[0]
+		if a.StepStatus != graph.Successful {
+			t.Errorf("run %d: expected step a to be successful but got %s", i, a.StepStatus)
+		}
+		if a.CacheHit != expectedHit {
+			t.Errorf("run %d: expected step a cache hit to be %v", i, expectedHit)
+		}
+		if task.Steps[1].Cmd != "use 1.2.3" {
+			t.Errorf("run %d: expected the output to be resolved but got %q", i, task.Steps[1].Cmd)
+		}
+	}
+}
+
+func TestRunTask_Cancel(t *testing.T) {
+	useFakeDocker(t)
+	stops := filepath.Join(t.TempDir(), "stops")
+	t.Setenv("ACB_TEST_STOPS", stops)
+	task, err := graph.UnmarshalTaskFromString(context.Background(), `
+steps:
+  - id: a
+    cmd: slow
+  - id: b
+    cmd: ok
+finally:
+  - id: cleanup
+    cmd: ok
+    if: run.status == 'cancelled'
+`, &graph.TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	builder.SetStopTimeout(time.Second)
+	runErr := builder.RunTask(ctx, task)
+	if !errors.Is(runErr, context.Canceled) {
+		t.Fatalf("expected the run to be cancelled but got: %v", runErr)
+	}
+
+	expected := map[*graph.Step]graph.StepStatus{
+		task.Steps[0]:   graph.Cancelled,
+		task.Steps[1]:   graph.Cancelled,
+		task.Finally[0]: graph.Successful,
+	}
+	for step, status := range expected {
+		if step.StepStatus != status {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, status, step.StepStatus)
+		}
+	}
+	if task.Steps[1].SkipReason != "the run was cancelled" {
+		t.Errorf("unexpected reason for step b: %q", task.Steps[1].SkipReason)
+	}
+
+	data, err := os.ReadFile(stops)
+	if err != nil {
+		t.Fatalf("expected the running container to be stopped: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "stop --time 1 a" {
+		t.Errorf("unexpected stop command: %q", got)
+	}
+
+	if report := NewRunReport(task, runErr); report.Status != RunCancelled {
+		t.Errorf("expected the report's status to be %s but got %s", RunCancelled, report.Status)
+	}
+}
+
+func TestRunTask_Timeout(t *testing.T) {
+	useFakeDocker(t)
+	stops := filepath.Join(t.TempDir(), "stops")
+	t.Setenv("ACB_TEST_STOPS", stops)
+	task, err := graph.UnmarshalTaskFromString(context.Background(), `
+steps:
+  - id: a
+    cmd: slow
+  - id: b
+    cmd: ok
+finally:
+  - id: cleanup
+    cmd: ok
+    if: run.status == 'failed'
+`, &graph.TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+
+	// The task's timeout is specified in seconds, the run's deadline is propagated the same way.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	builder := NewBuilder(procmanager.NewProcManager(false), false, "")
+	builder.SetStopTimeout(time.Second)
+	runErr := builder.RunTask(ctx, task)
+	if !errors.Is(runErr, context.DeadlineExceeded) {
+		t.Fatalf("expected the run to time out but got: %v", runErr)
+	}
+	if !strings.Contains(runErr.Error(), "while running step ID(s): a") {
+		t.Errorf("expected the error to name the running step but got: %v", runErr)
+	}
+
+	expected := map[*graph.Step]graph.StepStatus{
+		task.Steps[0]:   graph.Failed,
+		task.Steps[1]:   graph.Skipped,
+		task.Finally[0]: graph.Successful,
+	}
+	for step, status := range expected {
+		if step.StepStatus != status {
+			t.Errorf("expected step %s to be %s but got %s", step.ID, status, step.StepStatus)
+		}
+	}
+	if task.Steps[1].SkipReason != "the run timed out" {
+		t.Errorf("unexpected skip reason for step b: %q", task.Steps[1].SkipReason)
+	}
+	if _, err := os.ReadFile(stops); err != nil {
+		t.Errorf("expected the running container to be stopped: %v", err)
+	}
+}
+
+func TestGetStepTimeout(t *testing.T) {
+	step := &graph.Step{Timeout: 600}
+	if timeout := getStepTimeout(context.Background(), step); timeout != 600*time.Second {
+		t.Errorf("expected the step's timeout without a deadline but got %v", timeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if timeout := getStepTimeout(ctx, step); timeout > time.Minute || timeout < 59*time.Second {
+		t.Errorf("expected the timeout to be bounded by the deadline but got %v", timeout)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+)
+
+// TimingReport analyzes where the time of a run was spent.
+type TimingReport struct {
+	// DurationSeconds is the wall-clock time it took to run the Task's steps.
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// CriticalPath is the chain of dependent steps which determined the run's duration, in execution order.
+	CriticalPath        []string `json:"criticalPath"`
+	CriticalPathSeconds float64  `json:"criticalPathSeconds"`
+
+	// Parallelism is the average number of steps executing at once.
+	Parallelism float64 `json:"parallelism"`
+
+	Steps []*StepTiming `json:"steps"`
+}
+
+// StepTiming describes where the time of a single step was spent.
+type StepTiming struct {
+	ID string `json:"id"`
+
+	// QueueSeconds is the time between the step's dependencies completing and the step starting,
+	// e.g. waiting for a parallelism slot, a lock or its start delay.
+	QueueSeconds float64 `json:"queueSeconds"`
+
+	// ExecutionSeconds is the time the step was executing, including its retries.
+	ExecutionSeconds float64 `json:"executionSeconds"`
+
+	// RetrySeconds is the part of the execution spent in attempts which were retried, and waiting between them.
+	RetrySeconds float64 `json:"retrySeconds"`
+
+	Critical bool `json:"critical"`
+}
+
+// NewTimingReport analyzes the timing of a Task which has been run.
+// Steps which didn't execute, because they were skipped or restored from a checkpoint, aren't part of the analysis.
+func NewTimingReport(task *graph.Task) *TimingReport {
+	report := &TimingReport{
+		DurationSeconds: task.EndTime.Sub(task.StartTime).Seconds(),
+		CriticalPath:    []string{},
+		Steps:           []*StepTiming{},
+	}
+
+	executed := make(map[string]*graph.Step)
+	for _, step := range task.AllSteps() {
+		if !step.StartTime.IsZero() && !step.EndTime.IsZero() {
+			executed[step.ID] = step
+		}
+	}
+	finally := make(map[string]bool, len(task.Finally))
+	for _, step := range task.Finally {
+		finally[step.ID] = true
+	}
+
+	// lastStep is the executed step which completed last among the Task's steps, finally steps start after it.
+	var lastStep *graph.Step
+	for _, step := range task.Steps {
+		if s, ok := executed[step.ID]; ok && (lastStep == nil || s.EndTime.After(lastStep.EndTime)) {
+			lastStep = s
+		}
+	}
+
+	// predecessor returns the executed step the specified step was waiting on last, or nil if it only waited on the run to start.
+	predecessor := func(step *graph.Step) *graph.Step {
+		dag := task.Dag
+		if finally[step.ID] {
+			dag = task.FinallyDag
+		}
+		var pred *graph.Step
+		if dag != nil {
+			for _, id := range dag.Dependencies(step.ID) {
+				if dep, ok := executed[id]; ok && (pred == nil || dep.EndTime.After(pred.EndTime)) {
+					pred = dep
+				}
+			}
+		}
+		if pred == nil && finally[step.ID] {
+			pred = lastStep
+		}
+		return pred
+	}
+
+	var executionSeconds float64
+	var last *graph.Step
+	timings := make(map[string]*StepTiming, len(executed))
+	for _, step := range task.AllSteps() {
+		s, ok := executed[step.ID]
+		if !ok {
+			continue
+		}
+		ready := task.StartTime
+		if pred := predecessor(s); pred != nil {
+			ready = pred.EndTime
+		}
+		timing := &StepTiming{
+			ID:               s.ID,
+			QueueSeconds:     nonNegativeSeconds(s.StartTime.Sub(ready)),
+			ExecutionSeconds: nonNegativeSeconds(s.EndTime.Sub(s.StartTime)),
+			RetrySeconds:     retrySeconds(s),
+		}
+		executionSeconds += timing.ExecutionSeconds
+		timings[s.ID] = timing
+		report.Steps = append(report.Steps, timing)
+		if last == nil || s.EndTime.After(last.EndTime) {
+			last = s
+		}
+	}
+
+	// The critical path ends with the step which completed last, and follows the dependencies which completed last.
+	for s := last; s != nil; s = predecessor(s) {
+		report.CriticalPath = append([]string{s.ID}, report.CriticalPath...)
+		timings[s.ID].Critical = true
+		report.CriticalPathSeconds += timings[s.ID].QueueSeconds + timings[s.ID].ExecutionSeconds
+	}
+	if report.DurationSeconds > 0 {
+		report.Parallelism = executionSeconds / report.DurationSeconds
+	}
+	return report
+}
+
+// retrySeconds returns the time the step spent in attempts which were retried, including the delays between them.
+func retrySeconds(step *graph.Step) float64 {
+	var seconds float64
+	first := make(map[int]time.Time)
+	for _, attempt := range step.Attempts {
+		if attempt.Retry == 0 {
+			first[attempt.Repetition] = attempt.StartTime
+		} else if start, ok := first[attempt.Repetition]; ok && attempt.StartTime.After(start) {
+			seconds += attempt.StartTime.Sub(start).Seconds()
+			first[attempt.Repetition] = attempt.StartTime
+		}
+	}
+	return seconds
+}
+
+func nonNegativeSeconds(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return d.Seconds()
+}
+
+// Print writes a summary of the TimingReport as a table.
+func (r *TimingReport) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tQUEUED (s)\tEXECUTION (s)\tRETRIES (s)\tCRITICAL")
+	for _, s := range r.Steps {
+		critical := ""
+		if s.Critical {
+			critical = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\t%s\n", s.ID, s.QueueSeconds, s.ExecutionSeconds, s.RetrySeconds, critical)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "Critical path: %s (%.2fs of %.2fs), parallelism: %.2f\n",
+		strings.Join(r.CriticalPath, " -> "), r.CriticalPathSeconds, r.DurationSeconds, r.Parallelism)
+	return err
+}
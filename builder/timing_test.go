@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package builder
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/execution"
+)
+
+func TestNewTimingReport(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	// a and b run in parallel, c waits on both; d is skipped.
+	a := &graph.Step{ID: "a", Cmd: "a", StartTime: at(0), EndTime: at(10)}
+	b := &graph.Step{ID: "b", Cmd: "b", When: []string{graph.ImmediateExecutionToken}, StartTime: at(1), EndTime: at(4),
+		Attempts: []execution.Attempt{
+			{Retry: 0, StartTime: at(1), EndTime: at(2)},
+			{Retry: 1, StartTime: at(3), EndTime: at(4)},
+		}}
+	c := &graph.Step{ID: "c", Cmd: "c", When: []string{"a", "b"}, StartTime: at(12), EndTime: at(20)}
+	d := &graph.Step{ID: "d", Cmd: "d", When: []string{"b"}}
+	task := newTestTask(t, false, a, b, c, d)
+	task.StartTime = at(0)
+	task.EndTime = at(20)
+
+	report := NewTimingReport(task)
+	if report.DurationSeconds != 20 {
+		t.Errorf("expected a duration of 20s but got %v", report.DurationSeconds)
+	}
+	if strings.Join(report.CriticalPath, ",") != "a,c" {
+		t.Errorf("expected the critical path to be a,c but got %v", report.CriticalPath)
+	}
+	if report.CriticalPathSeconds != 20 {
+		t.Errorf("expected the critical path to take 20s but got %v", report.CriticalPathSeconds)
+	}
+	if report.Parallelism != 1.05 {
+		t.Errorf("expected a parallelism of 1.05 but got %v", report.Parallelism)
+	}
+
+	expected := []StepTiming{
+		{ID: "a", QueueSeconds: 0, ExecutionSeconds: 10, Critical: true},
+		{ID: "b", QueueSeconds: 1, ExecutionSeconds: 3, RetrySeconds: 2},
+		{ID: "c", QueueSeconds: 2, ExecutionSeconds: 8, Critical: true},
+	}
+	if len(report.Steps) != len(expected) {
+		t.Fatalf("expected %d step timings but got %d", len(expected), len(report.Steps))
+	}
+	for i, e := range expected {
+		if *report.Steps[i] != e {
+			t.Errorf("expected step timing %+v but got %+v", e, *report.Steps[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.Print(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Critical path: a -> c (20.00s of 20.00s), parallelism: 1.05") {
+		t.Errorf("unexpected summary:\n%s", buf.String())
+	}
+}
@@ -8,13 +8,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/Azure/acr-builder/builder"
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/containerruntime"
 	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/Azure/acr-builder/pkg/volume"
 	"github.com/Azure/acr-builder/secretmgmt"
 	"github.com/Azure/acr-builder/templating"
@@ -90,12 +93,41 @@ var Command = cli.Command{
 		},
 		cli.BoolFlag{
 			Name:  "dry-run",
-			Usage: "evaluates the command, but doesn't execute it",
+			Usage: "prints the execution plan of the command without executing it",
 		},
 		cli.BoolFlag{
 			Name:  "debug",
 			Usage: "enables diagnostic logging",
 		},
+		cli.StringFlag{
+			Name:  "report",
+			Usage: "the path to write a JSON report of the run to",
+		},
+		cli.StringFlag{
+			Name: "runtime",
+			Usage: fmt.Sprintf("the container runtime to use, either the %s, %s or %s CLI, or %s to call the Docker Engine API at DOCKER_HOST",
+				containerruntime.DockerRuntime, containerruntime.PodmanRuntime, containerruntime.NerdctlRuntime, containerruntime.EngineRuntime),
+			Value: containerruntime.DockerRuntime,
+		},
+		cli.StringFlag{
+			Name:  "runtime-socket",
+			Usage: "the host path of the runtime's Docker compatible API socket, mounted into the containers running the docker CLI (defaults to the runtime's)",
+		},
+		cli.IntFlag{
+			Name:  "secret-concurrency",
+			Usage: "the maximum number of registry credentials resolved at once",
+			Value: secretmgmt.DefaultSecretResolveConcurrency,
+		},
+		cli.IntFlag{
+			Name:  "credential-ttl",
+			Usage: "the number of seconds after which registry credentials resolved from a vault or MSI are resolved again before running a step, logging in with the fresh credentials (0 never refreshes them)",
+			Value: builder.DefaultCredentialTTLInSec,
+		},
+		cli.IntFlag{
+			Name:  "stop-timeout",
+			Usage: "the number of seconds running steps are given to stop gracefully when the run is cancelled by a signal, before being killed",
+			Value: builder.DefaultStopTimeoutInSec,
+		},
 
 		// Rendering options
 		cli.StringFlag{
@@ -166,6 +198,12 @@ var Command = cli.Command{
 			push                    = context.Bool("push")
 			dryRun                  = context.Bool("dry-run")
 			debug                   = context.Bool("debug")
+			reportFile              = context.String("report")
+			stopTimeout             = context.Int("stop-timeout")
+			credentialTTL           = context.Int("credential-ttl")
+			secretConcurrency       = context.Int("secret-concurrency")
+			runtimeKind             = context.String("runtime")
+			runtimeSocket           = context.String("runtime-socket")
 
 			// Rendering options
 			values        = context.String("values")
@@ -192,22 +230,39 @@ var Command = cli.Command{
 			return err
 		}
 
-		ctx := gocontext.Background()
+		// Cancel the run when receiving an interrupt or a termination signal.
+		ctx, stop := util.NewSignalContext(gocontext.Background())
+		defer stop()
 		pm := procmanager.NewProcManager(dryRun)
+		// Mask the values of secrets in the output of the run and in the logs once they're known.
+		redactor := redact.NewRedactor()
+		pm.SetRedactor(redactor)
+		log.SetOutput(redactor.NewWriter(log.Writer()))
+		containerRuntime, runtimeCLI, err := builder.NewContainerRuntime(runtimeKind, runtimeSocket, pm)
+		if err != nil {
+			return err
+		}
 
 		if homevol == "" {
-			if !dryRun {
+			if dryRun {
+				// The home volume isn't created during a dry run, but it's named in the plan.
+				homevol = fmt.Sprintf("%s%s", volume.DockerVolumeHelperPrefix, "dryrun")
+			} else {
 				homevol = fmt.Sprintf("%s%s", volume.DockerVolumeHelperPrefix, uuid.New())
-				v := volume.NewDockerVolumeHelper(homevol, pm)
-				if msg, err := v.Create(ctx); err != nil {
-					return fmt.Errorf("failed to create volume. Msg: %s, Err: %v", msg, err)
+				if err := containerRuntime.CreateVolume(ctx, homevol); err != nil {
+					return fmt.Errorf("failed to create volume. Err: %v", err)
 				}
 				defer func() {
-					_, _ = v.Delete(ctx)
+					_ = containerRuntime.RemoveVolume(gocontext.WithoutCancel(ctx), homevol)
 				}()
 			}
 		}
 		log.Printf("Using %s as the home volume\n", homevol)
+		for _, secretBuildArg := range secretBuildArgs {
+			if _, value, ok := strings.Cut(secretBuildArg, "="); ok {
+				redactor.Add(value)
+			}
+		}
 
 		renderOpts := &templating.BaseRenderOptions{
 			ValuesFile:              values,
@@ -250,9 +305,26 @@ var Command = cli.Command{
 			return err
 		}
 
-		builder := builder.NewBuilder(pm, debug, homevol)
-		defer builder.CleanTask(gocontext.Background(), task) // Use a separate context since the other may have expired.
-		return builder.RunTask(gocontext.Background(), task)
+		b := builder.NewBuilder(pm, debug, homevol)
+		b.SetContainerRuntime(containerRuntime, runtimeCLI)
+		b.SetRedactor(redactor)
+		secretCache, err := secretmgmt.NewCredentialCache(time.Duration(credentialTTL)*time.Second, secretConcurrency)
+		if err != nil {
+			return err
+		}
+		b.SetSecretCache(secretCache)
+		if dryRun {
+			return b.NewPlan(task, "").Print(os.Stdout)
+		}
+		b.SetStopTimeout(time.Duration(stopTimeout) * time.Second)
+		defer b.CleanTask(gocontext.Background(), task) // Use a separate context since the other may have expired.
+		err = b.RunTask(ctx, task)
+		if reportFile != "" {
+			if reportErr := builder.NewRunReport(task, err).WriteToFile(reportFile); reportErr != nil {
+				log.Printf("Failed to write the run report: %v\n", reportErr)
+			}
+		}
+		return redactor.RedactError(err)
 	},
 }
 
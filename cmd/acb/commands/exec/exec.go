@@ -7,15 +7,20 @@ import (
 	gocontext "context"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"runtime"
 	"time"
 
 	"github.com/Azure/acr-builder/builder"
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/containerruntime"
 	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/Azure/acr-builder/pkg/volume"
 	"github.com/Azure/acr-builder/secretmgmt"
 	"github.com/Azure/acr-builder/templating"
+	"github.com/Azure/acr-builder/util"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
@@ -57,12 +62,85 @@ var Command = cli.Command{
 		},
 		cli.BoolFlag{
 			Name:  "dry-run",
-			Usage: "evaluates the command, but doesn't execute it",
+			Usage: "prints the execution plan of the command without executing it",
 		},
 		cli.BoolFlag{
 			Name:  "debug",
 			Usage: "enables diagnostic logging",
 		},
+		cli.StringFlag{
+			Name:  "report",
+			Usage: "the path to write a JSON report of the run to",
+		},
+		cli.StringFlag{
+			Name: "runtime",
+			Usage: fmt.Sprintf("the container runtime to use, either the %s, %s or %s CLI, or %s to call the Docker Engine API at DOCKER_HOST",
+				containerruntime.DockerRuntime, containerruntime.PodmanRuntime, containerruntime.NerdctlRuntime, containerruntime.EngineRuntime),
+			Value: containerruntime.DockerRuntime,
+		},
+		cli.StringFlag{
+			Name:  "runtime-socket",
+			Usage: "the host path of the runtime's Docker compatible API socket, mounted into the containers running the docker CLI (defaults to the runtime's)",
+		},
+		cli.IntFlag{
+			Name:  "stop-timeout",
+			Usage: "the number of seconds running steps are given to stop gracefully when the run is cancelled by a signal, before being killed",
+			Value: builder.DefaultStopTimeoutInSec,
+		},
+		cli.IntFlag{
+			Name:  "timeout",
+			Usage: "the maximum execution time of the task in seconds, overriding the task's timeout (0 uses the task's setting)",
+		},
+		cli.IntFlag{
+			Name:  "max-parallel",
+			Usage: "the maximum number of steps running at once, overriding the task's maxParallel (0 uses the task's setting)",
+		},
+		cli.BoolFlag{
+			Name:  "checkpoint",
+			Usage: "persists a checkpoint of the completed steps to the home volume, so that the run can be resumed with --resume",
+		},
+		cli.StringFlag{
+			Name:  "resume",
+			Usage: "the ID of a checkpointed run to resume, only running the steps which didn't complete",
+		},
+		cli.StringFlag{
+			Name:  "from-step",
+			Usage: "when resuming a run, the step to run again along with the steps depending on it",
+		},
+		cli.BoolFlag{
+			Name:  "log-prefix",
+			Usage: "prefixes every line of the steps' output with the ID of the step",
+		},
+		cli.BoolFlag{
+			Name:  "log-color",
+			Usage: "prefixes every line of the steps' output with the colorized ID of the step",
+		},
+		cli.StringFlag{
+			Name:  "log-dir",
+			Usage: "a directory to write the output of each step's attempts to, as <step ID>/attempt-<n>.log",
+		},
+		cli.StringFlag{
+			Name:  "cache-registry",
+			Usage: "a repository to share the cache entries of steps with a cacheKey through, e.g. oci://myregistry.azurecr.io/acb-cache",
+		},
+		cli.IntFlag{
+			Name:  "secret-concurrency",
+			Usage: "the maximum number of secrets and registry credentials resolved at once",
+			Value: secretmgmt.DefaultSecretResolveConcurrency,
+		},
+		cli.IntFlag{
+			Name:  "credential-ttl",
+			Usage: "the number of seconds after which registry credentials resolved from a vault or MSI are resolved again before running a step, logging in with the fresh credentials (0 never refreshes them)",
+			Value: builder.DefaultCredentialTTLInSec,
+		},
+		cli.StringFlag{
+			Name:  "secrets-file",
+			Usage: "the path to a YAML file mapping the IDs of the task's secrets to local values, used instead of resolving them from their vaults",
+		},
+		cli.StringSliceFlag{
+			Name:  "secret",
+			Usage: "a local value of one of the task's secrets in the id=value format, used instead of resolving it from its vault (use --secret multiple times)",
+		},
 
 		// Rendering options
 		cli.StringFlag{
@@ -129,6 +207,23 @@ var Command = cli.Command{
 			creds                   = context.StringSlice("credential")
 			dryRun                  = context.Bool("dry-run")
 			debug                   = context.Bool("debug")
+			reportFile              = context.String("report")
+			stopTimeout             = context.Int("stop-timeout")
+			runtimeKind             = context.String("runtime")
+			runtimeSocket           = context.String("runtime-socket")
+			timeout                 = context.Int("timeout")
+			maxParallel             = context.Int("max-parallel")
+			checkpoint              = context.Bool("checkpoint")
+			resume                  = context.String("resume")
+			fromStep                = context.String("from-step")
+			cacheRegistry           = context.String("cache-registry")
+			logPrefix               = context.Bool("log-prefix")
+			logColor                = context.Bool("log-color")
+			logDir                  = context.String("log-dir")
+			secretsFile             = context.String("secrets-file")
+			secrets                 = context.StringSlice("secret")
+			secretConcurrency       = context.Int("secret-concurrency")
+			credentialTTL           = context.Int("credential-ttl")
 
 			// Rendering options
 			values        = context.String("values")
@@ -150,46 +245,100 @@ var Command = cli.Command{
 			taskFile = defaultTaskFile
 		}
 
-		ctx := gocontext.Background()
+		// Resumed runs are checkpointed too, under their own ID if they have one.
+		checkpointRunID := ""
+		if checkpoint || resume != "" {
+			if homevol == "" {
+				return errors.New("checkpoints are persisted to the home volume, --homevol must be specified")
+			}
+			checkpointRunID = id
+			if checkpointRunID == "" {
+				checkpointRunID = resume
+			}
+			if checkpointRunID == "" {
+				return errors.New("checkpoints are persisted per run, --id must be specified")
+			}
+			if err := builder.ValidateRunID(checkpointRunID); err != nil {
+				return err
+			}
+			if resume != "" {
+				if err := builder.ValidateRunID(resume); err != nil {
+					return err
+				}
+			}
+		}
+		if fromStep != "" && resume == "" {
+			return errors.New("--from-step can only be specified along with --resume")
+		}
+
+		// Cancel the run when receiving an interrupt or a termination signal.
+		ctx, stop := util.NewSignalContext(gocontext.Background())
+		defer stop()
 		pm := procmanager.NewProcManager(dryRun)
+		// Mask the values of secrets in the output of the run and in the logs once they're known.
+		redactor := redact.NewRedactor()
+		pm.SetRedactor(redactor)
+		log.SetOutput(redactor.NewWriter(log.Writer()))
+		containerRuntime, runtimeCLI, err := builder.NewContainerRuntime(runtimeKind, runtimeSocket, pm)
+		if err != nil {
+			return err
+		}
 
+		if resume != "" && !dryRun {
+			// The checkpoint of the resumed run is persisted to its home volume, which must still exist.
+			if _, err := containerRuntime.InspectVolume(ctx, homevol); err != nil {
+				return errors.Wrapf(err, "failed to find the home volume %s of the resumed run", homevol)
+			}
+		}
 		if homevol == "" {
-			if !dryRun {
+			if dryRun {
+				// The home volume isn't created during a dry run, but it's named in the plan.
+				homevol = fmt.Sprintf("%s%s", volume.DockerVolumeHelperPrefix, "dryrun")
+			} else {
 				homevol = fmt.Sprintf("%s%s", volume.DockerVolumeHelperPrefix, uuid.New())
-				v := volume.NewDockerVolumeHelper(homevol, pm)
-				if msg, err := v.Create(ctx); err != nil {
-					return fmt.Errorf("failed to create volume. Msg: %s, Err: %v", msg, err)
+				if err := containerRuntime.CreateVolume(ctx, homevol); err != nil {
+					return fmt.Errorf("failed to create volume. Err: %v", err)
 				}
 				defer func() {
-					_, _ = v.Delete(ctx)
+					_ = containerRuntime.RemoveVolume(gocontext.WithoutCancel(ctx), homevol)
 				}()
 			}
 		}
 
 		renderOpts := &templating.BaseRenderOptions{
-			TaskFile:                taskFile,
-			Base64EncodedTaskFile:   encodedTaskFile,
-			ValuesFile:              values,
-			Base64EncodedValuesFile: encodedValues,
-			TemplateValues:          setVals,
-			ID:                      id,
-			Commit:                  commit,
-			Repository:              repository,
-			Branch:                  branch,
-			TriggeredBy:             triggeredBy,
-			GitTag:                  tag,
-			Registry:                registry,
-			Date:                    time.Now().UTC(),
-			SharedVolume:            homevol,
-			OS:                      runtime.GOOS,
-			OSVersion:               osVersion,
-			Architecture:            runtime.GOARCH,
-			SecretResolveTimeout:    secretmgmt.DefaultSecretResolveTimeout,
-			TaskName:                taskName,
+			TaskFile:                 taskFile,
+			Base64EncodedTaskFile:    encodedTaskFile,
+			ValuesFile:               values,
+			Base64EncodedValuesFile:  encodedValues,
+			TemplateValues:           setVals,
+			ID:                       id,
+			Commit:                   commit,
+			Repository:               repository,
+			Branch:                   branch,
+			TriggeredBy:              triggeredBy,
+			GitTag:                   tag,
+			Registry:                 registry,
+			Date:                     time.Now().UTC(),
+			SharedVolume:             homevol,
+			OS:                       runtime.GOOS,
+			OSVersion:                osVersion,
+			Architecture:             runtime.GOARCH,
+			SecretResolveTimeout:     secretmgmt.DefaultSecretResolveTimeout,
+			TaskName:                 taskName,
+			Redactor:                 redactor,
+			SecretResolveConcurrency: secretConcurrency,
+		}
+
+		if secretsFile != "" || len(secrets) > 0 {
+			localSecrets, err := loadLocalSecrets(secretsFile, secrets)
+			if err != nil {
+				return err
+			}
+			log.Println("WARNING: the task's secrets are resolved from local values instead of their vaults, this is only meant for local development")
+			renderOpts.ResolveSecretFunc = localSecrets.ResolveSecretFunc()
 		}
 
 		var template *templating.Template
-		var err error
 		if taskFile == "" {
 			if template, err = templating.DecodeTemplate(encodedTaskFile); err != nil {
 				return err
@@ -206,61 +355,105 @@ var Command = cli.Command{
 			return errors.Wrap(err, "error creating registry credentials from given list")
 		}
 
-		var task *graph.Task
-		var alias *graph.Alias
-
-		versionInUse := graph.FindVersion(template.GetData())
-		shouldIncludeAlias := versionInUse >= "v1.1.0"
-		if shouldIncludeAlias {
-			log.Printf("Alias support enabled for version >= 1.1.0, please see https://aka.ms/acr/tasks/task-aliases for more information.")
-			// separate alias and remaining data from the Task
-			aliasData, taskData := graph.SeparateAliasFromRest(template.GetData())
-
-			// render alias data
-			renderedAlias, renderAliasErr := templating.LoadAndRenderSteps(ctx, templating.NewTemplate("aliasData", aliasData), renderOpts)
-			if renderAliasErr != nil {
-				return errors.Wrap(renderAliasErr, "unable to render alias data")
-			}
-			aliasData = []byte(renderedAlias)
-			// Preprocess the task to replace all aliases based on the alias sources.
-			processedTask, _alias, aliasErr := graph.SearchReplaceAlias(template.GetData(), aliasData, taskData)
-			alias = _alias
-			if aliasErr != nil {
-				return errors.Wrap(aliasErr, "unable to search/replace aliases in task")
-			}
-			if debug {
-				log.Printf("Processed task before rendering data:\n%s", processedTask)
-			}
-			// update the template.Data
-			template.Data = processedTask
-		}
-
-		rendered, err := templating.LoadAndRenderSteps(ctx, template, renderOpts)
+		rendered, alias, err := templating.LoadAndRenderTask(ctx, template, renderOpts)
 		if err != nil {
-			return errors.Wrap(err, "unable to render task")
+			return err
 		}
 		if debug {
 			log.Printf("Rendered template:\n%s", rendered)
 		}
 
+		// Local fragments are resolved relative to the task file.
+		fragmentDir := ""
+		if taskFile != "" {
+			fragmentDir = filepath.Dir(taskFile)
+		}
 		task, errUnmarshal := graph.UnmarshalTaskFromString(ctx, rendered, &graph.TaskOptions{
-			DefaultWorkingDir: defaultWorkingDirectory,
-			Network:           defaultNetwork,
-			Envs:              defaultEnvs,
-			Credentials:       credentials,
-			TaskName:          taskName,
-			Registry:          registry,
+			DefaultWorkingDir:        defaultWorkingDirectory,
+			Network:                  defaultNetwork,
+			Envs:                     defaultEnvs,
+			Credentials:              credentials,
+			TaskName:                 taskName,
+			Registry:                 registry,
+			MaxParallel:              maxParallel,
+			Timeout:                  timeout,
+			FragmentDir:              fragmentDir,
+			SecretResolveConcurrency: secretConcurrency,
+			Run: graph.RunMetadata{
+				ID:          id,
+				Commit:      commit,
+				Repository:  repository,
+				Branch:      branch,
+				TriggeredBy: triggeredBy,
+				GitTag:      tag,
+				Registry:    registry,
+				TaskName:    taskName,
+			},
 		})
 		if errUnmarshal != nil {
-			return errors.Wrap(errUnmarshal, "failed to unmarshal task before running")
+			return redactor.RedactError(errors.Wrap(errUnmarshal, "failed to unmarshal task before running"))
 		}
 
-		if shouldIncludeAlias {
+		if alias != nil {
 			graph.ExpandCommandAliases(alias, task)
 		}
 
-		builder := builder.NewBuilder(pm, debug, homevol)
-		defer builder.CleanTask(gocontext.Background(), task) // Use a separate context since the other may have expired.
-		return builder.RunTask(gocontext.Background(), task)
+		b := builder.NewBuilder(pm, debug, homevol)
+		b.SetContainerRuntime(containerRuntime, runtimeCLI)
+		b.SetRedactor(redactor)
+		secretCache, err := secretmgmt.NewCredentialCache(time.Duration(credentialTTL)*time.Second, secretConcurrency)
+		if err != nil {
+			return err
+		}
+		b.SetSecretCache(secretCache)
+		if dryRun {
+			return b.NewPlan(task, rendered).Print(os.Stdout)
+		}
+		if checkpointRunID != "" {
+			b.EnableCheckpoint(checkpointRunID)
+		}
+		if cacheRegistry != "" {
+			b.SetCacheRegistry(cacheRegistry)
+		}
+		logSink := builder.NewConsoleLogSink(os.Stdout, os.Stderr, logPrefix || logColor, logColor)
+		if logDir != "" {
+			fileSink, err := builder.NewFileLogSink(logDir)
+			if err != nil {
+				return errors.Wrap(err, "failed to create the log directory")
+			}
+			logSink = builder.NewMultiLogSink(logSink, fileSink)
+		}
+		defer logSink.Close()
+		b.SetLogSink(logSink)
+		if resume != "" {
+			if err := b.Resume(ctx, resume, fromStep); err != nil {
+				return redactor.RedactError(err)
+			}
+		}
+		b.SetStopTimeout(time.Duration(stopTimeout) * time.Second)
+		defer b.CleanTask(gocontext.Background(), task) // Use a separate context since the other may have expired.
+		err = b.RunTask(ctx, task)
+		if reportFile != "" {
+			if reportErr := builder.NewRunReport(task, err).WriteToFile(reportFile); reportErr != nil {
+				log.Printf("Failed to write the run report: %v\n", reportErr)
+			}
+		}
+		return redactor.RedactError(err)
 	},
 }
+
+// loadLocalSecrets loads the secrets of the secrets file, overridden by the secrets specified with --secret.
+func loadLocalSecrets(secretsFile string, secrets []string) (secretmgmt.LocalSecrets, error) {
+	localSecrets := secretmgmt.LocalSecrets{}
+	if secretsFile != "" {
+		var err error
+		if localSecrets, err = secretmgmt.LoadLocalSecretsFile(secretsFile); err != nil {
+			return nil, err
+		}
+	}
+	flagSecrets, err := secretmgmt.ParseLocalSecrets(secrets)
+	if err != nil {
+		return nil, err
+	}
+	return localSecrets.Merge(flagSecrets), nil
+}
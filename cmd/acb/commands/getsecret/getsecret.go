@@ -28,21 +28,26 @@ var Command = cli.Command{
 	Subcommands: []cli.Command{
 		{
 			Name:  "keyvault",
-			Usage: "gets the secret value from a key vault. If it is an Azure Key Vault (AKV) secret, it is assumed that the host has the MSI token service running at http://169.254.169.254/.",
+			Usage: "gets the secret value from a key vault. If it is an Azure Key Vault (AKV) secret, certificate or key, it is assumed that the host has the MSI token service running at http://169.254.169.254/.",
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "url",
-					Usage: "the secret URL",
+					Usage: "the secret, certificate or key URL",
 				},
 				cli.StringFlag{
 					Name:  "client-id",
 					Usage: "the MSI user assigned identity client ID",
 				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "the format of a certificate (pem, pfx or cer) or key (pem or jwk). Defaults to pem",
+				},
 			},
 			Action: func(context *cli.Context) error {
 				var (
 					url      = context.String("url")
 					clientID = context.String("client-id")
+					format   = context.String("format")
 				)
 
 				if url == "" {
@@ -53,6 +58,9 @@ var Command = cli.Command{
 				if err != nil {
 					return err
 				}
+				if err := secretConfig.SetFormat(format); err != nil {
+					return err
+				}
 
 				timeout := time.Duration(defaultTimeoutInSeconds) * time.Second
 				ctx, cancel := gocontext.WithTimeout(gocontext.Background(), timeout)
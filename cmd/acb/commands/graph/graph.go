@@ -0,0 +1,164 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	gocontext "context"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/Azure/acr-builder/builder"
+	"github.com/Azure/acr-builder/cmd/acb/commands/validate"
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/secretmgmt"
+	"github.com/Azure/acr-builder/templating"
+	"github.com/urfave/cli"
+)
+
+const (
+	defaultTaskFile = "acb.yaml"
+)
+
+// Command prints the graph of a task file.
+var Command = cli.Command{
+	Name:  "graph",
+	Usage: "print the graph of a task file",
+	Flags: []cli.Flag{
+		// Task options
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "the path to the task file",
+		},
+		cli.StringFlag{
+			Name:  "encoded-file",
+			Usage: "a base64 encoded task file",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "the output format: dot, mermaid or json",
+			Value: graph.ExportFormatDot,
+		},
+		cli.StringFlag{
+			Name:  "report",
+			Usage: "the path to a run report to annotate the steps with their statuses",
+		},
+
+		// Rendering options
+		cli.StringFlag{
+			Name:  "values",
+			Usage: "the path to the values file to use for rendering",
+		},
+		cli.StringFlag{
+			Name:  "encoded-values",
+			Usage: "a base64 encoded values file to use for rendering",
+		},
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "the unique run identifier",
+		},
+		cli.StringFlag{
+			Name:  "commit,c",
+			Usage: "the commit SHA that triggered the run",
+		},
+		cli.StringFlag{
+			Name:  "repository",
+			Usage: "the run's repository",
+		},
+		cli.StringFlag{
+			Name:  "branch",
+			Usage: "the git branch",
+		},
+		cli.StringFlag{
+			Name:  "triggered-by",
+			Usage: "describes what the run was triggered by",
+		},
+		cli.StringFlag{
+			Name:  "git-tag",
+			Usage: "the git tag that triggered the run",
+		},
+		cli.StringFlag{
+			Name:  "registry,r",
+			Usage: "the fully qualified name of the registry",
+		},
+		cli.StringFlag{
+			Name:  "os-version",
+			Usage: "the version of the OS",
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "set values on the command line (use --set multiple times or use commas: key1=val1,key2=val2)",
+		},
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "the name of the task",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var (
+			// Task options
+			taskFile        = context.String("file")
+			encodedTaskFile = context.String("encoded-file")
+			format          = context.String("format")
+			reportFile      = context.String("report")
+
+			// Rendering options
+			values        = context.String("values")
+			encodedValues = context.String("encoded-values")
+			id            = context.String("id")
+			commit        = context.String("commit")
+			repository    = context.String("repository")
+			branch        = context.String("branch")
+			triggeredBy   = context.String("triggered-by")
+			tag           = context.String("git-tag")
+			registry      = context.String("registry")
+			osVersion     = context.String("os-version")
+			setVals       = context.StringSlice("set")
+			taskName      = context.String("name")
+		)
+
+		if taskFile == "" && encodedTaskFile == "" {
+			taskFile = defaultTaskFile
+		}
+
+		var statuses map[string]string
+		if reportFile != "" {
+			report, err := builder.ReadRunReportFromFile(reportFile)
+			if err != nil {
+				return err
+			}
+			statuses = make(map[string]string, len(report.Steps))
+			for _, step := range report.Steps {
+				statuses[step.ID] = string(step.Status)
+			}
+		}
+
+		renderOpts := &templating.BaseRenderOptions{
+			TaskFile:                taskFile,
+			Base64EncodedTaskFile:   encodedTaskFile,
+			ValuesFile:              values,
+			Base64EncodedValuesFile: encodedValues,
+			TemplateValues:          setVals,
+			ID:                      id,
+			Commit:                  commit,
+			Repository:              repository,
+			Branch:                  branch,
+			TriggeredBy:             triggeredBy,
+			GitTag:                  tag,
+			Registry:                registry,
+			Date:                    time.Now().UTC(),
+			OS:                      runtime.GOOS,
+			OSVersion:               osVersion,
+			Architecture:            runtime.GOARCH,
+			SecretResolveTimeout:    secretmgmt.DefaultSecretResolveTimeout,
+			TaskName:                taskName,
+		}
+
+		task, err := validate.LoadTask(gocontext.Background(), taskFile, encodedTaskFile, renderOpts)
+		if err != nil {
+			return err
+		}
+		return graph.NewExportedGraph(task, statuses).Write(os.Stdout, format)
+	},
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package validate
+
+import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/secretmgmt"
+	"github.com/Azure/acr-builder/templating"
+	"github.com/urfave/cli"
+)
+
+const (
+	defaultTaskFile = "acb.yaml"
+)
+
+// Command validates a task file without running it.
+var Command = cli.Command{
+	Name:  "validate",
+	Usage: "validate a task file without running it",
+	Flags: []cli.Flag{
+		// Task options
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "the path to the task file",
+		},
+		cli.StringFlag{
+			Name:  "encoded-file",
+			Usage: "a base64 encoded task file",
+		},
+
+		// Rendering options
+		cli.StringFlag{
+			Name:  "values",
+			Usage: "the path to the values file to use for rendering",
+		},
+		cli.StringFlag{
+			Name:  "encoded-values",
+			Usage: "a base64 encoded values file to use for rendering",
+		},
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "the unique run identifier",
+		},
+		cli.StringFlag{
+			Name:  "commit,c",
+			Usage: "the commit SHA that triggered the run",
+		},
+		cli.StringFlag{
+			Name:  "repository",
+			Usage: "the run's repository",
+		},
+		cli.StringFlag{
+			Name:  "branch",
+			Usage: "the git branch",
+		},
+		cli.StringFlag{
+			Name:  "triggered-by",
+			Usage: "describes what the run was triggered by",
+		},
+		cli.StringFlag{
+			Name:  "git-tag",
+			Usage: "the git tag that triggered the run",
+		},
+		cli.StringFlag{
+			Name:  "registry,r",
+			Usage: "the fully qualified name of the registry",
+		},
+		cli.StringFlag{
+			Name:  "os-version",
+			Usage: "the version of the OS",
+		},
+		cli.StringSliceFlag{
+			Name:  "set",
+			Usage: "set values on the command line (use --set multiple times or use commas: key1=val1,key2=val2)",
+		},
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "the name of the task",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var (
+			// Task options
+			taskFile        = context.String("file")
+			encodedTaskFile = context.String("encoded-file")
+
+			// Rendering options
+			values        = context.String("values")
+			encodedValues = context.String("encoded-values")
+			id            = context.String("id")
+			commit        = context.String("commit")
+			repository    = context.String("repository")
+			branch        = context.String("branch")
+			triggeredBy   = context.String("triggered-by")
+			tag           = context.String("git-tag")
+			registry      = context.String("registry")
+			osVersion     = context.String("os-version")
+			setVals       = context.StringSlice("set")
+			taskName      = context.String("name")
+		)
+
+		if taskFile == "" && encodedTaskFile == "" {
+			taskFile = defaultTaskFile
+		}
+
+		renderOpts := &templating.BaseRenderOptions{
+			TaskFile:                taskFile,
+			Base64EncodedTaskFile:   encodedTaskFile,
+			ValuesFile:              values,
+			Base64EncodedValuesFile: encodedValues,
+			TemplateValues:          setVals,
+			ID:                      id,
+			Commit:                  commit,
+			Repository:              repository,
+			Branch:                  branch,
+			TriggeredBy:             triggeredBy,
+			GitTag:                  tag,
+			Registry:                registry,
+			Date:                    time.Now().UTC(),
+			OS:                      runtime.GOOS,
+			OSVersion:               osVersion,
+			Architecture:            runtime.GOARCH,
+			SecretResolveTimeout:    secretmgmt.DefaultSecretResolveTimeout,
+			TaskName:                taskName,
+		}
+
+		task, err := LoadTask(gocontext.Background(), taskFile, encodedTaskFile, renderOpts)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Task is valid: %d step(s)\n", len(task.Steps))
+		return nil
+	},
+}
+
+// LoadTask renders and unmarshals a Task, which validates it and its graph.
+// It works offline, so secrets are never fetched from their vaults and resolve to empty values.
+func LoadTask(ctx gocontext.Context, taskFile string, encodedTaskFile string, renderOpts *templating.BaseRenderOptions) (*graph.Task, error) {
+	renderOpts.ResolveSecretFunc = resolveSecretPlaceholder
+
+	var template *templating.Template
+	var err error
+	if taskFile == "" {
+		if template, err = templating.DecodeTemplate(encodedTaskFile); err != nil {
+			return nil, err
+		}
+	} else {
+		if template, err = templating.LoadTemplate(taskFile); err != nil {
+			return nil, err
+		}
+	}
+
+	rendered, _, err := templating.LoadAndRenderTask(ctx, template, renderOpts)
+	if err != nil {
+		return nil, err
+	}
+	if rendered == "" {
+		return nil, errors.New("the task file is empty")
+	}
+
+	// Local fragments are resolved relative to the task file.
+	fragmentDir := ""
+	if taskFile != "" {
+		fragmentDir = filepath.Dir(taskFile)
+	}
+	task, err := graph.UnmarshalTaskFromString(ctx, rendered, &graph.TaskOptions{
+		FragmentDir: fragmentDir,
+		TaskName:    renderOpts.TaskName,
+		Registry:    renderOpts.Registry,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid task: %v", err)
+	}
+	return task, nil
+}
+
+// resolveSecretPlaceholder resolves every secret to an empty value.
+func resolveSecretPlaceholder(_ gocontext.Context, secret *secretmgmt.Secret, errorChan chan error) {
+	if secret == nil {
+		errorChan <- errors.New("secret cannot be nil")
+		return
+	}
+	secret.ResolvedValue = ""
+	secret.ResolvedChan <- true
+}
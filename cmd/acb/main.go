@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -13,17 +14,25 @@ import (
 	downloadCmd "github.com/Azure/acr-builder/cmd/acb/commands/download"
 	execCmd "github.com/Azure/acr-builder/cmd/acb/commands/exec"
 	getsecretCmd "github.com/Azure/acr-builder/cmd/acb/commands/getsecret"
+	graphCmd "github.com/Azure/acr-builder/cmd/acb/commands/graph"
 	renderCmd "github.com/Azure/acr-builder/cmd/acb/commands/render"
 	scanCmd "github.com/Azure/acr-builder/cmd/acb/commands/scan"
+	validateCmd "github.com/Azure/acr-builder/cmd/acb/commands/validate"
 	versionCmd "github.com/Azure/acr-builder/cmd/acb/commands/version"
 	"github.com/Azure/acr-builder/version"
 	"github.com/urfave/cli"
 )
 
+// cancelledExitCode is the exit code of runs cancelled by a signal, following the shell convention of 128 + SIGINT.
+const cancelledExitCode = 130
+
 func main() {
 	app := New()
 	if err := app.Run(os.Args); err != nil {
 		fmt.Fprintln(os.Stderr, formatErrorMessage(err))
+		if errors.Is(err, context.Canceled) {
+			os.Exit(cancelledExitCode)
+		}
 		os.Exit(1)
 	}
 }
@@ -38,8 +47,10 @@ func New() *cli.App {
 		buildCmd.Command,
 		downloadCmd.Command,
 		execCmd.Command,
+		graphCmd.Command,
 		renderCmd.Command,
 		scanCmd.Command,
+		validateCmd.Command,
 		versionCmd.Command,
 		getsecretCmd.Command,
 	}
@@ -0,0 +1,130 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/acr-builder/util"
+	"github.com/pkg/errors"
+)
+
+// cacheKeyVersion is part of every cache key, so that changing how keys are computed invalidates existing entries.
+const cacheKeyVersion = "v1"
+
+var (
+	// cachePathRE matches the files, globs and directories which can be part of a cache key or restored from the cache.
+	// Characters interpreted by the shell other than globs aren't allowed, since paths are expanded by a shell.
+	cachePathRE = regexp.MustCompile(`^[\w.\-/*?\[\]{}@+,=]+$`)
+
+	envNameRE = regexp.MustCompile(`^\w+$`)
+
+	errInvalidCacheKeyUse   = errors.New("cacheKey is only supported for cmd and build steps which aren't detached")
+	errCachePathsWithoutKey = errors.New("cachePaths can only be specified on steps with a cacheKey")
+	errCachePathsOnBuild    = errors.New("cachePaths are only supported for cmd steps, build steps restore their images")
+	errCacheKeyNoTags       = errors.New("build steps with a cacheKey must tag their image")
+)
+
+// CacheKey describes the inputs of a Step. When a Step with a CacheKey has already completed with the same inputs,
+// its results are restored from the cache instead of running it again.
+type CacheKey struct {
+	// Files are the files and globs, relative to the step's working directory, whose contents are part of the key.
+	// Globs support **.
+	Files []string `yaml:"files"`
+
+	// Env are the names of the environment variables whose values are part of the key.
+	Env []string `yaml:"env"`
+}
+
+// Equals determines whether or not two cache keys are equal.
+func (k *CacheKey) Equals(t *CacheKey) bool {
+	if k == nil && t == nil {
+		return true
+	}
+	if k == nil || t == nil {
+		return false
+	}
+	return util.StringSequenceEquals(k.Files, t.Files) &&
+		util.StringSequenceEquals(k.Env, t.Env)
+}
+
+// validateCacheKey validates the Step's cache key and cache paths.
+func (s *Step) validateCacheKey() error {
+	if s.CacheKey == nil {
+		if len(s.CachePaths) > 0 {
+			return errCachePathsWithoutKey
+		}
+		return nil
+	}
+	if s.Detach || (!s.IsCmdStep() && !s.IsBuildStep()) {
+		return errInvalidCacheKeyUse
+	}
+	if s.IsBuildStep() {
+		if len(s.CachePaths) > 0 {
+			return errCachePathsOnBuild
+		}
+		if len(s.Tags) == 0 {
+			return errCacheKeyNoTags
+		}
+	}
+	for _, p := range append(append([]string{}, s.CacheKey.Files...), s.CachePaths...) {
+		if err := validateCachePath(p); err != nil {
+			return err
+		}
+	}
+	for _, env := range s.CacheKey.Env {
+		if !envNameRE.MatchString(env) {
+			return fmt.Errorf("invalid cacheKey environment variable %q", env)
+		}
+	}
+	return nil
+}
+
+// validateCachePath ensures that the path is relative to the step's working directory and can't escape it.
+func validateCachePath(p string) error {
+	if !cachePathRE.MatchString(p) {
+		return fmt.Errorf("invalid cache path %q, paths may only contain letters, digits, globs and the characters ._-/@+,=", p)
+	}
+	if strings.HasPrefix(p, "-") {
+		return fmt.Errorf("invalid cache path %q, paths can't start with -", p)
+	}
+	if path.IsAbs(p) {
+		return fmt.Errorf("invalid cache path %q, paths must be relative to the step's working directory", p)
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return fmt.Errorf("invalid cache path %q, paths can't reference parent directories", p)
+		}
+	}
+	return nil
+}
+
+// ComputeCacheKey returns the Step's cache key, given the hash of the files of its cache key
+// and the ID of the image it runs or the digests of its base images, if any. The key also covers the Step's definition.
+func (s *Step) ComputeCacheKey(filesHash string, imageID string) string {
+	env := make(map[string]string, len(s.Envs))
+	for _, e := range s.Envs {
+		if pair := strings.SplitN(e, "=", 2); len(pair) == 2 {
+			env[pair[0]] = pair[1]
+		}
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "version=%s\n", cacheKeyVersion)
+	fmt.Fprintf(h, "type=%s\ncmd=%s\nbuild=%s\n", s.Type(), s.Cmd, s.Build)
+	fmt.Fprintf(h, "entryPoint=%s\nworkingDirectory=%s\n", s.EntryPoint, s.WorkingDirectory)
+	for _, p := range s.CachePaths {
+		fmt.Fprintf(h, "path=%s\n", p)
+	}
+	for _, name := range s.CacheKey.Env {
+		fmt.Fprintf(h, "env.%s=%s\n", name, env[name])
+	}
+	fmt.Fprintf(h, "files=%s\nimage=%s\n", filesHash, imageID)
+	return hex.EncodeToString(h.Sum(nil))
+}
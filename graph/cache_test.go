@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import "testing"
+
+func TestValidateCacheKey(t *testing.T) {
+	key := &CacheKey{Files: []string{"go.sum", "src/**/*.go"}, Env: []string{"GOFLAGS"}}
+	tests := []struct {
+		step        *Step
+		shouldError bool
+	}{
+		{&Step{ID: "a", Cmd: "a", CacheKey: key, CachePaths: []string{"bin", "out/*.tar"}}, false},
+		{&Step{ID: "a", Build: ".", Tags: []string{"app:v1"}, CacheKey: key}, false},
+		{&Step{ID: "a", Cmd: "a", CachePaths: []string{"bin"}}, true},
+		{&Step{ID: "a", Build: ".", CacheKey: key}, true},
+		{&Step{ID: "a", Build: ".", Tags: []string{"app:v1"}, CacheKey: key, CachePaths: []string{"bin"}}, true},
+		{&Step{ID: "a", Push: []string{"app:v1"}, CacheKey: key}, true},
+		{&Step{ID: "a", Cmd: "a", Detach: true, CacheKey: key}, true},
+		{&Step{ID: "a", Cmd: "a", CacheKey: &CacheKey{Files: []string{"/etc/passwd"}}}, true},
+		{&Step{ID: "a", Cmd: "a", CacheKey: &CacheKey{Files: []string{"../go.sum"}}}, true},
+		{&Step{ID: "a", Cmd: "a", CacheKey: &CacheKey{Files: []string{"go.sum; rm -rf /"}}}, true},
+		{&Step{ID: "a", Cmd: "a", CacheKey: &CacheKey{Files: []string{"go sum"}}}, true},
+		{&Step{ID: "a", Cmd: "a", CacheKey: &CacheKey{Files: []string{"it's"}}}, true},
+		{&Step{ID: "a", Cmd: "a", CacheKey: key, CachePaths: []string{"--to-command=id"}}, true},
+		{&Step{ID: "a", Cmd: "a", CacheKey: &CacheKey{Env: []string{"$(id)"}}}, true},
+	}
+	for i, test := range tests {
+		err := test.step.Validate()
+		if test.shouldError && err == nil {
+			t.Errorf("test %d: expected an error but got none", i)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestComputeCacheKey(t *testing.T) {
+	newStep := func(envs ...string) *Step {
+		return &Step{ID: "a", Cmd: "golang go build", Envs: envs, CacheKey: &CacheKey{Env: []string{"GOOS"}}}
+	}
+	key := newStep("GOOS=linux", "OTHER=1").ComputeCacheKey("files", "image")
+
+	tests := []struct {
+		key      string
+		expected bool
+	}{
+		{newStep("GOOS=linux", "OTHER=2").ComputeCacheKey("files", "image"), true},
+		{newStep("GOOS=windows").ComputeCacheKey("files", "image"), false},
+		{newStep("GOOS=linux").ComputeCacheKey("other", "image"), false},
+		{newStep("GOOS=linux").ComputeCacheKey("files", "other"), false},
+	}
+	for i, test := range tests {
+		if (test.key == key) != test.expected {
+			t.Errorf("test %d: expected the keys to match: %v", i, test.expected)
+		}
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	successFunc = "success"
+	failureFunc = "failure"
+	alwaysFunc  = "always"
+)
+
+// RunMetadata describes the run a Task is executed for.
+type RunMetadata struct {
+	ID          string
+	Commit      string
+	Repository  string
+	Branch      string
+	TriggeredBy string
+	GitTag      string
+	Registry    string
+	TaskName    string
+
+	// Status is the status of the Task's steps once they've all completed, i.e. succeeded or failed.
+	// It's only set for finally steps.
+	Status string
+}
+
+// ConditionContext provides the values a Step's if condition is evaluated against.
+type ConditionContext struct {
+	// Steps contains every step of the Task by ID.
+	Steps map[string]*Step
+
+	// Run describes the current run.
+	Run RunMetadata
+
+	// Env contains the environment variables of the Step being evaluated.
+	Env map[string]string
+
+	// DependencySkipped is true if a dependency of the Step was skipped or failed.
+	DependencySkipped bool
+
+	// DependencyFailed is true if a dependency of the Step failed, or if the Task is failing fast.
+	DependencyFailed bool
+}
+
+// condition is a parsed if expression.
+type condition struct {
+	root conditionNode
+
+	// usesStatusFunc is true if the expression calls success(), failure() or always().
+	// Otherwise, the expression is implicitly combined with success().
+	usesStatusFunc bool
+
+	// stepRefs contains the IDs of the steps referenced by the expression.
+	stepRefs []string
+
+	// outputRefs contains the step outputs referenced by the expression.
+	outputRefs []outputReference
+}
+
+type conditionNode interface {
+	eval(ctx *ConditionContext) (interface{}, error)
+}
+
+// parseCondition parses an if expression.
+func parseCondition(expr string) (*condition, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens, cond: &condition{}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q", p.tokens[p.pos].text)
+	}
+	p.cond.root = root
+	return p.cond, nil
+}
+
+// evaluate evaluates the condition against the specified context.
+func (c *condition) evaluate(ctx *ConditionContext) (bool, error) {
+	if !c.usesStatusFunc && ctx.DependencySkipped {
+		return false, nil
+	}
+	val, err := c.root.eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	return toBool(val), nil
+}
+
+type tokenKind int
+
+const (
+	tokenOperator tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenIdent
+)
+
+type conditionToken struct {
+	kind tokenKind
+	text string
+}
+
+var conditionOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"}
+
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in condition: %s", expr)
+			}
+			tokens = append(tokens, conditionToken{kind: tokenString, text: string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, conditionToken{kind: tokenNumber, text: string(runes[i:end])})
+			i = end
+		case isIdentRune(r):
+			end := i
+			for end < len(runes) && (isIdentRune(runes[end]) || runes[end] == '.' || runes[end] == '-') {
+				end++
+			}
+			tokens = append(tokens, conditionToken{kind: tokenIdent, text: string(runes[i:end])})
+			i = end
+		default:
+			matched := false
+			for _, op := range conditionOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, conditionToken{kind: tokenOperator, text: op})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q in condition: %s", r, expr)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+	cond   *condition
+}
+
+func (p *conditionParser) peekOperator(ops ...string) string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenOperator {
+		return ""
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].text == op {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOperator("||") != "" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOperator("&&") != "" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	if p.peekOperator("!") != "" {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (conditionNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peekOperator("==", "!=", "<=", ">=", "<", ">"); op != "" {
+		p.pos++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &comparisonNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parsePrimary() (conditionNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of condition")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok.kind {
+	case tokenString:
+		return &literalNode{value: tok.text}, nil
+	case tokenNumber:
+		num, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in condition", tok.text)
+		}
+		return &literalNode{value: num}, nil
+	case tokenOperator:
+		if tok.text != "(" {
+			return nil, fmt.Errorf("unexpected token %q", tok.text)
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peekOperator(")") == "" {
+			return nil, errors.New("missing closing parenthesis in condition")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	// Identifiers: literals, status functions or property references.
+	switch tok.text {
+	case "true":
+		return &literalNode{value: true}, nil
+	case "false":
+		return &literalNode{value: false}, nil
+	}
+	if p.peekOperator("(") != "" {
+		p.pos++
+		if p.peekOperator(")") == "" {
+			return nil, fmt.Errorf("function %s() doesn't take any arguments", tok.text)
+		}
+		p.pos++
+		switch tok.text {
+		case successFunc, failureFunc, alwaysFunc:
+			p.cond.usesStatusFunc = true
+			return &statusFuncNode{name: tok.text}, nil
+		default:
+			return nil, fmt.Errorf("unknown function %s() in condition", tok.text)
+		}
+	}
+	ref, err := newReferenceNode(tok.text)
+	if err != nil {
+		return nil, err
+	}
+	if ref.stepID != "" {
+		p.cond.stepRefs = append(p.cond.stepRefs, ref.stepID)
+	}
+	if ref.output != "" {
+		p.cond.outputRefs = append(p.cond.outputRefs, outputReference{stepID: ref.stepID, name: ref.output})
+	}
+	return ref, nil
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(_ *ConditionContext) (interface{}, error) {
+	return n.value, nil
+}
+
+type notNode struct {
+	operand conditionNode
+}
+
+func (n *notNode) eval(ctx *ConditionContext) (interface{}, error) {
+	val, err := n.operand.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return !toBool(val), nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right conditionNode
+}
+
+func (n *logicalNode) eval(ctx *ConditionContext) (interface{}, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" && !toBool(left) {
+		return false, nil
+	}
+	if n.op == "||" && toBool(left) {
+		return true, nil
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return toBool(right), nil
+}
+
+type comparisonNode struct {
+	op          string
+	left, right conditionNode
+}
+
+func (n *comparisonNode) eval(ctx *ConditionContext) (interface{}, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare numerically if both sides are numbers, otherwise compare their string representations.
+	var cmp int
+	leftNum, leftIsNum := toNumber(left)
+	rightNum, rightIsNum := toNumber(right)
+	if leftIsNum && rightIsNum {
+		switch {
+		case leftNum < rightNum:
+			cmp = -1
+		case leftNum > rightNum:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(toString(left), toString(right))
+	}
+
+	switch n.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+type statusFuncNode struct {
+	name string
+}
+
+func (n *statusFuncNode) eval(ctx *ConditionContext) (interface{}, error) {
+	switch n.name {
+	case successFunc:
+		return !ctx.DependencySkipped, nil
+	case failureFunc:
+		return ctx.DependencyFailed, nil
+	default:
+		return true, nil
+	}
+}
+
+// referenceNode references a property of a step, the run or the environment, e.g.
+// steps.build.status, steps.build.outputs.version, run.branch or env.FOO.
+type referenceNode struct {
+	path   []string
+	stepID string
+	output string
+}
+
+var runProperties = map[string]func(RunMetadata) string{
+	"id":          func(r RunMetadata) string { return r.ID },
+	"commit":      func(r RunMetadata) string { return r.Commit },
+	"repository":  func(r RunMetadata) string { return r.Repository },
+	"branch":      func(r RunMetadata) string { return r.Branch },
+	"triggeredBy": func(r RunMetadata) string { return r.TriggeredBy },
+	"gitTag":      func(r RunMetadata) string { return r.GitTag },
+	"registry":    func(r RunMetadata) string { return r.Registry },
+	"taskName":    func(r RunMetadata) string { return r.TaskName },
+	"status":      func(r RunMetadata) string { return r.Status },
+}
+
+func newReferenceNode(ref string) (*referenceNode, error) {
+	path := strings.Split(ref, ".")
+	n := &referenceNode{path: path}
+	switch path[0] {
+	case "steps":
+		if len(path) == 4 && path[2] == "outputs" {
+			n.output = path[3]
+		} else if len(path) != 3 {
+			return nil, fmt.Errorf("invalid step reference %q, expected steps.<id>.<property> or steps.<id>.outputs.<name>", ref)
+		} else if path[2] != "status" && path[2] != "exitCode" {
+			return nil, fmt.Errorf("unknown step property %q in %q, expected status, exitCode or outputs", path[2], ref)
+		}
+		n.stepID = path[1]
+	case "run":
+		if len(path) != 2 {
+			return nil, fmt.Errorf("invalid run reference %q, expected run.<property>", ref)
+		}
+		if _, ok := runProperties[path[1]]; !ok {
+			return nil, fmt.Errorf("unknown run property %q in %q", path[1], ref)
+		}
+	case "env":
+		if len(path) != 2 {
+			return nil, fmt.Errorf("invalid env reference %q, expected env.<name>", ref)
+		}
+	default:
+		return nil, fmt.Errorf("unknown reference %q in condition, expected steps, run or env", ref)
+	}
+	return n, nil
+}
+
+func (n *referenceNode) eval(ctx *ConditionContext) (interface{}, error) {
+	switch n.path[0] {
+	case "steps":
+		step, ok := ctx.Steps[n.stepID]
+		if !ok {
+			return nil, fmt.Errorf("condition references unknown step %s", n.stepID)
+		}
+		switch {
+		case n.output != "":
+			return step.OutputValues[n.output], nil
+		case n.path[2] == "status":
+			return string(step.StepStatus), nil
+		default:
+			return float64(step.ExitCode), nil
+		}
+	case "run":
+		return runProperties[n.path[1]](ctx.Run), nil
+	default:
+		return ctx.Env[n.path[1]], nil
+	}
+}
+
+func toBool(val interface{}) bool {
+	switch v := val.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	default:
+		return false
+	}
+}
+
+func toNumber(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case string:
+		num, err := strconv.ParseFloat(v, 64)
+		return num, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func toString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"testing"
+)
+
+func TestEvaluateCondition(t *testing.T) {
+	ctx := &ConditionContext{
+		Steps: map[string]*Step{
+			"build":     {ID: "build", StepStatus: Successful},
+			"unit-test": {ID: "unit-test", StepStatus: Failed, ExitCode: 2},
+		},
+		Run: RunMetadata{Branch: "main", TriggeredBy: "commit", GitTag: "v1.2.0"},
+		Env: map[string]string{"DEPLOY": "true", "REPLICAS": "3"},
+	}
+
+	tests := []struct {
+		expr              string
+		dependencySkipped bool
+		dependencyFailed  bool
+		expected          bool
+	}{
+		{"run.branch == 'main'", false, false, true},
+		{"run.branch == \"dev\"", false, false, false},
+		{"run.branch != 'main' || run.triggeredBy == 'commit'", false, false, true},
+		{"run.gitTag && env.DEPLOY == 'true'", false, false, true},
+		{"!(run.branch == 'main')", false, false, false},
+		{"steps.build.status == 'successful'", false, false, true},
+		{"steps.unit-test.exitCode == 2", false, false, true},
+		{"steps.unit-test.exitCode > 1 && steps.unit-test.exitCode <= 2", false, false, true},
+		{"env.REPLICAS >= 10", false, false, false},
+		{"env.MISSING", false, false, false},
+		{"true", false, false, true},
+
+		// Without a status function, the condition is implicitly combined with success().
+		{"run.branch == 'main'", true, false, false},
+		{"success() && run.branch == 'main'", true, false, false},
+		{"failure()", true, true, true},
+		{"failure()", false, false, false},
+		{"failure() && steps.unit-test.status == 'failed'", true, true, true},
+		{"always()", true, true, true},
+	}
+
+	for _, test := range tests {
+		step := &Step{ID: "s", If: test.expr}
+		ctx.DependencySkipped = test.dependencySkipped
+		ctx.DependencyFailed = test.dependencyFailed
+		actual, err := step.EvaluateCondition(ctx)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.expr, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%s: expected %v but got %v", test.expr, test.expected, actual)
+		}
+	}
+}
+
+func TestParseCondition_Errors(t *testing.T) {
+	tests := []string{
+		"",
+		"run.branch ==",
+		"(run.branch == 'main'",
+		"run.branch == 'main",
+		"run.unknown == 'x'",
+		"steps.build == 'x'",
+		"steps.build.outputs == 'x'",
+		"foo.bar",
+		"cancelled()",
+		"success(1)",
+		"run.branch = 'main'",
+	}
+	for _, test := range tests {
+		if _, err := parseCondition(test); err == nil {
+			t.Errorf("%q: expected an error but got none", test)
+		}
+	}
+}
+
+func TestNewDagFromTask_ConditionReferences(t *testing.T) {
+	task := &Task{
+		Steps: []*Step{
+			{ID: "a", Cmd: "a"},
+			{ID: "b", Cmd: "b", When: []string{ImmediateExecutionToken}},
+			{ID: "c", Cmd: "c", When: []string{"a"}, If: "steps.a.status == 'successful'"},
+			{ID: "d", Cmd: "d", When: []string{"c"}, If: "steps.a.exitCode == 0 && steps.b.exitCode == 0"},
+			{ID: "e", Cmd: "e", When: []string{"a"}, If: "steps.missing.status == 'failed'"},
+		},
+	}
+	_, err := NewDagFromTask(task)
+	valErr, ok := err.(*DagValidationError)
+	if !ok {
+		t.Fatalf("expected a *DagValidationError but got %v", err)
+	}
+	expected := []string{
+		"step d's condition references step b, which it doesn't depend on",
+		"step e's condition references unknown step missing",
+	}
+	if len(valErr.InvalidConditions) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, valErr.InvalidConditions)
+	}
+	for i := range expected {
+		if valErr.InvalidConditions[i] != expected[i] {
+			t.Errorf("expected %q but got %q", expected[i], valErr.InvalidConditions[i])
+		}
+	}
+}
@@ -5,6 +5,7 @@ package graph
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -41,11 +42,21 @@ func (n *Node) GetDegree() int {
 	return n.degree
 }
 
+// Edge represents a directed edge between two vertices in a Dag.
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
 // Dag represents a thread safe directed acyclic graph.
 type Dag struct {
 	Root  *Node
 	Nodes map[string]*Node
 	mu    sync.Mutex
+
+	// edges records every edge added to the Dag. Unlike the nodes' children,
+	// it isn't modified by RemoveEdge, so it still describes the graph after execution.
+	edges []*Edge
 }
 
 // NewDag creates a new Dag with a root vertex.
@@ -57,17 +68,65 @@ func NewDag() *Dag {
 }
 
 // NewDagFromTask creates a new Dag based on the specified Task.
+// The Dag is validated before it's returned, see Dag.Validate.
 func NewDagFromTask(t *Task) (*Dag, error) {
+	return newDagFromSteps(t, nil)
+}
+
+// NewFinallyDagFromTask creates a new Dag based on the specified Task's finally steps.
+// Finally steps may reference any of the Task's steps, which have all completed by the time they run.
+func NewFinallyDagFromTask(t *Task) (*Dag, error) {
+	completed := make(map[string]*Step, len(t.Steps))
+	for _, step := range t.Steps {
+		completed[step.ID] = step
+	}
+	finally := &Task{Steps: t.Finally}
+	dag, err := newDagFromSteps(finally, completed)
+	t.Finally = finally.Steps
+	return dag, err
+}
+
+// newDagFromSteps creates a new Dag based on the Task's steps. The steps may also reference
+// the completed steps, which aren't part of the Dag.
+func newDagFromSteps(t *Task, completed map[string]*Step) (*Dag, error) {
 	dag := NewDag()
 
-	var prevStep *Step
+	// Steps with a matrix are replaced by their instances, which can be referenced as a group.
+	groups, err := expandMatrices(t)
+	if err != nil {
+		return dag, err
+	}
+	// Steps expanded from a fragment can be referenced as a group by the ID of the step using it.
+	for _, step := range t.Steps {
+		for _, fragment := range step.fragments {
+			groups[fragment] = append(groups[fragment], step.ID)
+		}
+	}
+
 	for _, step := range t.Steps {
 		if err := step.Validate(); err != nil {
 			return dag, err
 		}
+		if _, ok := completed[step.ID]; ok {
+			return dag, fmt.Errorf("finally step %s has the same ID as a step", step.ID)
+		}
 		if _, err := dag.AddVertex(step); err != nil {
 			return dag, err
 		}
+	}
+
+	// Edges are added once all vertices exist so that steps can reference
+	// any other step, regardless of the order they're declared in.
+	var unknownRefs []string
+	disconnected := make(map[string]bool)
+	var prevIDs, groupPrevIDs []string
+	currentGroup := ""
+	for _, step := range t.Steps {
+		// Instances of the same matrix all depend on the step preceding the matrix.
+		if step.matrixGroup == "" || step.matrixGroup != currentGroup {
+			groupPrevIDs = prevIDs
+			currentGroup = step.matrixGroup
+		}
 
 		// If the step is parallel, add it to the root
 		if step.ShouldExecuteImmediately() {
@@ -76,28 +135,167 @@ func NewDagFromTask(t *Task) (*Dag, error) {
 			}
 		} else if step.HasNoWhen() {
 			// If the step has no when, add it to the root or the previous step
-			if prevStep == nil {
+			if len(groupPrevIDs) == 0 {
 				if err := dag.AddEdge(rootNodeID, step.ID); err != nil {
 					return dag, err
 				}
-			} else {
-				if err := dag.AddEdge(prevStep.ID, step.ID); err != nil {
+			}
+			for _, prevID := range groupPrevIDs {
+				if err := dag.AddEdge(prevID, step.ID); err != nil {
 					return dag, err
 				}
 			}
 		} else {
 			// Otherwise, add edges according to when
 			for _, dep := range step.When {
-				if err := dag.AddEdge(dep, step.ID); err != nil {
-					return dag, err
+				deps, isGroup := groups[dep]
+				if !isGroup {
+					deps = []string{dep}
+				}
+				for _, dep := range deps {
+					if _, ok := dag.Nodes[dep]; !ok {
+						unknownRefs = append(unknownRefs, fmt.Sprintf("step %s references unknown step %s", step.ID, dep))
+						disconnected[step.ID] = true
+						continue
+					}
+					if err := dag.AddEdge(dep, step.ID); err != nil {
+						return dag, err
+					}
 				}
 			}
 		}
 
-		prevStep = step
+		if len(step.fragments) > 0 {
+			prevIDs = groups[step.fragments[0]]
+		} else if step.matrixGroup != "" {
+			prevIDs = groups[step.matrixGroup]
+		} else {
+			prevIDs = []string{step.ID}
+		}
+	}
+
+	err = dag.Validate()
+	invalidConditions := dag.validateConditions(t.Steps, completed)
+	invalidOutputRefs := dag.validateOutputReferences(t.Steps, completed)
+	if len(unknownRefs) == 0 && len(invalidConditions) == 0 && len(invalidOutputRefs) == 0 {
+		return dag, err
+	}
+	valErr, ok := err.(*DagValidationError)
+	if !ok {
+		valErr = &DagValidationError{}
+	}
+	valErr.UnknownReferences = unknownRefs
+	valErr.InvalidConditions = invalidConditions
+	valErr.InvalidOutputReferences = invalidOutputRefs
+
+	// Steps referencing unknown steps are missing edges, so they and their descendants
+	// are only reported through their unknown references.
+	for _, name := range sortedKeys(disconnected) {
+		markDescendants(dag.Nodes[name], disconnected)
+	}
+	var unreachable []string
+	for _, name := range valErr.Unreachable {
+		if !disconnected[name] {
+			unreachable = append(unreachable, name)
+		}
+	}
+	valErr.Unreachable = unreachable
+	return dag, valErr
+}
+
+// validateConditions ensures that the steps referenced by each step's if condition exist
+// and are either ancestors of the step or completed, so that they've completed when the condition
+// is evaluated, and that the outputs it references are declared.
+func (d *Dag) validateConditions(steps []*Step, completed map[string]*Step) []string {
+	var problems []string
+	for _, step := range steps {
+		if step.condition == nil || len(step.condition.stepRefs) == 0 {
+			continue
+		}
+		ancestors := d.ancestors(step.ID)
+		for _, ref := range step.condition.stepRefs {
+			if _, ok := completed[ref]; ok {
+				continue
+			}
+			if _, ok := d.Nodes[ref]; !ok {
+				problems = append(problems, fmt.Sprintf("step %s's condition references unknown step %s", step.ID, ref))
+			} else if !ancestors[ref] {
+				problems = append(problems, fmt.Sprintf("step %s's condition references step %s, which it doesn't depend on", step.ID, ref))
+			}
+		}
+		for _, ref := range step.condition.outputRefs {
+			if s, ok := d.lookup(ref.stepID, completed); ok && !s.declaresOutput(ref.name) {
+				problems = append(problems, fmt.Sprintf("step %s's condition references output %s, which step %s doesn't declare", step.ID, ref.name, ref.stepID))
+			}
+		}
+	}
+	return problems
+}
+
+// validateOutputReferences ensures that every output referenced by a step is declared
+// by one of the step's ancestors or by a completed step, so that it's available when the reference is resolved.
+func (d *Dag) validateOutputReferences(steps []*Step, completed map[string]*Step) []string {
+	var problems []string
+	for _, step := range steps {
+		refs := step.outputReferences()
+		if len(refs) == 0 {
+			continue
+		}
+		ancestors := d.ancestors(step.ID)
+		for _, ref := range refs {
+			s, ok := d.lookup(ref.stepID, completed)
+			_, isCompleted := completed[ref.stepID]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("step %s references output %s of unknown step %s", step.ID, ref.name, ref.stepID))
+			case !s.declaresOutput(ref.name):
+				problems = append(problems, fmt.Sprintf("step %s references output %s, which step %s doesn't declare", step.ID, ref.name, ref.stepID))
+			case !isCompleted && !ancestors[ref.stepID]:
+				problems = append(problems, fmt.Sprintf("step %s references output %s of step %s, which it doesn't depend on", step.ID, ref.name, ref.stepID))
+			}
+		}
+	}
+	return problems
+}
+
+// lookup returns the step with the specified ID, either from the Dag's vertices or from the completed steps.
+func (d *Dag) lookup(id string, completed map[string]*Step) (*Step, bool) {
+	if node, ok := d.Nodes[id]; ok {
+		return node.Value, true
+	}
+	step, ok := completed[id]
+	return step, ok
+}
+
+// ancestors returns the names of every vertex the specified vertex depends on, directly or transitively.
+func (d *Dag) ancestors(name string) map[string]bool {
+	parents := make(map[string][]string)
+	for _, edge := range d.Edges() {
+		parents[edge.To] = append(parents[edge.To], edge.From)
 	}
+	ancestors := make(map[string]bool)
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, parent := range parents[current] {
+			if parent != rootNodeID && !ancestors[parent] {
+				ancestors[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return ancestors
+}
 
-	return dag, nil
+// markDescendants marks every descendant of the node in the specified map.
+func markDescendants(n *Node, marked map[string]bool) {
+	for _, child := range n.Children() {
+		if !marked[child.Name] {
+			marked[child.Name] = true
+			markDescendants(child, marked)
+		}
+	}
 }
 
 // AddVertex adds a vertex to the Dag with the specified name and value.
@@ -126,6 +324,10 @@ func (d *Dag) AddEdge(from string, to string) error {
 	fromNode.children[to] = toNode
 	fromNode.mu.Unlock()
 
+	d.mu.Lock()
+	d.edges = append(d.edges, &Edge{From: from, To: to})
+	d.mu.Unlock()
+
 	toNode.mu.Lock()
 	toNode.degree++
 	toNode.mu.Unlock()
@@ -135,9 +337,17 @@ func (d *Dag) AddEdge(from string, to string) error {
 
 // RemoveEdge removes the edge between from and to.
 func (d *Dag) RemoveEdge(from string, to string) error {
+	_, err := d.RemoveEdgeAndGetDegree(from, to)
+	return err
+}
+
+// RemoveEdgeAndGetDegree removes the edge between from and to and returns the remaining degree of to.
+// The degree is read atomically with the removal, so only a single caller can observe
+// the degree reaching 0 when multiple edges to the same vertex are removed concurrently.
+func (d *Dag) RemoveEdgeAndGetDegree(from string, to string) (int, error) {
 	fromNode, toNode, err := d.validateFromAndTo(from, to)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	fromNode.mu.Lock()
@@ -145,10 +355,54 @@ func (d *Dag) RemoveEdge(from string, to string) error {
 	fromNode.mu.Unlock()
 
 	toNode.mu.Lock()
+	defer toNode.mu.Unlock()
 	toNode.degree--
-	toNode.mu.Unlock()
+	return toNode.degree, nil
+}
 
-	return nil
+// Edges returns every edge that has been added to the Dag, including the ones
+// originating from the root vertex, in the order they were added.
+func (d *Dag) Edges() []*Edge {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	edges := make([]*Edge, len(d.edges))
+	copy(edges, d.edges)
+	return edges
+}
+
+// Dependencies returns the names of the vertices with an edge to the specified vertex,
+// excluding the root vertex.
+func (d *Dag) Dependencies(name string) []string {
+	var deps []string
+	for _, edge := range d.Edges() {
+		if edge.To == name && edge.From != rootNodeID {
+			deps = append(deps, edge.From)
+		}
+	}
+	return deps
+}
+
+// Descendants returns the names of every vertex depending on the specified vertex, directly or transitively.
+func (d *Dag) Descendants(name string) []string {
+	children := make(map[string][]string)
+	for _, edge := range d.Edges() {
+		children[edge.From] = append(children[edge.From], edge.To)
+	}
+	var descendants []string
+	visited := make(map[string]bool)
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, child := range children[current] {
+			if !visited[child] {
+				visited[child] = true
+				descendants = append(descendants, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	return descendants
 }
 
 // Children returns the node's children.
@@ -164,6 +418,93 @@ func (n *Node) Children() []*Node {
 	return childNodes
 }
 
+// Validate ensures that the Dag doesn't contain any cycles and that every vertex
+// can be reached from the root. All problems found are returned together as a *DagValidationError.
+func (d *Dag) Validate() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	valErr := &DagValidationError{}
+	state := make(map[string]int, len(d.Nodes))
+	inCycle := make(map[string]bool)
+
+	var path []string
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		state[n.Name] = visiting
+		path = append(path, n.Name)
+		for _, child := range sortedChildren(n) {
+			switch state[child.Name] {
+			case unvisited:
+				visit(child)
+			case visiting:
+				// Found a back edge, extract the cycle from the current path.
+				start := 0
+				for i, name := range path {
+					if name == child.Name {
+						start = i
+						break
+					}
+				}
+				cycle := append([]string{}, path[start:]...)
+				cycle = append(cycle, child.Name)
+				for _, name := range cycle {
+					inCycle[name] = true
+				}
+				valErr.Cycles = append(valErr.Cycles, cycle)
+			}
+		}
+		path = path[:len(path)-1]
+		state[n.Name] = visited
+	}
+
+	visit(d.Root)
+	reachable := make(map[string]bool, len(state))
+	for name := range state {
+		reachable[name] = true
+	}
+
+	// Vertices which are unreachable from the root can only be part of,
+	// or depend on, a cycle. Visit them as well to report those cycles.
+	for _, name := range sortedKeys(d.Nodes) {
+		if state[name] == unvisited {
+			visit(d.Nodes[name])
+		}
+	}
+	for _, name := range sortedKeys(d.Nodes) {
+		if !reachable[name] && !inCycle[name] {
+			valErr.Unreachable = append(valErr.Unreachable, name)
+		}
+	}
+
+	if valErr.HasErrors() {
+		return valErr
+	}
+	return nil
+}
+
+// sortedChildren returns the node's children sorted by name.
+func sortedChildren(n *Node) []*Node {
+	children := n.Children()
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name < children[j].Name
+	})
+	return children
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys[T any](nodes map[string]T) []string {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (d *Dag) validateFromAndTo(from string, to string) (fromNode *Node, toNode *Node, err error) {
 	if from == "" {
 		return nil, nil, errors.New("from cannot be empty")
@@ -0,0 +1,49 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DagValidationError describes every problem found while validating a Dag.
+type DagValidationError struct {
+	// UnknownReferences describes each when dependency referencing a step which doesn't exist.
+	UnknownReferences []string
+
+	// InvalidConditions describes each if condition referencing a step which doesn't exist,
+	// or which isn't guaranteed to have completed when the condition is evaluated.
+	InvalidConditions []string
+
+	// InvalidOutputReferences describes each reference to an output which isn't declared,
+	// or which belongs to a step that isn't guaranteed to have completed when it's resolved.
+	InvalidOutputReferences []string
+
+	// Cycles contains the path of each cycle found, starting and ending with the same step.
+	Cycles [][]string
+
+	// Unreachable contains the steps that can never run because they depend on a cycle.
+	Unreachable []string
+}
+
+// HasErrors returns true if any problem has been recorded, false otherwise.
+func (e *DagValidationError) HasErrors() bool {
+	return len(e.UnknownReferences) > 0 || len(e.InvalidConditions) > 0 || len(e.InvalidOutputReferences) > 0 || len(e.Cycles) > 0 || len(e.Unreachable) > 0
+}
+
+// Error returns the error message for a DagValidationError.
+func (e *DagValidationError) Error() string {
+	var problems []string
+	problems = append(problems, e.UnknownReferences...)
+	problems = append(problems, e.InvalidConditions...)
+	problems = append(problems, e.InvalidOutputReferences...)
+	for _, cycle := range e.Cycles {
+		problems = append(problems, fmt.Sprintf("cycle detected: %s", strings.Join(cycle, " -> ")))
+	}
+	if len(e.Unreachable) > 0 {
+		problems = append(problems, fmt.Sprintf("unreachable steps: %s", strings.Join(e.Unreachable, ", ")))
+	}
+	return fmt.Sprintf("invalid task graph: %s", strings.Join(problems, "; "))
+}
@@ -284,3 +284,153 @@ func verifyChildren(expected map[string]*Step, actual []*Node) error {
 
 	return nil
 }
+
+func TestNewDagFromTask_Validation(t *testing.T) {
+	tests := []struct {
+		name                string
+		steps               []*Step
+		expectedUnknownRefs int
+		expectedCycles      [][]string
+		expectedUnreachable []string
+	}{
+		{
+			"forward reference",
+			[]*Step{
+				{ID: "a", Cmd: "a", When: []string{"b"}},
+				{ID: "b", Cmd: "b", When: []string{ImmediateExecutionToken}},
+			},
+			0,
+			nil,
+			nil,
+		},
+		{
+			"unknown references",
+			[]*Step{
+				{ID: "a", Cmd: "a", When: []string{"x"}},
+				{ID: "b", Cmd: "b", When: []string{"y"}},
+			},
+			2,
+			nil,
+			nil,
+		},
+		{
+			"cycle with unreachable descendant",
+			[]*Step{
+				{ID: "a", Cmd: "a", When: []string{"c"}},
+				{ID: "b", Cmd: "b", When: []string{"a"}},
+				{ID: "c", Cmd: "c", When: []string{"b"}},
+				{ID: "d", Cmd: "d", When: []string{"c"}},
+			},
+			0,
+			[][]string{{"a", "b", "c", "a"}},
+			[]string{"d"},
+		},
+		{
+			"cycle reachable from the root",
+			[]*Step{
+				{ID: "a", Cmd: "a"},
+				{ID: "b", Cmd: "b", When: []string{"a", "c"}},
+				{ID: "c", Cmd: "c", When: []string{"b"}},
+			},
+			0,
+			[][]string{{"b", "c", "b"}},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := NewDagFromTask(&Task{Steps: test.steps})
+		if test.expectedUnknownRefs == 0 && len(test.expectedCycles) == 0 && len(test.expectedUnreachable) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected err: %v", test.name, err)
+			}
+			continue
+		}
+		valErr, ok := err.(*DagValidationError)
+		if !ok {
+			t.Errorf("%s: expected a DagValidationError but got: %v", test.name, err)
+			continue
+		}
+		if len(valErr.UnknownReferences) != test.expectedUnknownRefs {
+			t.Errorf("%s: expected %d unknown references but got %v", test.name, test.expectedUnknownRefs, valErr.UnknownReferences)
+		}
+		if len(valErr.Cycles) != len(test.expectedCycles) {
+			t.Errorf("%s: expected cycles %v but got %v", test.name, test.expectedCycles, valErr.Cycles)
+		} else {
+			for i, cycle := range test.expectedCycles {
+				if !util.StringSequenceEquals(cycle, valErr.Cycles[i]) {
+					t.Errorf("%s: expected cycle %v but got %v", test.name, cycle, valErr.Cycles[i])
+				}
+			}
+		}
+		if !util.StringSequenceEquals(test.expectedUnreachable, valErr.Unreachable) {
+			t.Errorf("%s: expected unreachable steps %v but got %v", test.name, test.expectedUnreachable, valErr.Unreachable)
+		}
+	}
+}
+
+func TestNewFinallyDagFromTask(t *testing.T) {
+	steps := []*Step{
+		{ID: "build", Cmd: "build", Outputs: []*Output{{Name: "version"}}},
+	}
+	tests := []struct {
+		name        string
+		finally     []*Step
+		shouldError bool
+	}{
+		{
+			"references to steps",
+			[]*Step{{ID: "report", Cmd: "report {{.Steps.build.Outputs.version}}", If: "steps.build.status == 'failed'"}},
+			false,
+		},
+		{
+			"undeclared output",
+			[]*Step{{ID: "report", Cmd: "report {{.Steps.build.Outputs.digest}}"}},
+			true,
+		},
+		{
+			"duplicate ID",
+			[]*Step{{ID: "build", Cmd: "cleanup"}},
+			true,
+		},
+		{
+			"when referencing a step",
+			[]*Step{{ID: "report", Cmd: "report", When: []string{"build"}}},
+			true,
+		},
+	}
+	for _, test := range tests {
+		task := &Task{Steps: steps, Finally: test.finally}
+		_, err := NewFinallyDagFromTask(task)
+		if test.shouldError && err == nil {
+			t.Errorf("%s: expected an error but got none", test.name)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestDagDescendants(t *testing.T) {
+	task := &Task{Steps: []*Step{
+		{ID: "a", Cmd: "a"},
+		{ID: "b", Cmd: "b", When: []string{"a"}},
+		{ID: "c", Cmd: "c", When: []string{"b"}},
+		{ID: "d", Cmd: "d", When: []string{"a", "c"}},
+		{ID: "e", Cmd: "e", When: []string{ImmediateExecutionToken}},
+	}}
+	dag, err := NewDagFromTask(task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := map[string][]string{
+		"a": {"b", "d", "c"},
+		"c": {"d"},
+		"e": nil,
+	}
+	for name, expected := range tests {
+		if actual := dag.Descendants(name); !util.StringSequenceEquals(actual, expected) {
+			t.Errorf("expected the descendants of %s to be %v but got %v", name, expected, actual)
+		}
+	}
+}
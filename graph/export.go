@@ -0,0 +1,203 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The formats a Task's graph can be exported to.
+const (
+	ExportFormatDot     = "dot"
+	ExportFormatMermaid = "mermaid"
+	ExportFormatJSON    = "json"
+)
+
+const (
+	// finallyRootNodeID is the ID of the exported root of the Task's finally steps.
+	finallyRootNodeID = "acb_finally"
+
+	rootNodeType = "root"
+)
+
+// mermaidStatusStyles are the styles of the Mermaid classes nodes are assigned based on their status.
+var mermaidStatusStyles = []struct {
+	status StepStatus
+	style  string
+}{
+	{Successful, "fill:#d4edda,stroke:#28a745"},
+	{Failed, "fill:#f8d7da,stroke:#dc3545"},
+	{Skipped, "fill:#e2e3e5,stroke:#6c757d"},
+	{Cancelled, "fill:#fff3cd,stroke:#ffc107"},
+}
+
+// ExportedGraph describes the graph of a Task, including the implicit root and the edges between
+// consecutive steps which don't specify when.
+type ExportedGraph struct {
+	Nodes []*ExportedNode `json:"nodes"`
+	Edges []*ExportedEdge `json:"edges"`
+}
+
+// ExportedNode is a vertex of an ExportedGraph.
+type ExportedNode struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Status  string `json:"status,omitempty"`
+	Finally bool   `json:"finally,omitempty"`
+}
+
+// ExportedEdge is an edge of an ExportedGraph. Implicit edges aren't declared by the step's when.
+type ExportedEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Implicit bool   `json:"implicit,omitempty"`
+}
+
+// NewExportedGraph creates an ExportedGraph from the Task's graphs. The statuses of the steps,
+// e.g. read from a run report, are optional.
+func NewExportedGraph(t *Task, statuses map[string]string) *ExportedGraph {
+	g := &ExportedGraph{
+		Nodes: []*ExportedNode{{ID: rootNodeID, Type: rootNodeType}},
+		Edges: []*ExportedEdge{},
+	}
+	g.add(t.Dag, t.Steps, rootNodeID, false, statuses)
+	if len(t.Finally) > 0 {
+		g.Nodes = append(g.Nodes, &ExportedNode{ID: finallyRootNodeID, Type: rootNodeType, Finally: true})
+		g.add(t.FinallyDag, t.Finally, finallyRootNodeID, true, statuses)
+	}
+	return g
+}
+
+// add adds the steps and the edges of the Dag to the graph, renaming its root.
+func (g *ExportedGraph) add(dag *Dag, steps []*Step, root string, finally bool, statuses map[string]string) {
+	for _, step := range steps {
+		g.Nodes = append(g.Nodes, &ExportedNode{
+			ID:      step.ID,
+			Type:    step.Type(),
+			Status:  statuses[step.ID],
+			Finally: finally,
+		})
+	}
+	if dag == nil {
+		return
+	}
+	for _, edge := range dag.Edges() {
+		from := edge.From
+		if from == rootNodeID {
+			from = root
+		}
+		implicit := false
+		if node, ok := dag.Nodes[edge.To]; ok {
+			implicit = node.Value.HasNoWhen()
+		}
+		g.Edges = append(g.Edges, &ExportedEdge{From: from, To: edge.To, Implicit: implicit})
+	}
+}
+
+// Write writes the graph to w in the specified format.
+func (g *ExportedGraph) Write(w io.Writer, format string) error {
+	switch format {
+	case ExportFormatDot:
+		return g.writeDot(w)
+	case ExportFormatMermaid:
+		return g.writeMermaid(w)
+	case ExportFormatJSON:
+		data, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	default:
+		return fmt.Errorf("unsupported graph format %q, must be one of %s, %s or %s", format, ExportFormatDot, ExportFormatMermaid, ExportFormatJSON)
+	}
+}
+
+// label returns the lines describing the node.
+func (n *ExportedNode) label() []string {
+	if n.Type == rootNodeType {
+		if n.Finally {
+			return []string{"finally"}
+		}
+		return []string{"root"}
+	}
+	lines := []string{n.ID, n.Type}
+	if n.Status != "" {
+		lines = append(lines, n.Status)
+	}
+	return lines
+}
+
+func (g *ExportedGraph) writeDot(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph acb {\n")
+	for _, n := range g.Nodes {
+		shape := "box"
+		if n.Type == rootNodeType {
+			shape = "circle"
+		}
+		fmt.Fprintf(&sb, "  %q [label=%q, shape=%s", n.ID, strings.Join(n.label(), "\n"), shape)
+		switch StepStatus(n.Status) {
+		case Successful:
+			sb.WriteString(", color=green")
+		case Failed:
+			sb.WriteString(", color=red")
+		case Skipped:
+			sb.WriteString(", color=gray")
+		case Cancelled:
+			sb.WriteString(", color=orange")
+		}
+		sb.WriteString("];\n")
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&sb, "  %q -> %q", e.From, e.To)
+		if e.Implicit {
+			sb.WriteString(" [style=dashed]")
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (g *ExportedGraph) writeMermaid(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("flowchart TD\n")
+
+	// Step IDs may contain characters Mermaid doesn't allow in node IDs, so nodes are numbered.
+	ids := make(map[string]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+		label := strings.ReplaceAll(strings.Join(n.label(), "<br/>"), `"`, "#quot;")
+		if n.Type == rootNodeType {
+			fmt.Fprintf(&sb, "  %s((\"%s\"))\n", ids[n.ID], label)
+		} else {
+			fmt.Fprintf(&sb, "  %s[\"%s\"]\n", ids[n.ID], label)
+		}
+	}
+	for _, e := range g.Edges {
+		arrow := "-->"
+		if e.Implicit {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&sb, "  %s %s %s\n", ids[e.From], arrow, ids[e.To])
+	}
+	for _, s := range mermaidStatusStyles {
+		var nodes []string
+		for _, n := range g.Nodes {
+			if StepStatus(n.Status) == s.status {
+				nodes = append(nodes, ids[n.ID])
+			}
+		}
+		if len(nodes) > 0 {
+			fmt.Fprintf(&sb, "  classDef %s %s\n  class %s %s\n", s.status, s.style, strings.Join(nodes, ","), s.status)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
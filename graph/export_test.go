@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func newExportTestGraph(t *testing.T) *ExportedGraph {
+	t.Helper()
+	task, err := UnmarshalTaskFromString(context.Background(), `
+steps:
+  - id: build
+    build: -t app .
+  - id: test
+    cmd: app test
+  - id: lint
+    cmd: golint
+    when: ["-"]
+  - id: push
+    push: ["app"]
+    when: ["test", "lint"]
+finally:
+  - id: report
+    cmd: report
+`, &TaskOptions{})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	return NewExportedGraph(task, map[string]string{"build": "successful", "test": "failed"})
+}
+
+func TestNewExportedGraph(t *testing.T) {
+	g := newExportTestGraph(t)
+
+	expectedNodes := []ExportedNode{
+		{ID: rootNodeID, Type: rootNodeType},
+		{ID: "build", Type: BuildStepType, Status: "successful"},
+		{ID: "test", Type: CmdStepType, Status: "failed"},
+		{ID: "lint", Type: CmdStepType},
+		{ID: "push", Type: PushStepType},
+		{ID: finallyRootNodeID, Type: rootNodeType, Finally: true},
+		{ID: "report", Type: CmdStepType, Finally: true},
+	}
+	if len(g.Nodes) != len(expectedNodes) {
+		t.Fatalf("expected %d nodes but got %d", len(expectedNodes), len(g.Nodes))
+	}
+	for i, expected := range expectedNodes {
+		if *g.Nodes[i] != expected {
+			t.Errorf("expected node %d to be %+v but got %+v", i, expected, *g.Nodes[i])
+		}
+	}
+
+	expectedEdges := []ExportedEdge{
+		{From: rootNodeID, To: "build", Implicit: true},
+		{From: "build", To: "test", Implicit: true},
+		{From: rootNodeID, To: "lint"},
+		{From: "test", To: "push"},
+		{From: "lint", To: "push"},
+		{From: finallyRootNodeID, To: "report", Implicit: true},
+	}
+	if len(g.Edges) != len(expectedEdges) {
+		t.Fatalf("expected %d edges but got %d", len(expectedEdges), len(g.Edges))
+	}
+	for i, expected := range expectedEdges {
+		if *g.Edges[i] != expected {
+			t.Errorf("expected edge %d to be %+v but got %+v", i, expected, *g.Edges[i])
+		}
+	}
+}
+
+func TestExportedGraphWrite(t *testing.T) {
+	g := newExportTestGraph(t)
+	tests := []struct {
+		format   string
+		expected []string
+	}{
+		{ExportFormatDot, []string{`"build" [label="build\nbuild\nsuccessful", shape=box, color=green];`, `"build" -> "test" [style=dashed];`, `"test" -> "push";`}},
+		{ExportFormatMermaid, []string{`n1["build<br/>build<br/>successful"]`, "n1 -.-> n2", "n2 --> n4", "class n2 failed"}},
+		{ExportFormatJSON, []string{`"implicit": true`, `"status": "failed"`}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := g.Write(&buf, test.format); err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.format, err)
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("%s: expected the output to contain %q but got:\n%s", test.format, expected, buf.String())
+			}
+		}
+	}
+	if err := g.Write(&bytes.Buffer{}, "svg"); err == nil {
+		t.Error("expected an unsupported format to fail")
+	}
+}
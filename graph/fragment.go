@@ -0,0 +1,374 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/acr-builder/util"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/file"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+const (
+	// maxFragmentDepth limits how deeply fragments can use other fragments.
+	maxFragmentDepth = 10
+
+	fragmentFetchTimeout = 30 * time.Second
+)
+
+var (
+	// withReferenceRE matches references to fragment parameters, e.g. {{.With.image}}.
+	withReferenceRE = regexp.MustCompile(`\{\{\s*\.With\.(\w+)\s*\}\}`)
+
+	// conditionStepReferenceRE matches the step IDs referenced in conditions, e.g. steps.build.status.
+	conditionStepReferenceRE = regexp.MustCompile(`\bsteps\.([\w-]+)\.`)
+
+	errUsesWithoutID    = errors.New("steps with uses must specify an ID")
+	errUsesWithCommand  = errors.New("steps with uses can't specify cmd, build or push")
+	errFragmentTooDeep  = fmt.Errorf("fragments can't be nested more than %d levels deep", maxFragmentDepth)
+	errFragmentNoSteps  = errors.New("fragment must contain at least one step")
+	errFragmentNotFound = errors.New("the artifact doesn't contain a fragment, expected a single .yaml or .yml file")
+
+	errUsesWithStepProperties = errors.New("steps with uses can only specify id, when and with, other properties must be set on the fragment's steps")
+)
+
+// Fragment is a reusable sequence of steps which is referenced by steps with uses.
+type Fragment struct {
+	Params []*FragmentParam `yaml:"params"`
+	Steps  []*Step          `yaml:"steps"`
+}
+
+// FragmentParam is a parameter of a Fragment, set by the with values of the step using it
+// and referenced as {{.With.name}}.
+type FragmentParam struct {
+	Name     string `yaml:"name"`
+	Default  string `yaml:"default"`
+	Required bool   `yaml:"required"`
+}
+
+// fragmentLoader loads the fragments referenced by a Task's steps.
+type fragmentLoader struct {
+	credentials RegistryLoginCredentials
+}
+
+// load returns the contents of the fragment at the specified location, which is either a local file
+// relative to baseDir, a URL or a registry artifact. The directory nested local fragments are resolved
+// against is also returned.
+func (l *fragmentLoader) load(ctx context.Context, uses string, baseDir string) ([]byte, string, error) {
+	switch {
+	case util.IsRegistryArtifact(uses):
+		data, err := l.loadFromRegistry(ctx, util.TrimArtifactPrefix(uses))
+		return data, baseDir, err
+	case util.IsURL(uses):
+		data, err := loadFragmentFromURL(ctx, uses)
+		return data, baseDir, err
+	default:
+		path := uses
+		if !filepath.IsAbs(path) && baseDir != "" {
+			path = filepath.Join(baseDir, path)
+		}
+		data, err := os.ReadFile(path)
+		return data, filepath.Dir(path), err
+	}
+}
+
+// loadFragmentFromURL downloads a fragment.
+func loadFragmentFromURL(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fragmentFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// loadFromRegistry pulls a fragment stored as an artifact, which must contain a single YAML file.
+func (l *fragmentLoader) loadFromRegistry(ctx context.Context, registryArtifact string) ([]byte, error) {
+	src, err := remote.NewRepository(registryArtifact)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse artifact %s", registryArtifact)
+	}
+	src.Client = &auth.Client{
+		Header: http.Header{
+			"User-Agent":           {"oras-go"},
+			"X-Meta-Source-Client": {"azure/acr/tasks"},
+		},
+		Cache: auth.DefaultCache,
+		Credential: func(_ context.Context, registry string) (auth.Credential, error) {
+			// If no matching credential found, attempt an anonymous pull
+			if l.credentials[registry] == nil {
+				return auth.EmptyCredential, nil
+			}
+			return auth.Credential{
+				Username: l.credentials[registry].Username.ResolvedValue,
+				Password: l.credentials[registry].Password.ResolvedValue,
+			}, nil
+		},
+	}
+
+	dir, err := os.MkdirTemp("", "acb_fragment_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	dest, err := file.New(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to pull artifact to %s", dir)
+	}
+	defer dest.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, fragmentFetchTimeout)
+	defer cancel()
+	if _, err := oras.Copy(ctx, src, src.Reference.Reference, dest, "", oras.DefaultCopyOptions); err != nil {
+		return nil, errors.Wrap(err, "failed to pull artifact from registry")
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) != 1 {
+		return nil, errFragmentNotFound
+	}
+	return os.ReadFile(files[0])
+}
+
+// expandFragments replaces each of the Task's steps with uses by the steps of the fragment it references.
+// Local fragments are resolved against the directory of the Task.
+func (t *Task) expandFragments(ctx context.Context) error {
+	loader := &fragmentLoader{credentials: t.RegistryLoginCredentials}
+	steps, err := loader.expand(ctx, t.Steps, t.fragmentDir, nil)
+	if err != nil {
+		return err
+	}
+	finally, err := loader.expand(ctx, t.Finally, t.fragmentDir, nil)
+	if err != nil {
+		return err
+	}
+	t.Steps, t.Finally = steps, finally
+	return nil
+}
+
+// expand expands the steps with uses in the specified steps, recursively.
+// parents are the IDs of the steps with uses the steps were expanded from, outermost first.
+func (l *fragmentLoader) expand(ctx context.Context, steps []*Step, baseDir string, parents []string) ([]*Step, error) {
+	if len(parents) > maxFragmentDepth {
+		return nil, errFragmentTooDeep
+	}
+
+	var expanded []*Step
+	prevID := ""
+	for _, step := range steps {
+		if step.Uses == "" {
+			expanded = append(expanded, step)
+			prevID = step.ID
+			continue
+		}
+		if step.ID == "" {
+			return nil, errUsesWithoutID
+		}
+		if step.IsCmdStep() || step.IsBuildStep() || step.IsPushStep() {
+			return nil, errors.Wrapf(errUsesWithCommand, "invalid step ID: %s", step.ID)
+		}
+		if step.hasPropertiesBesidesUses() {
+			return nil, errors.Wrapf(errUsesWithStepProperties, "invalid step ID: %s", step.ID)
+		}
+
+		data, fragmentDir, err := l.load(ctx, step.Uses, baseDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load fragment %s for step ID: %s", step.Uses, step.ID)
+		}
+		fragment := &Fragment{}
+		if err := yaml.Unmarshal(data, fragment); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse fragment %s for step ID: %s", step.Uses, step.ID)
+		}
+
+		// The fragment's first steps inherit the dependencies of the step using it.
+		entryWhen := step.When
+		if len(entryWhen) == 0 {
+			if prevID == "" {
+				entryWhen = []string{ImmediateExecutionToken}
+			} else {
+				entryWhen = []string{prevID}
+			}
+		}
+		fragmentSteps, err := fragment.instantiate(step, entryWhen, parents)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid fragment %s for step ID: %s", step.Uses, step.ID)
+		}
+
+		nested := append(append([]string{}, parents...), step.ID)
+		fragmentSteps, err = l.expand(ctx, fragmentSteps, fragmentDir, nested)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, fragmentSteps...)
+		prevID = step.ID
+	}
+	return expanded, nil
+}
+
+// hasPropertiesBesidesUses returns whether the step specifies properties other than id, when, uses and with,
+// which would be silently dropped when it's replaced by the steps of its fragment.
+func (s *Step) hasPropertiesBesidesUses() bool {
+	stripped := *s
+	stripped.ID, stripped.When, stripped.Uses, stripped.With, stripped.fragments = "", nil, "", nil, nil
+	return !reflect.DeepEqual(stripped, Step{})
+}
+
+// instantiate creates the Fragment's steps for the specified step using it. The steps are namespaced
+// with the ID of the step using the fragment, and the fragment's parameters are set to its with values.
+func (f *Fragment) instantiate(uses *Step, entryWhen []string, parents []string) ([]*Step, error) {
+	if len(f.Steps) == 0 {
+		return nil, errFragmentNoSteps
+	}
+	values, err := f.paramValues(uses.With)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]string, len(f.Steps))
+	for i, s := range f.Steps {
+		if s.ID == "" {
+			s.ID = fmt.Sprintf("step_%d", i)
+		}
+		if _, ok := ids[s.ID]; ok {
+			return nil, fmt.Errorf("duplicate step ID: %s", s.ID)
+		}
+		ids[s.ID] = uses.ID + "_" + s.ID
+	}
+	prevID := ""
+	namespaced := func(id string) string {
+		if n, ok := ids[id]; ok {
+			return n
+		}
+		return id
+	}
+
+	fragments := append(append([]string{}, parents...), uses.ID)
+	for i, s := range f.Steps {
+		var when []string
+		switch {
+		case s.HasNoWhen():
+			if i == 0 {
+				when = entryWhen
+			} else {
+				when = []string{prevID}
+			}
+		case s.ShouldExecuteImmediately():
+			when = entryWhen
+		default:
+			for _, dep := range s.When {
+				n, ok := ids[dep]
+				if !ok {
+					return nil, fmt.Errorf("step %s references unknown step %s", s.ID, dep)
+				}
+				when = append(when, n)
+			}
+		}
+		s.When = append([]string{}, when...)
+		s.ID = ids[s.ID]
+		prevID = s.ID
+		s.fragments = fragments
+
+		// References to other steps of the fragment are namespaced before the parameters are set,
+		// so that with values can reference the steps of the task using the fragment.
+		s.If = conditionStepReferenceRE.ReplaceAllStringFunc(s.If, func(ref string) string {
+			return "steps." + namespaced(conditionStepReferenceRE.FindStringSubmatch(ref)[1]) + "."
+		})
+		substitute := func(v string) string {
+			v = outputReferenceRE.ReplaceAllStringFunc(v, func(ref string) string {
+				match := outputReferenceRE.FindStringSubmatch(ref)
+				return OutputReferencePlaceholder(namespaced(match[1]), match[2])
+			})
+			return withReferenceRE.ReplaceAllStringFunc(v, func(ref string) string {
+				if val, ok := values[withReferenceRE.FindStringSubmatch(ref)[1]]; ok {
+					return val
+				}
+				return ref
+			})
+		}
+		for _, prop := range s.fragmentProperties() {
+			*prop = substitute(*prop)
+		}
+		for k, v := range s.With {
+			s.With[k] = substitute(v)
+		}
+	}
+
+	for _, s := range f.Steps {
+		for _, prop := range s.fragmentProperties() {
+			if match := withReferenceRE.FindStringSubmatch(*prop); match != nil {
+				return nil, fmt.Errorf("step %s references parameter %s, which isn't declared", s.ID, match[1])
+			}
+		}
+	}
+	return f.Steps, nil
+}
+
+// paramValues returns the values of the Fragment's parameters given the with values of the step using it.
+func (f *Fragment) paramValues(with map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(f.Params))
+	for _, p := range f.Params {
+		if p.Name == "" {
+			return nil, errors.New("fragment parameters must have a name")
+		}
+		val, ok := with[p.Name]
+		if !ok {
+			if p.Required {
+				return nil, fmt.Errorf("missing required parameter %s", p.Name)
+			}
+			val = p.Default
+		}
+		values[p.Name] = val
+	}
+
+	var unknown []string
+	for name := range with {
+		if _, ok := values[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown parameters: %s", strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
+
+// fragmentProperties returns the properties of the Step in which fragment parameters can be referenced.
+func (s *Step) fragmentProperties() []*string {
+	return append(s.referenceableProperties(), &s.If, &s.User, &s.Network, &s.Uses)
+}
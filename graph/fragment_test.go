@@ -0,0 +1,161 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/Azure/acr-builder/util"
+)
+
+func TestUnmarshalTaskFromFile_Fragments(t *testing.T) {
+	task, err := UnmarshalTaskFromFile(context.Background(), filepath.Join("testdata", "fragments", "acb.yaml"), &TaskOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedIDs := []string{"build", "release_scan", "release_sign_step_0", "release_push", "notify"}
+	if len(task.Steps) != len(expectedIDs) {
+		t.Fatalf("expected %d steps but got %d", len(expectedIDs), len(task.Steps))
+	}
+	for i, id := range expectedIDs {
+		if task.Steps[i].ID != id {
+			t.Errorf("expected step %d to be %s but got %s", i, id, task.Steps[i].ID)
+		}
+	}
+
+	if task.Steps[1].Cmd != "scanner app:v1" {
+		t.Errorf("unexpected cmd: %s", task.Steps[1].Cmd)
+	}
+	if task.Steps[2].Cmd != "sign --key default-key app:v1" {
+		t.Errorf("unexpected cmd: %s", task.Steps[2].Cmd)
+	}
+	if !util.StringSequenceEquals(task.Steps[3].Push, []string{"app:v1"}) {
+		t.Errorf("unexpected push: %v", task.Steps[3].Push)
+	}
+	if task.Steps[3].If != "steps.release_scan.outputs.report == 'clean'" {
+		t.Errorf("unexpected if: %s", task.Steps[3].If)
+	}
+
+	expectedDeps := map[string][]string{
+		"release_scan":        {"build"},
+		"release_sign_step_0": {"release_scan"},
+		"release_push":        {"release_sign_step_0"},
+		"notify":              {"release_push", "release_scan", "release_sign_step_0"},
+	}
+	for id, expected := range expectedDeps {
+		actual := task.Dag.Dependencies(id)
+		sort.Strings(actual)
+		if !util.StringSequenceEquals(actual, expected) {
+			t.Errorf("expected %s to depend on %v but got %v", id, expected, actual)
+		}
+	}
+}
+
+func TestFragmentInstantiate(t *testing.T) {
+	tests := []struct {
+		name        string
+		fragment    *Fragment
+		with        map[string]string
+		shouldError bool
+	}{
+		{
+			name:     "valid fragment",
+			fragment: &Fragment{Steps: []*Step{{ID: "a", Cmd: "a"}, {ID: "b", Cmd: "b", When: []string{"a"}}}},
+		},
+		{
+			name:        "no steps",
+			fragment:    &Fragment{},
+			shouldError: true,
+		},
+		{
+			name:        "missing required parameter",
+			fragment:    &Fragment{Params: []*FragmentParam{{Name: "image", Required: true}}, Steps: []*Step{{Cmd: "a"}}},
+			shouldError: true,
+		},
+		{
+			name:        "unknown parameter",
+			fragment:    &Fragment{Steps: []*Step{{Cmd: "a"}}},
+			with:        map[string]string{"image": "app"},
+			shouldError: true,
+		},
+		{
+			name:        "undeclared parameter reference",
+			fragment:    &Fragment{Steps: []*Step{{Cmd: "a {{.With.image}}"}}},
+			shouldError: true,
+		},
+		{
+			name:        "unknown step reference",
+			fragment:    &Fragment{Steps: []*Step{{ID: "a", Cmd: "a", When: []string{"build"}}}},
+			shouldError: true,
+		},
+		{
+			name:        "duplicate step IDs",
+			fragment:    &Fragment{Steps: []*Step{{ID: "a", Cmd: "a"}, {ID: "a", Cmd: "b"}}},
+			shouldError: true,
+		},
+	}
+	for _, test := range tests {
+		uses := &Step{ID: "uses", Uses: "fragment.yaml", With: test.with}
+		_, err := test.fragment.instantiate(uses, []string{ImmediateExecutionToken}, nil)
+		if test.shouldError && err == nil {
+			t.Errorf("%s: expected an error but got none", test.name)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestExpandFragments_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []*Step
+	}{
+		{"missing ID", []*Step{{Uses: "fragment.yaml"}}},
+		{"uses with cmd", []*Step{{ID: "a", Uses: "fragment.yaml", Cmd: "a"}}},
+		{"missing fragment", []*Step{{ID: "a", Uses: "missing.yaml"}}},
+		{"recursive fragment", []*Step{{ID: "a", Uses: filepath.Join("testdata", "fragments", "recursive.yaml")}}},
+	}
+	for _, test := range tests {
+		task := &Task{Steps: test.steps}
+		if err := task.expandFragments(context.Background()); err == nil {
+			t.Errorf("%s: expected an error but got none", test.name)
+		}
+	}
+}
+
+func TestExpandFragments_StepProperties(t *testing.T) {
+	uses := filepath.Join("testdata", "fragments", "common", "sign.yaml")
+	tests := []struct {
+		name        string
+		step        *Step
+		shouldError bool
+	}{
+		{"id, when and with", &Step{ID: "a", Uses: uses, When: []string{"-"}, With: map[string]string{"image": "app:v1"}}, false},
+		{"if", &Step{ID: "a", Uses: uses, If: "env.SIGN == 'true'"}, true},
+		{"env", &Step{ID: "a", Uses: uses, Envs: []string{"SIGN=true"}}, true},
+		{"timeout", &Step{ID: "a", Uses: uses, Timeout: 60}, true},
+		{"retries", &Step{ID: "a", Uses: uses, Retries: 2}, true},
+		{"retryPolicy", &Step{ID: "a", Uses: uses, RetryPolicy: &RetryPolicy{}}, true},
+		{"locks", &Step{ID: "a", Uses: uses, Locks: []string{"signer"}}, true},
+		{"ignoreErrors", &Step{ID: "a", Uses: uses, IgnoreErrors: true}, true},
+		{"cacheKey", &Step{ID: "a", Uses: uses, CacheKey: &CacheKey{}}, true},
+		{"matrix", &Step{ID: "a", Uses: uses, Matrix: Matrix{"os": {"linux"}}}, true},
+	}
+	for _, test := range tests {
+		task := &Task{Steps: []*Step{test.step}}
+		err := task.expandFragments(context.Background())
+		if test.shouldError && !errors.Is(err, errUsesWithStepProperties) {
+			t.Errorf("%s: expected the step's properties to be rejected but got: %v", test.name, err)
+		}
+		if !test.shouldError && errors.Is(err, errUsesWithStepProperties) {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/acr-builder/util"
+	"github.com/pkg/errors"
+)
+
+var (
+	// matrixReferenceRE matches references to matrix values, e.g. {{.Matrix.arch}}.
+	matrixReferenceRE = regexp.MustCompile(`\{\{\s*\.Matrix\.(\w+)\s*\}\}`)
+
+	matrixKeyRE = regexp.MustCompile(`^\w+$`)
+
+	// invalidIDCharsRE matches the characters of matrix values which can't be used in generated step IDs.
+	invalidIDCharsRE = regexp.MustCompile(`\W+`)
+
+	errInvalidMaxParallel       = errors.New("step must specify maxParallel >= 0")
+	errMaxParallelWithoutMatrix = errors.New("maxParallel can only be specified on steps with a matrix")
+)
+
+// Matrix maps each dimension of a matrix to its values.
+type Matrix map[string][]string
+
+// Equals determines whether or not two matrices are equal.
+func (m Matrix) Equals(t Matrix) bool {
+	if len(m) != len(t) {
+		return false
+	}
+	for dimension, values := range m {
+		other, ok := t[dimension]
+		if !ok || !util.StringSequenceEquals(values, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// combinations returns every combination of the matrix's values.
+// Dimensions are iterated in alphabetical order, and values in the order they're declared.
+func (m Matrix) combinations() []map[string]string {
+	combinations := []map[string]string{{}}
+	for _, key := range sortedKeys(m) {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, val := range m[key] {
+				c := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					c[k] = v
+				}
+				c[key] = val
+				next = append(next, c)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// MatrixReferencePlaceholder returns the reference to the specified matrix value as it must appear in a rendered Task.
+func MatrixReferencePlaceholder(key string) string {
+	return fmt.Sprintf("{{.Matrix.%s}}", key)
+}
+
+// FindMatrixReferences returns the matrix values referenced in the specified string.
+func FindMatrixReferences(s string) []string {
+	var keys []string
+	for _, match := range matrixReferenceRE.FindAllStringSubmatch(s, -1) {
+		keys = append(keys, match[1])
+	}
+	return keys
+}
+
+// MatrixGroup returns the ID of the step the Step was generated from if it's an instance of a matrix,
+// or an empty string otherwise.
+func (s *Step) MatrixGroup() string {
+	if s == nil {
+		return ""
+	}
+	return s.matrixGroup
+}
+
+// validateMatrix validates the Step's matrix.
+func (s *Step) validateMatrix() error {
+	if s.MaxParallel < 0 {
+		return errInvalidMaxParallel
+	}
+	if len(s.Matrix) == 0 {
+		if s.MaxParallel > 0 && s.matrixGroup == "" {
+			return errMaxParallelWithoutMatrix
+		}
+		return nil
+	}
+	for _, key := range sortedKeys(s.Matrix) {
+		if !matrixKeyRE.MatchString(key) {
+			return fmt.Errorf("invalid matrix key %q, keys may only contain letters, digits and underscores", key)
+		}
+		if len(s.Matrix[key]) == 0 {
+			return fmt.Errorf("matrix key %s must have at least one value", key)
+		}
+	}
+	return nil
+}
+
+// expandMatrices replaces each of the Task's steps with a matrix by one step per combination of its values.
+// The IDs of the generated steps are returned, keyed by the ID of the step they were generated from.
+func expandMatrices(t *Task) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	ids := make(map[string]bool, len(t.Steps))
+	for _, step := range t.Steps {
+		ids[step.ID] = true
+	}
+
+	var steps []*Step
+	for _, step := range t.Steps {
+		if len(step.Matrix) == 0 {
+			steps = append(steps, step)
+			continue
+		}
+		if err := step.validateMatrix(); err != nil {
+			return nil, errors.Wrapf(err, "invalid matrix for step ID: %s", step.ID)
+		}
+		for _, values := range step.Matrix.combinations() {
+			instance := step.newMatrixInstance(values, ids)
+			ids[instance.ID] = true
+			groups[step.ID] = append(groups[step.ID], instance.ID)
+			steps = append(steps, instance)
+		}
+	}
+
+	for _, step := range steps {
+		for _, prop := range step.referenceableProperties() {
+			if keys := FindMatrixReferences(*prop); len(keys) > 0 {
+				return nil, fmt.Errorf("step %s references matrix value %s, which isn't defined", step.ID, keys[0])
+			}
+		}
+	}
+
+	t.Steps = steps
+	return groups, nil
+}
+
+// newMatrixInstance creates an instance of the Step for the specified matrix values.
+// The generated ID is guaranteed not to be in use.
+func (s *Step) newMatrixInstance(values map[string]string, ids map[string]bool) *Step {
+	keys := sortedKeys(values)
+	idParts := []string{s.ID}
+	for _, key := range keys {
+		idParts = append(idParts, strings.Trim(invalidIDCharsRE.ReplaceAllString(values[key], "_"), "_"))
+	}
+	id := strings.Join(idParts, "_")
+	for i := 1; ids[id]; i++ {
+		id = fmt.Sprintf("%s_%d", strings.Join(idParts, "_"), i)
+	}
+
+	instance := *s
+	instance.ID = id
+	instance.Matrix = nil
+	instance.MatrixValues = values
+	instance.matrixGroup = s.ID
+	instance.CompletedChan = make(chan bool, 1)
+	instance.Push = append([]string{}, s.Push...)
+
+	// Matrix values are exposed as environment variables, overriding any existing ones.
+	instance.Envs = nil
+	for _, env := range s.Envs {
+		if _, ok := values[strings.SplitN(env, "=", 2)[0]]; !ok {
+			instance.Envs = append(instance.Envs, env)
+		}
+	}
+	for _, key := range keys {
+		instance.Envs = append(instance.Envs, key+"="+values[key])
+	}
+
+	for _, prop := range instance.referenceableProperties() {
+		*prop = matrixReferenceRE.ReplaceAllStringFunc(*prop, func(ref string) string {
+			key := matrixReferenceRE.FindStringSubmatch(ref)[1]
+			if val, ok := values[key]; ok {
+				return val
+			}
+			return ref
+		})
+	}
+
+	if instance.IsBuildStep() {
+		// Matrix values are also provided as build args. Docker only uses the ones declared in the Dockerfile.
+		var buildArgs strings.Builder
+		for _, key := range keys {
+			buildArgs.WriteString(fmt.Sprintf("--build-arg %s=%s ", key, values[key]))
+		}
+		instance.Build = buildArgs.String() + instance.Build
+		instance.Tags = util.ParseTags(instance.Build)
+		instance.BuildArgs = util.ParseBuildArgs(instance.Build)
+	} else if instance.IsPushStep() {
+		instance.Push = getNormalizedDockerImageNames(instance.Push)
+	}
+	return &instance
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/Azure/acr-builder/secretmgmt"
+	"github.com/Azure/acr-builder/util"
+)
+
+func TestNewDagFromTask_Matrix(t *testing.T) {
+	steps := []*Step{
+		{ID: "setup", Cmd: "setup"},
+		{
+			ID:          "build",
+			Build:       "-t app:{{.Matrix.base}}-{{.Matrix.arch}} .",
+			Envs:        []string{"arch=overridden", "OTHER=1"},
+			MaxParallel: 2,
+			Matrix: Matrix{
+				"base": {"alpine:3.18", "alpine:3.19"},
+				"arch": {"amd64", "arm64"},
+			},
+		},
+		{ID: "test", Cmd: "test {{.Matrix.arch}}", When: []string{"setup"}, Matrix: Matrix{"arch": {"amd64"}}},
+		{ID: "push", Push: []string{"app:latest"}},
+		{ID: "notify", Cmd: "notify", When: []string{"build", "test"}},
+	}
+	task, err := NewTask(context.Background(), steps, []*secretmgmt.Secret{}, "", nil, true, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expectedIDs := []string{
+		"setup",
+		"build_amd64_alpine_3_18", "build_amd64_alpine_3_19", "build_arm64_alpine_3_18", "build_arm64_alpine_3_19",
+		"test_amd64",
+		"push",
+		"notify",
+	}
+	if len(task.Steps) != len(expectedIDs) {
+		t.Fatalf("expected %d steps but got %d", len(expectedIDs), len(task.Steps))
+	}
+	for i, id := range expectedIDs {
+		if task.Steps[i].ID != id {
+			t.Errorf("expected step %d to be %s but got %s", i, id, task.Steps[i].ID)
+		}
+	}
+
+	instance := task.Steps[3]
+	if instance.Build != "--build-arg arch=arm64 --build-arg base=alpine:3.18 -t app:alpine:3.18-arm64 ." {
+		t.Errorf("unexpected build: %s", instance.Build)
+	}
+	if !util.StringSequenceEquals(instance.Tags, []string{"app:alpine:3.18-arm64"}) {
+		t.Errorf("unexpected tags: %v", instance.Tags)
+	}
+	if !util.StringSequenceEquals(instance.Envs, []string{"OTHER=1", "arch=arm64", "base=alpine:3.18"}) {
+		t.Errorf("unexpected envs: %v", instance.Envs)
+	}
+	if instance.MatrixGroup() != "build" || instance.MatrixValues["arch"] != "arm64" {
+		t.Errorf("unexpected matrix group %s and values %v", instance.MatrixGroup(), instance.MatrixValues)
+	}
+	if instance.CompletedChan == task.Steps[4].CompletedChan {
+		t.Error("expected each instance to have its own completion channel")
+	}
+	if task.Steps[5].Cmd != "test amd64" {
+		t.Errorf("unexpected cmd: %s", task.Steps[5].Cmd)
+	}
+
+	expectedDeps := map[string][]string{
+		"build_amd64_alpine_3_18": {"setup"},
+		"build_arm64_alpine_3_19": {"setup"},
+		"test_amd64":              {"setup"},
+		"push":                    {"test_amd64"},
+		"notify":                  {"build_amd64_alpine_3_18", "build_amd64_alpine_3_19", "build_arm64_alpine_3_18", "build_arm64_alpine_3_19", "test_amd64"},
+	}
+	for id, expected := range expectedDeps {
+		actual := task.Dag.Dependencies(id)
+		sort.Strings(actual)
+		if !util.StringSequenceEquals(actual, expected) {
+			t.Errorf("expected %s to depend on %v but got %v", id, expected, actual)
+		}
+	}
+}
+
+func TestNewDagFromTask_MatrixErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []*Step
+	}{
+		{"undefined matrix value", []*Step{{ID: "a", Cmd: "a {{.Matrix.arch}}"}}},
+		{"undefined matrix key", []*Step{{ID: "a", Cmd: "a {{.Matrix.arch}}", Matrix: Matrix{"base": {"1"}}}}},
+		{"empty values", []*Step{{ID: "a", Cmd: "a", Matrix: Matrix{"base": {}}}}},
+		{"invalid key", []*Step{{ID: "a", Cmd: "a", Matrix: Matrix{"my-key": {"1"}}}}},
+		{"max parallel without matrix", []*Step{{ID: "a", Cmd: "a", MaxParallel: 1}}},
+		{"negative max parallel", []*Step{{ID: "a", Cmd: "a", MaxParallel: -1, Matrix: Matrix{"base": {"1"}}}}},
+	}
+	for _, test := range tests {
+		if _, err := NewDagFromTask(&Task{Steps: test.steps}); err == nil {
+			t.Errorf("%s: expected an error but got none", test.name)
+		}
+	}
+}
+
+func TestNewMatrixInstance_UniqueIDs(t *testing.T) {
+	task := &Task{
+		Steps: []*Step{
+			{ID: "a_1", Cmd: "a"},
+			{ID: "a", Cmd: "a", When: []string{"-"}, Matrix: Matrix{"v": {"1", "1.0", "1-0"}}},
+		},
+	}
+	if _, err := NewDagFromTask(task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []string
+	for _, step := range task.Steps {
+		ids = append(ids, step.ID)
+	}
+	if !util.StringSequenceEquals(ids, []string{"a_1", "a_1_1", "a_1_0", "a_1_0_1"}) {
+		t.Errorf("unexpected IDs: %v", ids)
+	}
+}
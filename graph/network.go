@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 
+	"github.com/Azure/acr-builder/pkg/containerruntime"
 	"github.com/Azure/acr-builder/pkg/procmanager"
 	"github.com/pkg/errors"
 )
@@ -43,10 +44,19 @@ func NewNetwork(name string, ipv6 bool, driver string, skipCreation bool, isDefa
 	}, nil
 }
 
+// Options returns the options to create the network with.
+func (n *Network) Options() *containerruntime.NetworkOptions {
+	return &containerruntime.NetworkOptions{
+		Name:   n.Name,
+		Driver: n.Driver,
+		IPv6:   n.Ipv6,
+	}
+}
+
 // Create creates a new Docker network.
 func (n *Network) Create(ctx context.Context, pm *procmanager.ProcManager) (string, error) {
 	var buf bytes.Buffer
-	err := pm.Run(ctx, n.getDockerCreateArgs(), nil, &buf, &buf, "")
+	err := pm.Run(ctx, n.GetDockerCreateArgs(), nil, &buf, &buf, "")
 	return buf.String(), err
 }
 
@@ -57,17 +67,11 @@ func (n *Network) Delete(ctx context.Context, pm *procmanager.ProcManager) (stri
 	return buf.String(), err
 }
 
-func (n *Network) getDockerCreateArgs() []string {
-	args := []string{"docker", "network", "create", n.Name}
-	if n.Ipv6 {
-		args = append(args, "--ipv6")
-	}
-	if n.Driver != "" {
-		args = append(args, "--driver", n.Driver)
-	}
-	return args
+// GetDockerCreateArgs returns the args used to create the Docker network.
+func (n *Network) GetDockerCreateArgs() []string {
+	return containerruntime.DockerCLI.NetworkCreateArgs(n.Options())
 }
 
 func (n *Network) getDockerRmArgs() []string {
-	return []string{"docker", "network", "rm", n.Name}
+	return containerruntime.DockerCLI.NetworkRemoveArgs(n.Name)
 }
@@ -86,7 +86,7 @@ func TestNetwork(t *testing.T) {
 		if network.Driver != test.driver {
 			t.Fatalf("Expected network: %s to have driver of %s, but got %s", test.name, test.driver, network.Driver)
 		}
-		if actual := network.getDockerCreateArgs(); !util.StringSequenceEquals(actual, test.expectedCreateArgs) {
+		if actual := network.GetDockerCreateArgs(); !util.StringSequenceEquals(actual, test.expectedCreateArgs) {
 			t.Fatalf("Expected %v as the create args, but got %v", test.expectedCreateArgs, actual)
 		}
 		if actual := network.getDockerRmArgs(); !util.StringSequenceEquals(actual, test.expectedDeleteArgs) {
@@ -0,0 +1,259 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/acr-builder/util"
+	"github.com/pkg/errors"
+)
+
+var (
+	// outputReferenceRE matches references to step outputs, e.g. {{.Steps.build.Outputs.version}}.
+	outputReferenceRE = regexp.MustCompile(`\{\{\s*\.Steps\.(\w+)\.Outputs\.(\w+)\s*\}\}`)
+
+	outputNameRE = regexp.MustCompile(`^\w+$`)
+
+	errInvalidOutputsUse = errors.New("outputs are only supported for cmd steps which aren't detached")
+)
+
+// Output is a value produced by a Step which can be consumed by the steps depending on it.
+type Output struct {
+	Name string `yaml:"name"`
+
+	// Regex captures the output from the step's standard output. The first capture group is used
+	// if the regex has one, otherwise the whole match is used. If multiple lines match, the last one wins.
+	// If unspecified, the output is read from the outputs file of the step.
+	Regex string `yaml:"regex,omitempty"`
+}
+
+// Validate validates the output and returns an error if it has problems.
+func (o *Output) Validate() error {
+	if !outputNameRE.MatchString(o.Name) {
+		return fmt.Errorf("invalid output name %q, names may only contain letters, digits and underscores", o.Name)
+	}
+	if o.Regex != "" {
+		if _, err := regexp.Compile(o.Regex); err != nil {
+			return errors.Wrapf(err, "invalid regex for output %s", o.Name)
+		}
+	}
+	return nil
+}
+
+// Equals determines whether or not two outputs are equal.
+func (o *Output) Equals(t *Output) bool {
+	if o == nil && t == nil {
+		return true
+	}
+	if o == nil || t == nil {
+		return false
+	}
+	return o.Name == t.Name && o.Regex == t.Regex
+}
+
+// outputsEqual determines whether or not two output sequences are equivalent.
+func outputsEqual(a, b []*Output) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// outputReference references an output of a step.
+type outputReference struct {
+	stepID string
+	name   string
+}
+
+// OutputReferencePlaceholder returns the reference to the specified step output as it must appear in a rendered Task.
+func OutputReferencePlaceholder(stepID string, name string) string {
+	return fmt.Sprintf("{{.Steps.%s.Outputs.%s}}", stepID, name)
+}
+
+// FindOutputReferences returns the IDs of the steps and the names of their outputs referenced in the specified string,
+// keyed by step ID.
+func FindOutputReferences(s string) map[string][]string {
+	refs := make(map[string][]string)
+	for _, match := range outputReferenceRE.FindAllStringSubmatch(s, -1) {
+		refs[match[1]] = append(refs[match[1]], match[2])
+	}
+	return refs
+}
+
+// HasOutputs returns true if the Step declares outputs, false otherwise.
+func (s *Step) HasOutputs() bool {
+	if s == nil {
+		return false
+	}
+	return len(s.Outputs) > 0
+}
+
+// declaresOutput returns true if the Step declares the specified output.
+func (s *Step) declaresOutput(name string) bool {
+	for _, o := range s.Outputs {
+		if o.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasOutputFile returns true if any of the Step's outputs is read from its outputs file.
+func (s *Step) HasOutputFile() bool {
+	for _, o := range s.Outputs {
+		if o.Regex == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// HasOutputRegex returns true if any of the Step's outputs is captured from its standard output.
+func (s *Step) HasOutputRegex() bool {
+	for _, o := range s.Outputs {
+		if o.Regex != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateOutputs validates the Step's declared outputs.
+func (s *Step) validateOutputs() error {
+	if !s.HasOutputs() {
+		return nil
+	}
+	if !s.IsCmdStep() || s.Detach {
+		return errInvalidOutputsUse
+	}
+	names := make(map[string]bool, len(s.Outputs))
+	for _, o := range s.Outputs {
+		if err := o.Validate(); err != nil {
+			return err
+		}
+		if names[o.Name] {
+			return fmt.Errorf("duplicate output %s", o.Name)
+		}
+		names[o.Name] = true
+	}
+	return nil
+}
+
+// outputReferences returns every output referenced in the Step's properties.
+func (s *Step) outputReferences() []outputReference {
+	var refs []outputReference
+	for _, prop := range s.referenceableProperties() {
+		for _, match := range outputReferenceRE.FindAllStringSubmatch(*prop, -1) {
+			refs = append(refs, outputReference{stepID: match[1], name: match[2]})
+		}
+	}
+	return refs
+}
+
+// referenceableProperties returns the properties of the Step in which outputs and matrix values can be referenced.
+func (s *Step) referenceableProperties() []*string {
+	props := []*string{&s.Cmd, &s.Build, &s.EntryPoint, &s.WorkingDirectory}
+	for i := range s.Envs {
+		props = append(props, &s.Envs[i])
+	}
+	for i := range s.Push {
+		props = append(props, &s.Push[i])
+	}
+	return props
+}
+
+// ResolveOutputReferences replaces the references to other steps' outputs in the Step's properties
+// with their values. It must be called once the referenced steps have completed.
+func (s *Step) ResolveOutputReferences(steps map[string]*Step) error {
+	var problems []string
+	resolved := false
+	for _, prop := range s.referenceableProperties() {
+		*prop = outputReferenceRE.ReplaceAllStringFunc(*prop, func(ref string) string {
+			match := outputReferenceRE.FindStringSubmatch(ref)
+			step, ok := steps[match[1]]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("unknown step %s", match[1]))
+				return ref
+			}
+			val, ok := step.OutputValues[match[2]]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("step %s didn't produce output %s", match[1], match[2]))
+				return ref
+			}
+			resolved = true
+			return val
+		})
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("failed to resolve output references: %s", strings.Join(problems, ", "))
+	}
+
+	// Tags and build args were parsed from the unresolved properties.
+	if resolved {
+		if s.IsBuildStep() {
+			s.Tags = util.ParseTags(s.Build)
+			s.BuildArgs = util.ParseBuildArgs(s.Build)
+		} else if s.IsPushStep() {
+			s.Push = getNormalizedDockerImageNames(s.Push)
+		}
+	}
+	return nil
+}
+
+// CollectOutputs sets the Step's output values using its standard output and the contents of its outputs file,
+// which consists of key=value lines. An error is returned if any declared output wasn't produced.
+func (s *Step) CollectOutputs(stdout string, outputFile string) error {
+	fileValues := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(outputFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		pair := strings.SplitN(line, "=", 2)
+		if len(pair) != 2 {
+			return fmt.Errorf("invalid line in the outputs file of step %s, expected key=value: %s", s.ID, line)
+		}
+		fileValues[pair[0]] = pair[1]
+	}
+
+	values := make(map[string]string, len(s.Outputs))
+	for _, o := range s.Outputs {
+		if o.Regex == "" {
+			if val, ok := fileValues[o.Name]; ok {
+				values[o.Name] = val
+			}
+			continue
+		}
+
+		re := regexp.MustCompile(o.Regex)
+		matches := re.FindAllStringSubmatch(stdout, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		match := matches[len(matches)-1]
+		if len(match) > 1 {
+			values[o.Name] = match[1]
+		} else {
+			values[o.Name] = match[0]
+		}
+	}
+
+	s.OutputValues = values
+	for _, o := range s.Outputs {
+		if _, ok := values[o.Name]; !ok {
+			return fmt.Errorf("step %s didn't produce output %s", s.ID, o.Name)
+		}
+	}
+	return nil
+}
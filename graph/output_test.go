@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCollectOutputs(t *testing.T) {
+	step := &Step{
+		ID: "s",
+		Outputs: []*Output{
+			{Name: "version", Regex: `version: (\S+)`},
+			{Name: "line", Regex: `done`},
+			{Name: "digest"},
+		},
+	}
+	stdout := "version: 1.0.0\nbuilding...\nversion: 1.0.1\ndone\n"
+	file := "\ndigest=sha256:abc=\nother=ignored\n"
+	if err := step.CollectOutputs(stdout, file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{"version": "1.0.1", "line": "done", "digest": "sha256:abc="}
+	for name, val := range expected {
+		if step.OutputValues[name] != val {
+			t.Errorf("expected output %s to be %q but got %q", name, val, step.OutputValues[name])
+		}
+	}
+	if _, ok := step.OutputValues["other"]; ok {
+		t.Error("expected undeclared outputs to be ignored")
+	}
+
+	if err := step.CollectOutputs("nothing", "digest=x"); err == nil {
+		t.Error("expected an error for a missing output")
+	}
+	if err := step.CollectOutputs(stdout, "not a pair"); err == nil {
+		t.Error("expected an error for an invalid outputs file")
+	}
+}
+
+func TestResolveOutputReferences(t *testing.T) {
+	steps := map[string]*Step{
+		"a": {ID: "a", OutputValues: map[string]string{"version": "1.0.1"}},
+	}
+	step := &Step{
+		ID:    "b",
+		Build: "-t app:{{.Steps.a.Outputs.version}} --build-arg V={{ .Steps.a.Outputs.version }} .",
+		Envs:  []string{"V={{.Steps.a.Outputs.version}}"},
+	}
+	if err := step.ResolveOutputReferences(steps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if step.Build != "-t app:1.0.1 --build-arg V=1.0.1 ." {
+		t.Errorf("unexpected build: %s", step.Build)
+	}
+	if step.Envs[0] != "V=1.0.1" {
+		t.Errorf("unexpected env: %s", step.Envs[0])
+	}
+	if len(step.Tags) != 1 || step.Tags[0] != "app:1.0.1" {
+		t.Errorf("expected the tags to be parsed again but got %v", step.Tags)
+	}
+
+	step = &Step{ID: "c", Cmd: "echo {{.Steps.a.Outputs.missing}}"}
+	if err := step.ResolveOutputReferences(steps); err == nil {
+		t.Error("expected an error for a missing output")
+	}
+}
+
+func TestNewDagFromTask_OutputReferences(t *testing.T) {
+	task := &Task{
+		Steps: []*Step{
+			{ID: "a", Cmd: "a", Outputs: []*Output{{Name: "version"}}},
+			{ID: "b", Cmd: "b {{.Steps.a.Outputs.version}}", When: []string{"a"}},
+			{ID: "c", Cmd: "c {{.Steps.a.Outputs.version}}", When: []string{ImmediateExecutionToken}},
+			{ID: "d", Cmd: "d {{.Steps.a.Outputs.digest}}", When: []string{"a"}},
+			{ID: "e", Cmd: "e", When: []string{"a"}, If: "steps.a.outputs.digest == 'x'"},
+		},
+	}
+	_, err := NewDagFromTask(task)
+	valErr, ok := err.(*DagValidationError)
+	if !ok {
+		t.Fatalf("expected a *DagValidationError but got %v", err)
+	}
+	msg := valErr.Error()
+	for _, expected := range []string{
+		"step c references output version of step a, which it doesn't depend on",
+		"step d references output digest, which step a doesn't declare",
+		"step e's condition references output digest, which step a doesn't declare",
+	} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("expected %q in %q", expected, msg)
+		}
+	}
+}
+
+func TestValidateOutputs(t *testing.T) {
+	tests := []struct {
+		step        *Step
+		shouldError bool
+	}{
+		{&Step{ID: "a", Cmd: "a", Outputs: []*Output{{Name: "version"}, {Name: "digest", Regex: "sha256:\\w+"}}}, false},
+		{&Step{ID: "a", Build: ".", Outputs: []*Output{{Name: "version"}}}, true},
+		{&Step{ID: "a", Cmd: "a", Detach: true, Outputs: []*Output{{Name: "version"}}}, true},
+		{&Step{ID: "a", Cmd: "a", Outputs: []*Output{{Name: "my-version"}}}, true},
+		{&Step{ID: "a", Cmd: "a", Outputs: []*Output{{Name: "v"}, {Name: "v"}}}, true},
+		{&Step{ID: "a", Cmd: "a", Outputs: []*Output{{Name: "v", Regex: "("}}}, true},
+	}
+	for i, test := range tests {
+		err := test.step.Validate()
+		if test.shouldError && err == nil {
+			t.Errorf("test %d: expected an error but got none", i)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/pkg/errors"
+)
+
+// The backoffs of a RetryPolicy.
+const (
+	FixedBackoff       = "fixed"
+	ExponentialBackoff = "exponential"
+)
+
+const defaultRetryMaxDelayInSeconds = 300
+
+var (
+	errRetryPolicyWithRetries = errors.New("retryPolicy can't be combined with retries or retryOnErrors")
+	errRetryPolicyStepType    = errors.New("retryPolicy is only supported for cmd and build steps")
+)
+
+// RetryPolicy describes which failed executions of a Step are retried and how long to wait before retrying them.
+type RetryPolicy struct {
+	// Retries is the maximum number of retries after the initial execution.
+	Retries int `yaml:"retries"`
+
+	// Delay is the number of seconds to wait before the first retry. Defaults to the Step's retryDelay.
+	Delay int `yaml:"delay"`
+
+	// Backoff is either fixed, the default, or exponential, which doubles the delay with every retry up to MaxDelay.
+	Backoff  string `yaml:"backoff"`
+	MaxDelay int    `yaml:"maxDelay"`
+
+	// Jitter is the fraction, between 0 and 1, by which each delay is randomly reduced.
+	Jitter float64 `yaml:"jitter"`
+
+	// ExitCodes and Patterns filter the failures which are retried: a failure is retried if the container
+	// exited with one of the exit codes, or if its output matches one of the regular expressions.
+	// If neither is specified, every failure is retried.
+	ExitCodes []int    `yaml:"exitCodes"`
+	Patterns  []string `yaml:"patterns"`
+
+	// MaxRetryTime is the number of seconds after the initial execution started past which no retry is started.
+	MaxRetryTime int `yaml:"maxRetryTime"`
+}
+
+// validateRetryPolicy validates the Step's retry policy.
+func (s *Step) validateRetryPolicy() error {
+	p := s.RetryPolicy
+	if p == nil {
+		return nil
+	}
+	if s.Retries > 0 || len(s.RetryOnErrors) > 0 {
+		return errRetryPolicyWithRetries
+	}
+	if !s.IsCmdStep() && !s.IsBuildStep() {
+		return errRetryPolicyStepType
+	}
+	if p.Retries < 0 || p.Delay < 0 || p.MaxDelay < 0 || p.MaxRetryTime < 0 {
+		return errors.New("retries, delay, maxDelay and maxRetryTime must be >= 0")
+	}
+	if p.Backoff != "" && p.Backoff != FixedBackoff && p.Backoff != ExponentialBackoff {
+		return fmt.Errorf("invalid backoff %q, must be either %s or %s", p.Backoff, FixedBackoff, ExponentialBackoff)
+	}
+	if p.MaxDelay > 0 && p.Backoff != ExponentialBackoff {
+		return errors.New("maxDelay can only be specified with an exponential backoff")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("invalid jitter %v, must be between 0 and 1", p.Jitter)
+	}
+	for _, pattern := range p.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// GetRetryPolicy returns the policy used to retry the Step's failed executions, which is either
+// its retryPolicy or based on its retries, retryOnErrors and retryDelay.
+func (s *Step) GetRetryPolicy() *procmanager.RetryPolicy {
+	p := s.RetryPolicy
+	if p == nil {
+		return &procmanager.RetryPolicy{
+			Retries:       s.Retries,
+			RetryOnErrors: s.RetryOnErrors,
+			Delay:         time.Duration(s.RetryDelayInSeconds) * time.Second,
+		}
+	}
+
+	delay := p.Delay
+	if delay == 0 {
+		delay = s.RetryDelayInSeconds
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = defaultRetryMaxDelayInSeconds
+	}
+	if maxDelay < delay {
+		maxDelay = delay
+	}
+	policy := &procmanager.RetryPolicy{
+		Retries:          p.Retries,
+		RetryOnExitCodes: p.ExitCodes,
+		Delay:            time.Duration(delay) * time.Second,
+		Exponential:      p.Backoff == ExponentialBackoff,
+		MaxDelay:         time.Duration(maxDelay) * time.Second,
+		Jitter:           p.Jitter,
+		MaxRetryTime:     time.Duration(p.MaxRetryTime) * time.Second,
+	}
+	for _, pattern := range p.Patterns {
+		// Patterns have been validated.
+		policy.RetryOnPatterns = append(policy.RetryOnPatterns, regexp.MustCompile(pattern))
+	}
+	return policy
+}
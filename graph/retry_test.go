@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package graph
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateRetryPolicy(t *testing.T) {
+	tests := []struct {
+		step        *Step
+		shouldError bool
+	}{
+		{&Step{ID: "a", Cmd: "a", RetryPolicy: &RetryPolicy{Retries: 3, Backoff: ExponentialBackoff, MaxDelay: 60, Jitter: 0.2, ExitCodes: []int{137}, Patterns: []string{"reset|refused"}}}, false},
+		{&Step{ID: "a", Build: ".", RetryPolicy: &RetryPolicy{Retries: 3}}, false},
+		{&Step{ID: "a", Push: []string{"a"}, RetryPolicy: &RetryPolicy{Retries: 3}}, true},
+		{&Step{ID: "a", Cmd: "a", Retries: 1, RetryPolicy: &RetryPolicy{Retries: 3}}, true},
+		{&Step{ID: "a", Cmd: "a", RetryOnErrors: []string{"x"}, RetryPolicy: &RetryPolicy{Retries: 3}}, true},
+		{&Step{ID: "a", Cmd: "a", RetryPolicy: &RetryPolicy{Retries: -1}}, true},
+		{&Step{ID: "a", Cmd: "a", RetryPolicy: &RetryPolicy{Backoff: "linear"}}, true},
+		{&Step{ID: "a", Cmd: "a", RetryPolicy: &RetryPolicy{MaxDelay: 60}}, true},
+		{&Step{ID: "a", Cmd: "a", RetryPolicy: &RetryPolicy{Jitter: 1.5}}, true},
+		{&Step{ID: "a", Cmd: "a", RetryPolicy: &RetryPolicy{Patterns: []string{"("}}}, true},
+	}
+	for i, test := range tests {
+		err := test.step.Validate()
+		if test.shouldError && err == nil {
+			t.Errorf("test %d: expected an error but got none", i)
+		}
+		if !test.shouldError && err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestGetRetryPolicy(t *testing.T) {
+	step := &Step{ID: "a", Cmd: "a", Retries: 2, RetryOnErrors: []string{"x"}, RetryDelayInSeconds: 5}
+	policy := step.GetRetryPolicy()
+	if policy.Retries != 2 || len(policy.RetryOnErrors) != 1 || policy.Delay != 5*time.Second || policy.Exponential {
+		t.Errorf("unexpected policy from retries: %+v", policy)
+	}
+
+	step = &Step{ID: "a", Cmd: "a", RetryDelayInSeconds: 5, RetryPolicy: &RetryPolicy{
+		Retries:      3,
+		Backoff:      ExponentialBackoff,
+		Jitter:       0.5,
+		ExitCodes:    []int{137},
+		Patterns:     []string{"reset"},
+		MaxRetryTime: 120,
+	}}
+	policy = step.GetRetryPolicy()
+	if policy.Retries != 3 || policy.Delay != 5*time.Second || !policy.Exponential ||
+		policy.MaxDelay != defaultRetryMaxDelayInSeconds*time.Second || policy.Jitter != 0.5 ||
+		len(policy.RetryOnExitCodes) != 1 || len(policy.RetryOnPatterns) != 1 || policy.MaxRetryTime != 2*time.Minute {
+		t.Errorf("unexpected policy from retryPolicy: %+v", policy)
+	}
+}
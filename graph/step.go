@@ -5,10 +5,12 @@ package graph
 
 import (
 	"fmt"
+	"reflect"
 	"runtime"
 	"strings"
 	"time"
 
+	"github.com/Azure/acr-builder/pkg/execution"
 	"github.com/Azure/acr-builder/pkg/image"
 	"github.com/Azure/acr-builder/pkg/volume"
 	"github.com/Azure/acr-builder/util"
@@ -22,6 +24,13 @@ const (
 	enabled                 = "enabled"
 	disabled                = "disabled"
 	BuildKitEnv             = "DOCKER_BUILDKIT=1"
+
+	// BuildStepType is the type of a Step with a build property.
+	BuildStepType = "build"
+	// CmdStepType is the type of a Step with a cmd property.
+	CmdStepType = "cmd"
+	// PushStepType is the type of a Step with a push property.
+	PushStepType = "push"
 )
 
 var (
@@ -34,6 +43,7 @@ var (
 	errInvalidRepeat     = errors.New("step must specify repeat >= 0")
 	errInvalidCacheValue = errors.New("invalid value for cache property. Valid values are 'enabled', 'disabled'")
 	errInvalidMountsUse  = errors.New("invalid use of Mounts. Mounts must have unique container paths and only used for cmd or build steps")
+	errInvalidLock       = errors.New("step locks must be non-empty and cannot contain spaces")
 )
 
 type chanBool chan bool
@@ -52,25 +62,33 @@ func (c chanBool) UnmarshalYAML(_ func(interface{}) error) error {
 
 // Step is a step in the execution task.
 type Step struct {
-	ID               string          `yaml:"id"`
-	Cmd              string          `yaml:"cmd"`
-	Build            string          `yaml:"build"`
-	WorkingDirectory string          `yaml:"workingDirectory"`
-	EntryPoint       string          `yaml:"entryPoint"`
-	User             string          `yaml:"user"`
-	Network          string          `yaml:"network"`
-	Isolation        string          `yaml:"isolation"`
-	CPUS             string          `yaml:"cpus"`
-	Cache            string          `yaml:"cache"`
-	Mounts           []*volume.Mount `yaml:"volumeMounts"`
-	Push             []string        `yaml:"push"`
-	Envs             []string        `yaml:"env"`
-	Expose           []string        `yaml:"expose"`
-	Ports            []string        `yaml:"ports"`
-	When             []string        `yaml:"when"`
-	ExitedWith       []int           `yaml:"exitedWith"`
-	ExitedWithout    []int           `yaml:"exitedWithout"`
-	Timeout          int             `yaml:"timeout"`
+	ID               string            `yaml:"id"`
+	Cmd              string            `yaml:"cmd"`
+	Build            string            `yaml:"build"`
+	WorkingDirectory string            `yaml:"workingDirectory"`
+	EntryPoint       string            `yaml:"entryPoint"`
+	User             string            `yaml:"user"`
+	Network          string            `yaml:"network"`
+	Isolation        string            `yaml:"isolation"`
+	CPUS             string            `yaml:"cpus"`
+	Cache            string            `yaml:"cache"`
+	Mounts           []*volume.Mount   `yaml:"volumeMounts"`
+	Push             []string          `yaml:"push"`
+	Envs             []string          `yaml:"env"`
+	Expose           []string          `yaml:"expose"`
+	Ports            []string          `yaml:"ports"`
+	When             []string          `yaml:"when"`
+	If               string            `yaml:"if"`
+	Outputs          []*Output         `yaml:"outputs"`
+	Matrix           Matrix            `yaml:"matrix"`
+	Locks            []string          `yaml:"locks"`
+	CacheKey         *CacheKey         `yaml:"cacheKey"`
+	CachePaths       []string          `yaml:"cachePaths"`
+	Uses             string            `yaml:"uses"`
+	With             map[string]string `yaml:"with"`
+	ExitedWith       []int             `yaml:"exitedWith"`
+	ExitedWithout    []int             `yaml:"exitedWithout"`
+	Timeout          int               `yaml:"timeout"`
 	// CmdDownloadRetries specifies how many times a download in a step will be retried
 	CmdDownloadRetries             int `yaml:"cmdDownloadRetries"`
 	CmdDownloadRetryDelayInSeconds int `yaml:"cmdDownloadRetryDelay"`
@@ -79,8 +97,12 @@ type Step struct {
 	// Retries specifies how many times a Step will be retried if it fails after its initial execution.
 	Retries       int      `yaml:"retries"`
 	RetryOnErrors []string `yaml:"retryOnErrors"`
+	// RetryPolicy replaces Retries and RetryOnErrors with finer control over retries.
+	RetryPolicy *RetryPolicy `yaml:"retryPolicy"`
 	// Repeat specifies how many times a Step will be repeated after its initial execution.
-	Repeat                          int  `yaml:"repeat"`
+	Repeat int `yaml:"repeat"`
+	// MaxParallel limits how many instances of the Step's matrix run at once.
+	MaxParallel                     int  `yaml:"maxParallel"`
 	Keep                            bool `yaml:"keep"`
 	Detach                          bool `yaml:"detach"`
 	Privileged                      bool `yaml:"privileged"`
@@ -94,6 +116,22 @@ type Step struct {
 	EndTime    time.Time
 	StepStatus StepStatus
 
+	// SkipReason describes why the Step was skipped during a run, if it was.
+	SkipReason string
+
+	// ExitCode is the exit code of the Step's last execution.
+	ExitCode int
+	// Attempts records every execution of the Step, including retries and repetitions.
+	Attempts []execution.Attempt
+	// OutputValues contains the values of the Step's outputs once it has completed successfully.
+	OutputValues map[string]string
+	// MatrixValues contains the matrix values of the Step if it's an instance of a matrix.
+	MatrixValues map[string]string
+	// Resumed is true if the Step completed in a previous run and was restored from its checkpoint.
+	Resumed bool
+	// CacheHit is true if the Step's results were restored from the cache instead of running it.
+	CacheHit bool
+
 	// CompletedChan can be used to signal to readers
 	// that the step has been processed.
 	CompletedChan chanBool
@@ -102,6 +140,13 @@ type Step struct {
 	Tags                 []string
 	BuildArgs            []string
 	DefaultBuildCacheTag string
+
+	// condition is the parsed If expression.
+	condition *condition
+	// matrixGroup is the ID of the step this Step was generated from if it's an instance of a matrix.
+	matrixGroup string
+	// fragments are the IDs of the steps with uses this Step was expanded from, outermost first.
+	fragments []string
 }
 
 // Validate validates the step and returns an error if the Step has problems.
@@ -145,6 +190,36 @@ func (s *Step) Validate() error {
 		}
 	}
 
+	for _, lock := range s.Locks {
+		if lock == "" || util.ContainsSpace(lock) {
+			return errInvalidLock
+		}
+	}
+
+	if err := s.validateMatrix(); err != nil {
+		return errors.Wrapf(err, "invalid matrix for step ID: %s", s.ID)
+	}
+
+	if err := s.validateOutputs(); err != nil {
+		return errors.Wrapf(err, "invalid outputs for step ID: %s", s.ID)
+	}
+
+	if err := s.validateCacheKey(); err != nil {
+		return errors.Wrapf(err, "invalid cacheKey for step ID: %s", s.ID)
+	}
+
+	if err := s.validateRetryPolicy(); err != nil {
+		return errors.Wrapf(err, "invalid retryPolicy for step ID: %s", s.ID)
+	}
+
+	if s.If != "" {
+		cond, err := parseCondition(s.If)
+		if err != nil {
+			return errors.Wrapf(err, "invalid if condition for step ID: %s", s.ID)
+		}
+		s.condition = cond
+	}
+
 	if s.Cache != "" && !strings.EqualFold(s.Cache, enabled) && !strings.EqualFold(s.Cache, disabled) {
 		return errInvalidCacheValue
 	}
@@ -211,6 +286,8 @@ func (s *Step) Equals(t *Step) bool {
 		util.StringSequenceEquals(s.Envs, t.Envs) &&
 		s.Timeout == t.Timeout &&
 		util.StringSequenceEquals(s.When, t.When) &&
+		util.StringSequenceEquals(s.Locks, t.Locks) &&
+		s.If == t.If &&
 		util.IntSequenceEquals(s.ExitedWith, t.ExitedWith) &&
 		util.IntSequenceEquals(s.ExitedWithout, t.ExitedWithout) &&
 		s.StartDelay == t.StartDelay &&
@@ -224,10 +301,18 @@ func (s *Step) Equals(t *Step) bool {
 		s.Cache == t.Cache &&
 		s.IgnoreErrors == t.IgnoreErrors &&
 		s.Retries == t.Retries &&
+		reflect.DeepEqual(s.RetryPolicy, t.RetryPolicy) &&
 		s.RetryDelayInSeconds == t.RetryDelayInSeconds &&
 		s.DisableWorkingDirectoryOverride == t.DisableWorkingDirectoryOverride &&
 		s.Pull == t.Pull &&
-		s.Repeat == t.Repeat
+		s.Repeat == t.Repeat &&
+		s.MaxParallel == t.MaxParallel &&
+		outputsEqual(s.Outputs, t.Outputs) &&
+		s.Matrix.Equals(t.Matrix) &&
+		s.Uses == t.Uses &&
+		util.StringMapEquals(s.With, t.With) &&
+		s.CacheKey.Equals(t.CacheKey) &&
+		util.StringSequenceEquals(s.CachePaths, t.CachePaths)
 }
 
 // ShouldExecuteImmediately returns true if the Step should be executed immediately.
@@ -249,6 +334,30 @@ func (s *Step) HasNoWhen() bool {
 	return len(s.When) == 0
 }
 
+// HasCondition returns true if the Step has an if condition, false otherwise.
+func (s *Step) HasCondition() bool {
+	if s == nil {
+		return false
+	}
+	return s.If != ""
+}
+
+// EvaluateCondition determines whether or not the Step should run.
+// Steps without a condition only run if none of their dependencies failed or were skipped.
+func (s *Step) EvaluateCondition(ctx *ConditionContext) (bool, error) {
+	if !s.HasCondition() {
+		return !ctx.DependencySkipped, nil
+	}
+	if s.condition == nil {
+		cond, err := parseCondition(s.If)
+		if err != nil {
+			return false, err
+		}
+		s.condition = cond
+	}
+	return s.condition.evaluate(ctx)
+}
+
 // HasMounts returns true if the Step has at least 1 mount listed, false otherwise
 func (s *Step) HasMounts() bool {
 	if s == nil {
@@ -281,6 +390,21 @@ func (s *Step) IsPushStep() bool {
 	return len(s.Push) > 0
 }
 
+// Type returns the type of the Step, i.e. build, cmd, or push.
+// An empty string is returned if the type can't be determined.
+func (s *Step) Type() string {
+	switch {
+	case s.IsBuildStep():
+		return BuildStepType
+	case s.IsCmdStep():
+		return CmdStepType
+	case s.IsPushStep():
+		return PushStepType
+	default:
+		return ""
+	}
+}
+
 // UpdateBuildStepWithDefaults updates a build step with hyperv isolation on Windows.
 func (s *Step) UpdateBuildStepWithDefaults() {
 	if s.IsBuildStep() && runtime.GOOS == util.WindowsOS && !strings.Contains(s.Build, "--isolation") {
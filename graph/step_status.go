@@ -18,4 +18,7 @@ const (
 
 	// Failed means the step failed because of an error.
 	Failed StepStatus = "failed"
+
+	// Cancelled means the step was stopped, or never started, because the run was cancelled.
+	Cancelled StepStatus = "cancelled"
 )
@@ -204,6 +204,23 @@ func TestValidate(t *testing.T) {
 			},
 			false,
 		},
+		{
+			&Step{
+				ID:    "a",
+				Cmd:   "b",
+				Locks: []string{"docker-daemon-heavy"},
+			},
+			false,
+		},
+		{
+			// Locks cannot contain spaces.
+			&Step{
+				ID:    "a",
+				Cmd:   "b",
+				Locks: []string{"docker daemon"},
+			},
+			true,
+		},
 	}
 
 	for _, test := range tests {
@@ -387,6 +404,54 @@ func TestEquals(t *testing.T) {
 			},
 			true,
 		},
+		{
+			&Step{
+				ID:      "a",
+				Outputs: []*Output{{Name: "version", Regex: "v(.*)"}},
+			},
+			&Step{
+				ID:      "a",
+				Outputs: []*Output{{Name: "version"}},
+			},
+			false,
+		},
+		{
+			&Step{
+				ID:     "a",
+				Matrix: Matrix{"arch": {"amd64", "arm64"}},
+			},
+			&Step{
+				ID:     "a",
+				Matrix: Matrix{"arch": {"amd64"}},
+			},
+			false,
+		},
+		{
+			&Step{
+				ID:   "a",
+				Uses: "./common/sign.yaml",
+				With: map[string]string{"image": "app:v1"},
+			},
+			&Step{
+				ID:   "a",
+				Uses: "./common/sign.yaml",
+				With: map[string]string{"image": "app:v2"},
+			},
+			false,
+		},
+		{
+			&Step{
+				ID:         "a",
+				CacheKey:   &CacheKey{Files: []string{"go.sum"}},
+				CachePaths: []string{"/root/.cache"},
+			},
+			&Step{
+				ID:         "a",
+				CacheKey:   &CacheKey{Files: []string{"go.sum"}, Env: []string{"GOOS"}},
+				CachePaths: []string{"/root/.cache"},
+			},
+			false,
+		},
 	}
 
 	for _, test := range tests {
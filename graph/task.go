@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/Azure/acr-builder/pkg/volume"
 	"github.com/Azure/acr-builder/secretmgmt"
@@ -32,6 +34,9 @@ const (
 )
 
 var (
+	errInvalidTaskMaxParallel = errors.New("task must specify maxParallel >= 0")
+	errInvalidTaskTimeout     = errors.New("task must specify timeout >= 0")
+
 	validTaskVersions = map[string]bool{
 		"1.0-preview-1":    true,
 		currentTaskVersion: true,
@@ -51,21 +56,35 @@ type RegistryLoginCredentials map[string]*ResolvedRegistryCred
 // Task represents a task execution.
 type Task struct {
 	Steps                    []*Step              `yaml:"steps"`
+	Finally                  []*Step              `yaml:"finally,omitempty"`
 	StepTimeout              int                  `yaml:"stepTimeout,omitempty"`
+	Timeout                  int                  `yaml:"timeout,omitempty"`
 	Secrets                  []*secretmgmt.Secret `yaml:"secrets,omitempty"`
 	Networks                 []*Network           `yaml:"networks,omitempty"`
 	Volumes                  []*volume.Volume     `yaml:"volumes,omitempty"`
 	Envs                     []string             `yaml:"env,omitempty"`
 	WorkingDirectory         string               `yaml:"workingDirectory,omitempty"`
 	Version                  string               `yaml:"version,omitempty"`
+	ContinueOnFailure        bool                 `yaml:"continueOnFailure,omitempty"`
+	MaxParallel              int                  `yaml:"maxParallel,omitempty"`
 	RegistryName             string
 	Registry                 string
 	TaskName                 string // Used to form the build cache image tag.
 	Credentials              []*RegistryCredential
 	RegistryLoginCredentials RegistryLoginCredentials
 	Dag                      *Dag
-	IsBuildTask              bool // Used to skip the default network creation for build.
-	InitBuildkitContainer    bool // Used to initialize buildkit container if a build step is using build cache.
+	FinallyDag               *Dag
+	Run                      RunMetadata `yaml:"-"` // Used to evaluate step conditions.
+	StartTime                time.Time   `yaml:"-"`
+	EndTime                  time.Time   `yaml:"-"`
+	IsBuildTask              bool        // Used to skip the default network creation for build.
+	InitBuildkitContainer    bool        // Used to initialize buildkit container if a build step is using build cache.
+
+	// fragmentDir is the directory local fragments are resolved against.
+	fragmentDir string
+
+	// secretResolveConcurrency is the maximum number of registry credentials resolved at once.
+	secretResolveConcurrency int
 }
 
 // TaskOptions are used to configure a new Task
@@ -93,6 +112,22 @@ type TaskOptions struct {
 
 	// GlobalAliases keeps track of all the Task native global aliases
 	GlobalAliases []byte
+
+	// Run describes the run the Task is executed for
+	Run RunMetadata
+
+	// MaxParallel overrides the maximum number of steps running at once if it's greater than 0
+	MaxParallel int
+
+	// Timeout overrides the maximum execution time of the Task in seconds if it's greater than 0
+	Timeout int
+
+	// FragmentDir is the directory local fragments referenced by steps with uses are resolved against
+	FragmentDir string
+
+	// SecretResolveConcurrency is the maximum number of registry credentials resolved at once.
+	// If unspecified, secretmgmt.DefaultSecretResolveConcurrency is used.
+	SecretResolveConcurrency int
 }
 
 // UnmarshalTaskFromString unmarshals a Task from a raw string.
@@ -130,6 +165,15 @@ func (t *Task) AddTaskDefaults(ctx context.Context, opts *TaskOptions) error {
 	}
 
 	t.Registry = opts.Registry
+	t.Run = opts.Run
+	t.fragmentDir = opts.FragmentDir
+	t.secretResolveConcurrency = opts.SecretResolveConcurrency
+	if opts.MaxParallel > 0 {
+		t.MaxParallel = opts.MaxParallel
+	}
+	if opts.Timeout > 0 {
+		t.Timeout = opts.Timeout
+	}
 
 	// External network parsed in from CLI will be set as default network, it will be used for any step if no network provide for them
 	// The external network is append at the end of the list of networks, later we will do reverse iteration to get this network
@@ -163,6 +207,11 @@ func UnmarshalTaskFromFile(ctx context.Context, file string, opts *TaskOptions)
 	} else {
 		t.TaskName = noTaskNamePlaceholder
 	}
+	t.fragmentDir = opts.FragmentDir
+	if t.fragmentDir == "" {
+		t.fragmentDir = filepath.Dir(file)
+	}
+	t.secretResolveConcurrency = opts.SecretResolveConcurrency
 	err = t.initialize(ctx)
 	return t, err
 }
@@ -183,6 +232,13 @@ func NewTaskFromBytes(data []byte) (*Task, error) {
 
 // Validate validates the task and returns an error if the Task has problems.
 func (t *Task) Validate() error {
+	if t.MaxParallel < 0 {
+		return errInvalidTaskMaxParallel
+	}
+	if t.Timeout < 0 {
+		return errInvalidTaskTimeout
+	}
+
 	// Validate secrets if exists
 	idMap := make(map[string]struct{}, len(t.Secrets))
 	for _, secret := range t.Secrets {
@@ -206,7 +262,7 @@ func (t *Task) Validate() error {
 		return err
 	}
 	// Validate that mounts reference a volume that exists
-	for _, s := range t.Steps {
+	for _, s := range t.AllSteps() {
 		if err := s.ValidateMountVolumeNames(t.Volumes); err != nil {
 			return err
 		}
@@ -287,6 +343,28 @@ func (t *Task) initialize(ctx context.Context) error {
 	}
 
 	for i, s := range t.Steps {
+		if s.ID == "" {
+			s.ID = fmt.Sprintf("acb_step_%d", i)
+		}
+	}
+	for i, s := range t.Finally {
+		if s.ID == "" {
+			s.ID = fmt.Sprintf("acb_finally_%d", i)
+		}
+	}
+
+	var err error
+	t.RegistryLoginCredentials, err = resolveCustomRegistryCredentials(ctx, t.Credentials, t.secretResolveConcurrency)
+	if err != nil {
+		return err
+	}
+
+	// Steps with uses are replaced by the steps of their fragments, which need to be initialized too.
+	if err := t.expandFragments(ctx); err != nil {
+		return err
+	}
+
+	for _, s := range t.AllSteps() {
 		// If individual steps don't have step timeouts specified,
 		// stamp the global timeout on them.
 		if s.Timeout <= 0 {
@@ -307,18 +385,15 @@ func (t *Task) initialize(ctx context.Context) error {
 		}
 		s.Envs = newEnvs
 
-		if s.ID == "" {
-			s.ID = fmt.Sprintf("acb_step_%d", i)
-		}
-
 		// Override the step's working directory to be the parent's working directory.
 		if s.WorkingDirectory == "" && t.WorkingDirectory != "" {
 			s.WorkingDirectory = t.WorkingDirectory
 		}
 
 		// Initialize a completion channel for each step.
+		// It's buffered so that completing a step never blocks, even if nobody is waiting on it anymore.
 		if s.CompletedChan == nil {
-			s.CompletedChan = make(chan bool)
+			s.CompletedChan = make(chan bool, 1)
 		}
 
 		// Mark the step as skipped initially
@@ -347,16 +422,20 @@ func (t *Task) initialize(ctx context.Context) error {
 			s.Push = getNormalizedDockerImageNames(s.Push)
 		}
 	}
-	var err error
-
-	t.RegistryLoginCredentials, err = ResolveCustomRegistryCredentials(ctx, t.Credentials)
-	if err != nil {
+	if t.Dag, err = NewDagFromTask(t); err != nil {
 		return err
 	}
-	t.Dag, err = NewDagFromTask(t)
+	t.FinallyDag, err = NewFinallyDagFromTask(t)
 	return err
 }
 
+// AllSteps returns the Task's steps followed by its finally steps.
+func (t *Task) AllSteps() []*Step {
+	steps := make([]*Step, 0, len(t.Steps)+len(t.Finally))
+	steps = append(steps, t.Steps...)
+	return append(steps, t.Finally...)
+}
+
 // UsingRegistryCreds determines whether or not the Task is using registry creds.
 func (t *Task) UsingRegistryCreds() bool {
 	return len(t.RegistryLoginCredentials) > 0
@@ -431,6 +510,11 @@ func validateTaskVersion(version string) error {
 
 // ResolveCustomRegistryCredentials resolves all the registry login credentials
 func ResolveCustomRegistryCredentials(ctx context.Context, credentials []*RegistryCredential) (RegistryLoginCredentials, error) {
+	return resolveCustomRegistryCredentials(ctx, credentials, secretmgmt.DefaultSecretResolveConcurrency)
+}
+
+// resolveCustomRegistryCredentials resolves all the registry login credentials, resolving up to concurrency secrets at once.
+func resolveCustomRegistryCredentials(ctx context.Context, credentials []*RegistryCredential, concurrency int) (RegistryLoginCredentials, error) {
 	resolvedCreds := make(RegistryLoginCredentials)
 	var unresolvedCreds []*secretmgmt.Secret
 
@@ -483,6 +567,7 @@ func ResolveCustomRegistryCredentials(ctx context.Context, credentials []*Regist
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create secret resolver")
 	}
+	secretResolver.SetConcurrency(concurrency)
 
 	err = secretResolver.ResolveSecrets(ctx, unresolvedCreds)
 	if err != nil {
@@ -137,6 +137,36 @@ func TestInitializeTimeouts(t *testing.T) {
 	}
 }
 
+func TestUnmarshalTaskFromString_Timeout(t *testing.T) {
+	tests := []struct {
+		data            string
+		timeout         int
+		expectedTimeout int
+		shouldError     bool
+	}{
+		{"steps: [{cmd: a}]", 0, 0, false},
+		{"timeout: 300\nsteps: [{cmd: a}]", 0, 300, false},
+		{"timeout: 300\nsteps: [{cmd: a}]", 60, 60, false},
+		{"timeout: -1\nsteps: [{cmd: a}]", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		task, err := UnmarshalTaskFromString(context.Background(), test.data, &TaskOptions{Timeout: test.timeout})
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("expected an error for %q", test.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", test.data, err)
+		}
+		if task.Timeout != test.expectedTimeout {
+			t.Errorf("expected %d as the task's timeout but got %d", test.expectedTimeout, task.Timeout)
+		}
+	}
+}
+
 func TestMergeEnvs(t *testing.T) {
 	tests := []struct {
 		taskEnvs     []string
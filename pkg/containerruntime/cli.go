@@ -0,0 +1,191 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package containerruntime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/procmanager"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerCLIImageName = "docker"
+
+	// toolRemoveTimeout bounds the removal of a docker CLI container once its command is cancelled.
+	toolRemoveTimeout = 30 * time.Second
+)
+
+// CLI is a ContainerRuntime shelling out to a CLI compatible with the docker CLI.
+type CLI struct {
+	procManager *procmanager.ProcManager
+	config      *CLIConfig
+
+	// toolMounts and toolEnvs are given to the docker CLI containers pulling and pushing images,
+	// which must at least mount the runtime's socket.
+	toolMounts []Mount
+	toolEnvs   []string
+}
+
+// NewCLI creates a new CLI for the runtime described by config. Unless the runtime's own CLI is used
+// on the host, images are pulled and pushed from docker CLI containers with the specified mounts and environment variables.
+func NewCLI(pm *procmanager.ProcManager, config *CLIConfig, toolMounts []Mount, toolEnvs []string) *CLI {
+	return &CLI{
+		procManager: pm,
+		config:      config,
+		toolMounts:  toolMounts,
+		toolEnvs:    toolEnvs,
+	}
+}
+
+// Run implements ContainerRuntime.
+func (c *CLI) Run(ctx context.Context, opts *RunOptions, stdout io.Writer, stderr io.Writer) error {
+	return c.procManager.Run(ctx, c.config.RunArgs(opts), nil, stdout, stderr, "")
+}
+
+// Stop implements ContainerRuntime.
+func (c *CLI) Stop(ctx context.Context, name string, timeout time.Duration) error {
+	return c.run(ctx, c.config.StopArgs(name, timeout)...)
+}
+
+// Remove implements ContainerRuntime.
+func (c *CLI) Remove(ctx context.Context, name string) error {
+	return c.run(ctx, c.config.RemoveArgs(name)...)
+}
+
+// Pull implements ContainerRuntime.
+func (c *CLI) Pull(ctx context.Context, image string, stdout io.Writer, stderr io.Writer) error {
+	if c.config.HostTools {
+		return c.procManager.Run(ctx, c.config.PullArgs(image), nil, stdout, stderr, "")
+	}
+	return c.runTool(ctx, stdout, stderr, "pull", image)
+}
+
+// Push implements ContainerRuntime.
+func (c *CLI) Push(ctx context.Context, image string, stdout io.Writer, stderr io.Writer) error {
+	if c.config.HostTools {
+		return c.procManager.Run(ctx, c.config.PushArgs(image), nil, stdout, stderr, "")
+	}
+	return c.runTool(ctx, stdout, stderr, "push", image)
+}
+
+// InspectImage implements ContainerRuntime.
+func (c *CLI) InspectImage(ctx context.Context, image string) (*ImageInfo, error) {
+	var stdout, stderr bytes.Buffer
+	if err := c.procManager.Run(ctx, c.config.ImageInspectArgs(image), nil, &stdout, &stderr, ""); err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect image %s: %s", image, strings.TrimSpace(stderr.String()))
+	}
+	if c.procManager.DryRun {
+		return &ImageInfo{}, nil
+	}
+	var images []*ImageInfo
+	if err := json.Unmarshal(stdout.Bytes(), &images); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the inspection of image %s", image)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no such image: %s", image)
+	}
+	return images[0], nil
+}
+
+// Login implements ContainerRuntime. Unless the runtime's own CLI pulls and pushes images, there's nothing to do,
+// since the docker CLI containers read the credentials from the configuration in their home directory.
+func (c *CLI) Login(ctx context.Context, registry string, username string, password string) error {
+	if !c.config.HostTools {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := c.procManager.Run(ctx, c.config.LoginArgs(registry, username), strings.NewReader(password+"\n"), &buf, &buf, ""); err != nil {
+		return errors.Wrapf(err, "failed to login to %s: %s", registry, strings.TrimSpace(buf.String()))
+	}
+	return nil
+}
+
+// CreateNetwork implements ContainerRuntime.
+func (c *CLI) CreateNetwork(ctx context.Context, opts *NetworkOptions) error {
+	return c.run(ctx, c.config.NetworkCreateArgs(opts)...)
+}
+
+// RemoveNetwork implements ContainerRuntime.
+func (c *CLI) RemoveNetwork(ctx context.Context, name string) error {
+	return c.run(ctx, c.config.NetworkRemoveArgs(name)...)
+}
+
+// CreateVolume implements ContainerRuntime.
+func (c *CLI) CreateVolume(ctx context.Context, name string) error {
+	return c.run(ctx, c.config.VolumeCreateArgs(name)...)
+}
+
+// InspectVolume implements ContainerRuntime.
+func (c *CLI) InspectVolume(ctx context.Context, name string) (*VolumeInfo, error) {
+	var stdout, stderr bytes.Buffer
+	if err := c.procManager.Run(ctx, c.config.VolumeInspectArgs(name), nil, &stdout, &stderr, ""); err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect volume %s: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	if c.procManager.DryRun {
+		return &VolumeInfo{Name: name}, nil
+	}
+	var volumes []*VolumeInfo
+	if err := json.Unmarshal(stdout.Bytes(), &volumes); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the inspection of volume %s", name)
+	}
+	if len(volumes) == 0 {
+		return nil, fmt.Errorf("no such volume: %s", name)
+	}
+	return volumes[0], nil
+}
+
+// RemoveVolume implements ContainerRuntime.
+func (c *CLI) RemoveVolume(ctx context.Context, name string) error {
+	return c.run(ctx, c.config.VolumeRemoveArgs(name)...)
+}
+
+// run runs the args, returning an error including their output if they fail.
+func (c *CLI) run(ctx context.Context, args ...string) error {
+	var buf bytes.Buffer
+	if err := c.procManager.Run(ctx, args, nil, &buf, &buf, ""); err != nil {
+		return errors.Wrapf(err, "failed to run %s: %s", strings.Join(args[:min(3, len(args))], " "), strings.TrimSpace(buf.String()))
+	}
+	return nil
+}
+
+// runTool runs a docker CLI container with the specified args. Killing the runtime's CLI doesn't stop the container,
+// so if ctx is done before it exits, the container is removed.
+func (c *CLI) runTool(ctx context.Context, stdout io.Writer, stderr io.Writer, args ...string) error {
+	name := fmt.Sprintf("acb_docker_%s_%s", args[0], uuid.New())
+	err := c.procManager.Run(ctx, c.getToolArgs(name, args...), nil, stdout, stderr, "")
+	if ctx.Err() != nil {
+		removeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), toolRemoveTimeout)
+		defer cancel()
+		// The container may have already exited and been removed.
+		_ = c.Remove(removeCtx, name)
+	}
+	return err
+}
+
+// getToolArgs returns the args to run a docker CLI container with the specified name and args,
+// driving the runtime through its socket.
+func (c *CLI) getToolArgs(name string, args ...string) []string {
+	toolArgs := []string{
+		c.config.Binary,
+		"run",
+		"--name", name,
+		"--rm",
+	}
+	for _, mount := range c.toolMounts {
+		toolArgs = append(toolArgs, "--volume", mount.Source+":"+mount.Target)
+	}
+	for _, env := range c.toolEnvs {
+		toolArgs = append(toolArgs, "--env", env)
+	}
+	toolArgs = append(toolArgs, dockerCLIImageName)
+	return append(toolArgs, args...)
+}
@@ -0,0 +1,233 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package containerruntime
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CLIConfig describes the command line of a container runtime compatible with the docker CLI, along with its flag dialect.
+type CLIConfig struct {
+	// Name identifies the runtime, e.g. docker.
+	Name string
+
+	// Binary is the runtime's executable.
+	Binary string
+
+	// Socket is the host path of the runtime's Docker compatible API socket, which is mounted into the docker CLI
+	// containers building and pushing images. It's empty if the runtime doesn't serve the Docker API.
+	Socket string
+
+	// HostTools is whether images are pulled and pushed and registries are logged in to with the runtime's own
+	// CLI on the host, rather than from docker CLI containers.
+	HostTools bool
+
+	// VolumeNameFlag is whether volumes are named with --name rather than with an argument when they're created.
+	VolumeNameFlag bool
+
+	// SupportsIsolation and SupportsExpose are whether containers can be run with --isolation and --expose.
+	SupportsIsolation bool
+	SupportsExpose    bool
+}
+
+var (
+	// DockerCLI is the configuration of the docker CLI.
+	DockerCLI = &CLIConfig{
+		Name:              DockerRuntime,
+		Binary:            "docker",
+		Socket:            dockerSocket,
+		VolumeNameFlag:    true,
+		SupportsIsolation: true,
+		SupportsExpose:    true,
+	}
+
+	// PodmanCLI is the configuration of the podman CLI, using the socket of the rootful podman service.
+	PodmanCLI = &CLIConfig{
+		Name:           PodmanRuntime,
+		Binary:         "podman",
+		Socket:         "/run/podman/podman.sock",
+		HostTools:      true,
+		SupportsExpose: true,
+	}
+
+	// NerdctlCLI is the configuration of the nerdctl CLI. containerd doesn't serve the Docker API,
+	// so it has no socket unless one is specified.
+	NerdctlCLI = &CLIConfig{
+		Name:      NerdctlRuntime,
+		Binary:    "nerdctl",
+		HostTools: true,
+	}
+)
+
+// GetCLIConfig returns the configuration of the specified runtime's CLI.
+func GetCLIConfig(name string) (*CLIConfig, error) {
+	switch name {
+	case "", DockerRuntime:
+		return DockerCLI, nil
+	case PodmanRuntime:
+		return PodmanCLI, nil
+	case NerdctlRuntime:
+		return NerdctlCLI, nil
+	default:
+		return nil, fmt.Errorf("unsupported container runtime CLI: %s, must be %s, %s or %s", name, DockerRuntime, PodmanRuntime, NerdctlRuntime)
+	}
+}
+
+// WithSocket returns a copy of the configuration using the specified Docker compatible API socket.
+func (c *CLIConfig) WithSocket(socket string) *CLIConfig {
+	config := *c
+	config.Socket = socket
+	return &config
+}
+
+// SocketMounts returns the mounts exposing the runtime's Docker compatible API socket to the docker CLI in a container.
+func (c *CLIConfig) SocketMounts() []Mount {
+	if c.Socket == "" {
+		return nil
+	}
+	return []Mount{{Source: c.Socket, Target: dockerSocket}}
+}
+
+// RunArgs returns the args to run a container.
+func (c *CLIConfig) RunArgs(opts *RunOptions) []string {
+	var args []string
+	var sb strings.Builder
+	// Run user commands from a shell instance in order to mirror the shell's field splitting algorithms.
+	if runtime.GOOS == "windows" {
+		args = []string{"powershell.exe", "-Command"}
+	} else {
+		args = []string{"/bin/sh", "-c"}
+	}
+
+	sb.WriteString(c.Binary + " run")
+	if opts.Remove {
+		sb.WriteString(" --rm")
+	}
+	if opts.Detach {
+		sb.WriteString(" --detach")
+	}
+	for _, port := range opts.Ports {
+		sb.WriteString(" -p " + port)
+	}
+	if c.SupportsExpose {
+		for _, exp := range opts.Expose {
+			sb.WriteString(" --expose " + exp)
+		}
+	}
+	if opts.Privileged {
+		sb.WriteString(" --privileged")
+	}
+	if opts.User != "" {
+		sb.WriteString(" --user " + opts.User)
+	}
+	if opts.Network != "" {
+		sb.WriteString(" --network " + opts.Network)
+	}
+	if opts.Isolation != "" && c.SupportsIsolation {
+		sb.WriteString(" --isolation " + opts.Isolation)
+	}
+	if opts.CPUs != "" {
+		sb.WriteString(" --cpus " + opts.CPUs)
+	}
+	if opts.Entrypoint != "" {
+		sb.WriteString(" --entrypoint " + opts.Entrypoint)
+	}
+	sb.WriteString(" --name " + opts.Name)
+	for _, mount := range opts.Mounts {
+		sb.WriteString(" --volume " + mount.Source + ":" + mount.Target)
+	}
+
+	// NB: this has the assumption that the underlying runtime handles the case of duplicated
+	// environment variables by only keeping the last specified.
+	for _, env := range opts.Envs {
+		sb.WriteString(" --env " + env)
+	}
+
+	if opts.WorkingDir != "" {
+		sb.WriteString(" --workdir " + opts.WorkingDir)
+	}
+	sb.WriteString(" " + opts.Command)
+
+	args = append(args, sb.String())
+	return args
+}
+
+// StopArgs returns the args to stop a container.
+func (c *CLIConfig) StopArgs(name string, timeout time.Duration) []string {
+	return []string{c.Binary, "stop", "--time", strconv.Itoa(int(timeout.Seconds())), name}
+}
+
+// RemoveArgs returns the args to remove a container, killing it first if it's running.
+func (c *CLIConfig) RemoveArgs(name string) []string {
+	return []string{c.Binary, "rm", "-f", name}
+}
+
+// PullArgs returns the args to pull an image with the runtime's CLI.
+func (c *CLIConfig) PullArgs(image string) []string {
+	return []string{c.Binary, "pull", image}
+}
+
+// PushArgs returns the args to push an image with the runtime's CLI.
+func (c *CLIConfig) PushArgs(image string) []string {
+	return []string{c.Binary, "push", image}
+}
+
+// ImageInspectArgs returns the args to print the JSON description of an image.
+func (c *CLIConfig) ImageInspectArgs(image string) []string {
+	return []string{c.Binary, "image", "inspect", image}
+}
+
+// LoadArgs returns the args to load the images of a tarball read from the standard input.
+func (c *CLIConfig) LoadArgs() []string {
+	return []string{c.Binary, "load"}
+}
+
+// SaveArgs returns the args to write a tarball of the images to the standard output.
+func (c *CLIConfig) SaveArgs(images []string) []string {
+	return append([]string{c.Binary, "save"}, images...)
+}
+
+// LoginArgs returns the args to log in to a registry with the runtime's CLI, reading the password from the standard input.
+func (c *CLIConfig) LoginArgs(registry string, username string) []string {
+	return []string{c.Binary, "login", "--username", username, "--password-stdin", registry}
+}
+
+// NetworkCreateArgs returns the args to create a network.
+func (c *CLIConfig) NetworkCreateArgs(opts *NetworkOptions) []string {
+	args := []string{c.Binary, "network", "create", opts.Name}
+	if opts.IPv6 {
+		args = append(args, "--ipv6")
+	}
+	if opts.Driver != "" {
+		args = append(args, "--driver", opts.Driver)
+	}
+	return args
+}
+
+// NetworkRemoveArgs returns the args to remove a network.
+func (c *CLIConfig) NetworkRemoveArgs(name string) []string {
+	return []string{c.Binary, "network", "rm", name}
+}
+
+// VolumeCreateArgs returns the args to create a volume.
+func (c *CLIConfig) VolumeCreateArgs(name string) []string {
+	if c.VolumeNameFlag {
+		return []string{c.Binary, "volume", "create", "--name", name}
+	}
+	return []string{c.Binary, "volume", "create", name}
+}
+
+// VolumeInspectArgs returns the args to print the JSON description of a volume.
+func (c *CLIConfig) VolumeInspectArgs(name string) []string {
+	return []string{c.Binary, "volume", "inspect", name}
+}
+
+// VolumeRemoveArgs returns the args to remove a volume.
+func (c *CLIConfig) VolumeRemoveArgs(name string) []string {
+	return []string{c.Binary, "volume", "rm", name}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+//go:build linux || darwin
+
+package containerruntime
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCLIConfig_RunArgs(t *testing.T) {
+	opts := &RunOptions{
+		Name:       "step",
+		Command:    "alpine echo hello",
+		Remove:     true,
+		Ports:      []string{"8080:80"},
+		Expose:     []string{"9090"},
+		Privileged: true,
+		User:       "root",
+		Network:    "host",
+		Isolation:  "hyperv",
+		Entrypoint: "sh",
+		Mounts:     []Mount{{Source: "vol", Target: "/workspace"}, {Source: "home", Target: "/acb/home"}},
+		Envs:       []string{"HOME=/acb/home", "foo=bar"},
+		WorkingDir: "/workspace",
+	}
+	tests := []struct {
+		config   *CLIConfig
+		expected string
+	}{
+		{
+			DockerCLI,
+			"docker run --rm -p 8080:80 --expose 9090 --privileged --user root --network host --isolation hyperv --entrypoint sh --name step" +
+				" --volume vol:/workspace --volume home:/acb/home --env HOME=/acb/home --env foo=bar --workdir /workspace alpine echo hello",
+		},
+		{
+			PodmanCLI,
+			"podman run --rm -p 8080:80 --expose 9090 --privileged --user root --network host --entrypoint sh --name step" +
+				" --volume vol:/workspace --volume home:/acb/home --env HOME=/acb/home --env foo=bar --workdir /workspace alpine echo hello",
+		},
+		{
+			NerdctlCLI,
+			"nerdctl run --rm -p 8080:80 --privileged --user root --network host --entrypoint sh --name step" +
+				" --volume vol:/workspace --volume home:/acb/home --env HOME=/acb/home --env foo=bar --workdir /workspace alpine echo hello",
+		},
+	}
+
+	for _, test := range tests {
+		expected := []string{"/bin/sh", "-c", test.expected}
+		if actual := test.config.RunArgs(opts); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected %v, got %v", test.config.Name, expected, actual)
+		}
+	}
+
+	bare := &RunOptions{Name: "step", Command: "alpine"}
+	expected := []string{"/bin/sh", "-c", "docker run --name step alpine"}
+	if actual := DockerCLI.RunArgs(bare); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestCLIConfig_Args(t *testing.T) {
+	network := &NetworkOptions{Name: "net", Driver: "bridge", IPv6: true}
+	tests := []struct {
+		config   *CLIConfig
+		expected [][]string
+	}{
+		{
+			DockerCLI,
+			[][]string{
+				{"docker", "stop", "--time", "10", "step"},
+				{"docker", "rm", "-f", "step"},
+				{"docker", "pull", "alpine"},
+				{"docker", "push", "alpine"},
+				{"docker", "image", "inspect", "alpine"},
+				{"docker", "load"},
+				{"docker", "save", "alpine", "alpine:v1"},
+				{"docker", "login", "--username", "user", "--password-stdin", "registry"},
+				{"docker", "network", "create", "net", "--ipv6", "--driver", "bridge"},
+				{"docker", "network", "rm", "net"},
+				{"docker", "volume", "create", "--name", "vol"},
+				{"docker", "volume", "inspect", "vol"},
+				{"docker", "volume", "rm", "vol"},
+			},
+		},
+		{
+			PodmanCLI,
+			[][]string{
+				{"podman", "stop", "--time", "10", "step"},
+				{"podman", "rm", "-f", "step"},
+				{"podman", "pull", "alpine"},
+				{"podman", "push", "alpine"},
+				{"podman", "image", "inspect", "alpine"},
+				{"podman", "load"},
+				{"podman", "save", "alpine", "alpine:v1"},
+				{"podman", "login", "--username", "user", "--password-stdin", "registry"},
+				{"podman", "network", "create", "net", "--ipv6", "--driver", "bridge"},
+				{"podman", "network", "rm", "net"},
+				{"podman", "volume", "create", "vol"},
+				{"podman", "volume", "inspect", "vol"},
+				{"podman", "volume", "rm", "vol"},
+			},
+		},
+		{
+			NerdctlCLI,
+			[][]string{
+				{"nerdctl", "stop", "--time", "10", "step"},
+				{"nerdctl", "rm", "-f", "step"},
+				{"nerdctl", "pull", "alpine"},
+				{"nerdctl", "push", "alpine"},
+				{"nerdctl", "image", "inspect", "alpine"},
+				{"nerdctl", "load"},
+				{"nerdctl", "save", "alpine", "alpine:v1"},
+				{"nerdctl", "login", "--username", "user", "--password-stdin", "registry"},
+				{"nerdctl", "network", "create", "net", "--ipv6", "--driver", "bridge"},
+				{"nerdctl", "network", "rm", "net"},
+				{"nerdctl", "volume", "create", "vol"},
+				{"nerdctl", "volume", "inspect", "vol"},
+				{"nerdctl", "volume", "rm", "vol"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		c := test.config
+		actual := [][]string{
+			c.StopArgs("step", 10*time.Second),
+			c.RemoveArgs("step"),
+			c.PullArgs("alpine"),
+			c.PushArgs("alpine"),
+			c.ImageInspectArgs("alpine"),
+			c.LoadArgs(),
+			c.SaveArgs([]string{"alpine", "alpine:v1"}),
+			c.LoginArgs("registry", "user"),
+			c.NetworkCreateArgs(network),
+			c.NetworkRemoveArgs("net"),
+			c.VolumeCreateArgs("vol"),
+			c.VolumeInspectArgs("vol"),
+			c.VolumeRemoveArgs("vol"),
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", c.Name, test.expected, actual)
+		}
+	}
+}
+
+func TestCLIConfig_SocketMounts(t *testing.T) {
+	tests := []struct {
+		config   *CLIConfig
+		expected []Mount
+	}{
+		{DockerCLI, []Mount{{Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"}}},
+		{PodmanCLI, []Mount{{Source: "/run/podman/podman.sock", Target: "/var/run/docker.sock"}}},
+		{NerdctlCLI, nil},
+		{PodmanCLI.WithSocket("/run/user/1000/podman/podman.sock"), []Mount{{Source: "/run/user/1000/podman/podman.sock", Target: "/var/run/docker.sock"}}},
+	}
+
+	for _, test := range tests {
+		if actual := test.config.SocketMounts(); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.config.Name, test.expected, actual)
+		}
+	}
+	if PodmanCLI.Socket != "/run/podman/podman.sock" {
+		t.Errorf("WithSocket must not modify the original configuration")
+	}
+}
+
+func TestGetCLIConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		expected    *CLIConfig
+		shouldError bool
+	}{
+		{"", DockerCLI, false},
+		{"docker", DockerCLI, false},
+		{"podman", PodmanCLI, false},
+		{"nerdctl", NerdctlCLI, false},
+		{"crio", nil, true},
+	}
+
+	for _, test := range tests {
+		actual, err := GetCLIConfig(test.name)
+		if (err != nil) != test.shouldError {
+			t.Errorf("unexpected error getting the configuration of %q: %v", test.name, err)
+		}
+		if actual != test.expected {
+			t.Errorf("expected the configuration of %q to be %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,548 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package containerruntime
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultDockerHost is the address of the Docker daemon if DOCKER_HOST isn't set.
+	defaultDockerHost = "unix:///var/run/docker.sock"
+
+	// dockerHubRegistry is the name Docker Hub credentials are stored under.
+	dockerHubRegistry = "docker.io"
+
+	// stdErrStream identifies the standard error in the multiplexed output of containers.
+	stdErrStream = 2
+
+	// apiVersion is the version of the Docker Engine API the requests are made with,
+	// supported by Docker Engine 25.0 and later.
+	apiVersion = "1.44"
+)
+
+// Engine is a ContainerRuntime calling the Docker Engine API. The API version is pinned,
+// so that the format of the requests doesn't depend on the daemon's version.
+type Engine struct {
+	client  *http.Client
+	baseURL string
+
+	mu    sync.Mutex
+	auths map[string]registryAuth
+}
+
+// registryAuth is the credential of a registry, as sent in the X-Registry-Auth header.
+type registryAuth struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+}
+
+// apiError is an error returned by the Docker Engine API.
+type apiError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+// Error implements error.
+func (e *apiError) Error() string {
+	return fmt.Sprintf("Error response from daemon: %s", e.Message)
+}
+
+// NewEngine creates an Engine for the Docker daemon at the specified host, e.g. unix:///var/run/docker.sock
+// or tcp://localhost:2375. If host is empty, DOCKER_HOST or the default socket is used.
+// TLS and named pipes aren't supported.
+func NewEngine(host string) (*Engine, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid Docker host %s", host)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return newEngine(&http.Client{Transport: transport}, "http://docker"), nil
+	case "tcp", "http":
+		return newEngine(&http.Client{}, "http://"+u.Host), nil
+	default:
+		return nil, fmt.Errorf("unsupported Docker host %s, only unix:// and tcp:// hosts are supported", host)
+	}
+}
+
+func newEngine(client *http.Client, baseURL string) *Engine {
+	return &Engine{
+		client:  client,
+		baseURL: baseURL,
+		auths:   map[string]registryAuth{},
+	}
+}
+
+// Run implements ContainerRuntime.
+// Unlike with the CLI, the containers aren't removed by the daemon when they exit. They're removed
+// once their output has been read, and otherwise left running so that they can be stopped gracefully.
+func (e *Engine) Run(ctx context.Context, opts *RunOptions, stdout io.Writer, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	config, err := newContainerConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	id, err := e.createContainer(ctx, opts.Name, config)
+	if isNotFound(err) {
+		fmt.Fprintf(stderr, "Unable to find image '%s' locally\n", config.Image)
+		if err := e.Pull(ctx, config.Image, stderr, stderr); err != nil {
+			return err
+		}
+		id, err = e.createContainer(ctx, opts.Name, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := e.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil, nil); err != nil {
+		return err
+	}
+	if opts.Detach {
+		fmt.Fprintln(stdout, id)
+		return nil
+	}
+
+	// The logs are followed until the container exits, starting from its first output.
+	logsQuery := url.Values{"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	var logs io.ReadCloser
+	if err := e.do(ctx, http.MethodGet, "/containers/"+id+"/logs", logsQuery, nil, nil, &logs); err != nil {
+		return err
+	}
+	err = demuxOutput(logs, stdout, stderr)
+	logs.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the output of container %s", opts.Name)
+	}
+
+	var result struct {
+		StatusCode int
+		Error      *struct{ Message string }
+	}
+	if err := e.doJSON(ctx, http.MethodPost, "/containers/"+id+"/wait", nil, nil, &result); err != nil {
+		return err
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return fmt.Errorf("failed to wait for container %s: %s", opts.Name, result.Error.Message)
+	}
+	if opts.Remove {
+		if err := e.Remove(ctx, id); err != nil {
+			return err
+		}
+	}
+	if result.StatusCode != 0 {
+		return &ExitError{Code: result.StatusCode}
+	}
+	return nil
+}
+
+// Stop implements ContainerRuntime.
+func (e *Engine) Stop(ctx context.Context, name string, timeout time.Duration) error {
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	return e.do(ctx, http.MethodPost, "/containers/"+name+"/stop", query, nil, nil, nil)
+}
+
+// Remove implements ContainerRuntime.
+func (e *Engine) Remove(ctx context.Context, name string) error {
+	return e.do(ctx, http.MethodDelete, "/containers/"+name, url.Values{"force": {"1"}}, nil, nil, nil)
+}
+
+// Pull implements ContainerRuntime.
+func (e *Engine) Pull(ctx context.Context, image string, stdout io.Writer, _ io.Writer) error {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return errors.Wrapf(err, "invalid image %s", image)
+	}
+	named = reference.TagNameOnly(named)
+	query := url.Values{"fromImage": {named.Name()}}
+	if digested, ok := named.(reference.Digested); ok {
+		query.Set("tag", digested.Digest().String())
+	} else if tagged, ok := named.(reference.Tagged); ok {
+		query.Set("tag", tagged.Tag())
+	}
+	return e.stream(ctx, "/images/create", query, reference.Domain(named), stdout)
+}
+
+// Push implements ContainerRuntime.
+func (e *Engine) Push(ctx context.Context, image string, stdout io.Writer, _ io.Writer) error {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return errors.Wrapf(err, "invalid image %s", image)
+	}
+	query := url.Values{}
+	if tagged, ok := named.(reference.Tagged); ok {
+		query.Set("tag", tagged.Tag())
+	}
+	return e.stream(ctx, "/images/"+named.Name()+"/push", query, reference.Domain(named), stdout)
+}
+
+// InspectImage implements ContainerRuntime.
+func (e *Engine) InspectImage(ctx context.Context, image string) (*ImageInfo, error) {
+	var info ImageInfo
+	if err := e.doJSON(ctx, http.MethodGet, "/images/"+image+"/json", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Login implements ContainerRuntime. The credentials are validated by the daemon
+// and sent along with the subsequent pulls and pushes from and to the registry.
+func (e *Engine) Login(ctx context.Context, registry string, username string, password string) error {
+	auth := registryAuth{
+		Username:      username,
+		Password:      password,
+		ServerAddress: registry,
+	}
+	if err := e.doJSON(ctx, http.MethodPost, "/auth", nil, auth, nil); err != nil {
+		return errors.Wrapf(err, "failed to login to %s", registry)
+	}
+	e.mu.Lock()
+	e.auths[normalizeRegistry(registry)] = auth
+	e.mu.Unlock()
+	return nil
+}
+
+// CreateNetwork implements ContainerRuntime.
+func (e *Engine) CreateNetwork(ctx context.Context, opts *NetworkOptions) error {
+	body := struct {
+		Name       string
+		Driver     string `json:",omitempty"`
+		EnableIPv6 bool
+	}{
+		Name:       opts.Name,
+		Driver:     opts.Driver,
+		EnableIPv6: opts.IPv6,
+	}
+	return e.doJSON(ctx, http.MethodPost, "/networks/create", nil, body, nil)
+}
+
+// RemoveNetwork implements ContainerRuntime.
+func (e *Engine) RemoveNetwork(ctx context.Context, name string) error {
+	return e.do(ctx, http.MethodDelete, "/networks/"+name, nil, nil, nil, nil)
+}
+
+// CreateVolume implements ContainerRuntime.
+func (e *Engine) CreateVolume(ctx context.Context, name string) error {
+	return e.doJSON(ctx, http.MethodPost, "/volumes/create", nil, struct{ Name string }{name}, nil)
+}
+
+// InspectVolume implements ContainerRuntime.
+func (e *Engine) InspectVolume(ctx context.Context, name string) (*VolumeInfo, error) {
+	var info VolumeInfo
+	if err := e.doJSON(ctx, http.MethodGet, "/volumes/"+name, nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// RemoveVolume implements ContainerRuntime.
+func (e *Engine) RemoveVolume(ctx context.Context, name string) error {
+	return e.do(ctx, http.MethodDelete, "/volumes/"+name, nil, nil, nil, nil)
+}
+
+// containerConfig is the body of a container creation.
+type containerConfig struct {
+	Image        string
+	Cmd          []string            `json:",omitempty"`
+	Entrypoint   []string            `json:",omitempty"`
+	Env          []string            `json:",omitempty"`
+	WorkingDir   string              `json:",omitempty"`
+	User         string              `json:",omitempty"`
+	ExposedPorts map[string]struct{} `json:",omitempty"`
+	HostConfig   hostConfig
+}
+
+type hostConfig struct {
+	Binds        []string                 `json:",omitempty"`
+	NetworkMode  string                   `json:",omitempty"`
+	Privileged   bool                     `json:",omitempty"`
+	PortBindings map[string][]portBinding `json:",omitempty"`
+	Isolation    string                   `json:",omitempty"`
+	NanoCPUs     int64                    `json:"NanoCpus,omitempty"`
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string
+}
+
+// newContainerConfig converts the options to the configuration of a container.
+func newContainerConfig(opts *RunOptions) (*containerConfig, error) {
+	words, err := SplitCommandLine(opts.Command)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the command of container %s", opts.Name)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("the command of container %s doesn't specify an image", opts.Name)
+	}
+	// Environment variables are passed as --env args on the command line by the CLI, so their quotes are removed the same way.
+	var envs []string
+	for _, env := range opts.Envs {
+		envWords, err := SplitCommandLine(env)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the environment variable %s of container %s", env, opts.Name)
+		}
+		if len(envWords) != 1 {
+			return nil, fmt.Errorf("the environment variable %s of container %s must be quoted since it contains whitespace", env, opts.Name)
+		}
+		envs = append(envs, envWords[0])
+	}
+	config := &containerConfig{
+		Image:      words[0],
+		Cmd:        words[1:],
+		Env:        envs,
+		WorkingDir: opts.WorkingDir,
+		User:       opts.User,
+		HostConfig: hostConfig{
+			NetworkMode: opts.Network,
+			Privileged:  opts.Privileged,
+			Isolation:   opts.Isolation,
+		},
+	}
+	if opts.Entrypoint != "" {
+		if config.Entrypoint, err = SplitCommandLine(opts.Entrypoint); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse the entrypoint of container %s", opts.Name)
+		}
+	}
+	for _, mount := range opts.Mounts {
+		config.HostConfig.Binds = append(config.HostConfig.Binds, mount.Source+":"+mount.Target)
+	}
+	if opts.CPUs != "" {
+		cpus, err := strconv.ParseFloat(opts.CPUs, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cpus %s", opts.CPUs)
+		}
+		config.HostConfig.NanoCPUs = int64(cpus * 1e9)
+	}
+	for _, exp := range opts.Expose {
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+		}
+		config.ExposedPorts[normalizePort(exp)] = struct{}{}
+	}
+	for _, port := range opts.Ports {
+		containerPort, binding, err := parsePortBinding(port)
+		if err != nil {
+			return nil, err
+		}
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+		}
+		if config.HostConfig.PortBindings == nil {
+			config.HostConfig.PortBindings = map[string][]portBinding{}
+		}
+		config.ExposedPorts[containerPort] = struct{}{}
+		config.HostConfig.PortBindings[containerPort] = append(config.HostConfig.PortBindings[containerPort], binding)
+	}
+	return config, nil
+}
+
+// parsePortBinding parses a port published as [[hostIP:]hostPort:]containerPort[/protocol].
+// Port ranges aren't supported.
+func parsePortBinding(port string) (string, portBinding, error) {
+	var binding portBinding
+	parts := strings.Split(port, ":")
+	switch len(parts) {
+	case 1:
+	case 2:
+		binding.HostPort = parts[0]
+	case 3:
+		binding.HostIP = parts[0]
+		binding.HostPort = parts[1]
+	default:
+		return "", binding, fmt.Errorf("invalid port %s", port)
+	}
+	containerPort := normalizePort(parts[len(parts)-1])
+	if strings.Contains(containerPort, "-") || strings.Contains(binding.HostPort, "-") {
+		return "", binding, fmt.Errorf("port ranges aren't supported by the engine runtime: %s", port)
+	}
+	return containerPort, binding, nil
+}
+
+// normalizePort appends the default protocol to a port if it has none.
+func normalizePort(port string) string {
+	if !strings.Contains(port, "/") {
+		return port + "/tcp"
+	}
+	return port
+}
+
+// createContainer creates a container and returns its ID.
+func (e *Engine) createContainer(ctx context.Context, name string, config *containerConfig) (string, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := e.doJSON(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// stream performs a request authenticated to the registry which responds with a stream of progress messages,
+// displaying them to out.
+func (e *Engine) stream(ctx context.Context, path string, query url.Values, registry string, out io.Writer) error {
+	if out == nil {
+		out = io.Discard
+	}
+	e.mu.Lock()
+	auth := e.auths[normalizeRegistry(registry)]
+	e.mu.Unlock()
+	authJSON, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"X-Registry-Auth": {base64.URLEncoding.EncodeToString(authJSON)}}
+
+	var body io.ReadCloser
+	if err := e.do(ctx, http.MethodPost, path, query, nil, header, &body); err != nil {
+		return err
+	}
+	defer body.Close()
+	return jsonmessage.DisplayJSONMessagesStream(body, out, 0, false, nil)
+}
+
+// doJSON performs a request with an optional JSON body, decoding the JSON response to result if it's specified.
+func (e *Engine) doJSON(ctx context.Context, method string, path string, query url.Values, in interface{}, result interface{}) error {
+	var body io.ReadCloser
+	if err := e.do(ctx, method, path, query, in, nil, &body); err != nil {
+		return err
+	}
+	defer body.Close()
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(body).Decode(result); err != nil {
+		return errors.Wrapf(err, "failed to decode the response of %s %s", method, path)
+	}
+	return nil
+}
+
+// do performs a request with an optional JSON body. If body is specified, it's set to the body
+// of the response which must then be closed, otherwise the body is discarded.
+func (e *Engine) do(ctx context.Context, method string, path string, query url.Values, in interface{}, header http.Header, body *io.ReadCloser) error {
+	var reqBody io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	u := e.baseURL + "/v" + apiVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the Docker daemon")
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		b, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(b, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		return apiErr
+	}
+	if body == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+	*body = resp.Body
+	return nil
+}
+
+// isNotFound returns whether the error was caused by a missing object.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// demuxOutput copies the multiplexed output of a container to stdout and stderr.
+// Each frame is prefixed by a header made of the stream type and the size of the frame.
+func demuxOutput(r io.Reader, stdout io.Writer, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if header[0] == stdErrStream {
+			w = stderr
+		}
+		if _, err := io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+// normalizeRegistry returns the name credentials of the registry are stored under.
+func normalizeRegistry(registry string) string {
+	registry = strings.ToLower(registry)
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	registry = strings.TrimSuffix(registry, "/")
+	switch registry {
+	case "", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com", "index.docker.io/v1":
+		return dockerHubRegistry
+	}
+	return registry
+}
@@ -0,0 +1,359 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package containerruntime
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/acr-builder/pkg/procmanager"
+)
+
+// fakeDaemon emulates the Docker Engine API endpoints used by the Engine, recording the requests it receives
+// along with their bodies. Requests which aren't made with the pinned API version are rejected.
+type fakeDaemon struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string][]byte
+	images   map[string]bool
+	created  *containerConfig
+	auth     string
+	exitCode int
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path, ok := strings.CutPrefix(r.URL.Path, "/v"+apiVersion)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message":"unexpected API version: ` + r.URL.Path + `"}`))
+		return
+	}
+	d.requests = append(d.requests, r.Method+" "+path)
+	body, _ := io.ReadAll(r.Body)
+	d.bodies[path] = body
+
+	switch {
+	case path == "/containers/create":
+		var config containerConfig
+		_ = json.Unmarshal(body, &config)
+		if !d.images[config.Image] {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"No such image: ` + config.Image + `"}`))
+			return
+		}
+		d.created = &config
+		_, _ = w.Write([]byte(`{"Id":"abc123"}`))
+	case path == "/images/create":
+		d.auth = r.Header.Get("X-Registry-Auth")
+		d.images[strings.TrimPrefix(r.URL.Query().Get("fromImage"), "docker.io/library/")] = true
+		_, _ = w.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"status":"Downloaded newer image"}` + "\n"))
+	case strings.HasSuffix(path, "/push"):
+		d.auth = r.Header.Get("X-Registry-Auth")
+		_, _ = w.Write([]byte(`{"status":"Pushing"}` + "\n" + `{"errorDetail":{"message":"denied"},"error":"denied"}` + "\n"))
+	case path == "/containers/abc123/logs":
+		writeFrame(w, 1, "hello\n")
+		writeFrame(w, 2, "oops\n")
+	case path == "/containers/abc123/wait":
+		_ = json.NewEncoder(w).Encode(map[string]int{"StatusCode": d.exitCode})
+	case path == "/images/hello-world/json":
+		_, _ = w.Write([]byte(`{"Id":"sha256:d2c94e258dcb"}`))
+	case path == "/images/missing/json":
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"No such image: missing:latest"}`))
+	case path == "/volumes/home":
+		_, _ = w.Write([]byte(`{"Name":"home","Driver":"local","Mountpoint":"/var/lib/docker/volumes/home/_data"}`))
+	case path == "/volumes/missing":
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"get missing: no such volume"}`))
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeFrame(w io.Writer, stream byte, s string) {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(s)))
+	_, _ = w.Write(header)
+	_, _ = w.Write([]byte(s))
+}
+
+func newTestEngine(t *testing.T) (*Engine, *fakeDaemon) {
+	t.Helper()
+	daemon := &fakeDaemon{images: map[string]bool{}, bodies: map[string][]byte{}}
+	server := httptest.NewServer(daemon)
+	t.Cleanup(server.Close)
+	engine, err := NewEngine("tcp://" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to create the engine: %v", err)
+	}
+	return engine, daemon
+}
+
+func TestEngine_Run(t *testing.T) {
+	engine, daemon := newTestEngine(t)
+	daemon.exitCode = 3
+
+	var stdout, stderr bytes.Buffer
+	err := engine.Run(context.Background(), &RunOptions{
+		Name:       "step",
+		Command:    `alpine sh -c "echo hello"`,
+		Remove:     true,
+		Ports:      []string{"8080:80", "127.0.0.1:53:53/udp"},
+		CPUs:       "0.5",
+		Mounts:     []Mount{{Source: "home", Target: "/acb/home"}},
+		Envs:       []string{"HOME=/acb/home"},
+		WorkingDir: "/workspace",
+	}, &stdout, &stderr)
+	if procmanager.ExitCode(err) != 3 {
+		t.Fatalf("expected the exit code 3, got %v", err)
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("unexpected stdout: %q", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "Unable to find image 'alpine' locally") || !strings.HasSuffix(stderr.String(), "oops\n") {
+		t.Errorf("unexpected stderr: %q", stderr.String())
+	}
+
+	expectedRequests := []string{
+		"POST /containers/create",
+		"POST /images/create",
+		"POST /containers/create",
+		"POST /containers/abc123/start",
+		"GET /containers/abc123/logs",
+		"POST /containers/abc123/wait",
+		"DELETE /containers/abc123",
+	}
+	if !reflect.DeepEqual(daemon.requests, expectedRequests) {
+		t.Errorf("expected requests %v, got %v", expectedRequests, daemon.requests)
+	}
+
+	config := daemon.created
+	if config.Image != "alpine" || !reflect.DeepEqual(config.Cmd, []string{"sh", "-c", "echo hello"}) {
+		t.Errorf("unexpected image and command: %s %v", config.Image, config.Cmd)
+	}
+	if !reflect.DeepEqual(config.HostConfig.Binds, []string{"home:/acb/home"}) || config.WorkingDir != "/workspace" {
+		t.Errorf("unexpected binds and working directory: %v %s", config.HostConfig.Binds, config.WorkingDir)
+	}
+	if config.HostConfig.NanoCPUs != 5e8 {
+		t.Errorf("expected 5e8 nano CPUs, got %d", config.HostConfig.NanoCPUs)
+	}
+	expectedBindings := map[string][]portBinding{
+		"80/tcp": {{HostPort: "8080"}},
+		"53/udp": {{HostIP: "127.0.0.1", HostPort: "53"}},
+	}
+	if !reflect.DeepEqual(config.HostConfig.PortBindings, expectedBindings) {
+		t.Errorf("expected port bindings %v, got %v", expectedBindings, config.HostConfig.PortBindings)
+	}
+}
+
+// TestEngine_WireFormat ensures that the bodies of the requests match the schemas of the pinned API version.
+func TestEngine_WireFormat(t *testing.T) {
+	engine, daemon := newTestEngine(t)
+	daemon.images["alpine"] = true
+	ctx := context.Background()
+
+	err := engine.Run(ctx, &RunOptions{
+		Name:       "step",
+		Command:    `alpine sh -c "echo hello"`,
+		Entrypoint: "/bin/sh",
+		Privileged: true,
+		Ports:      []string{"8080:80"},
+		Expose:     []string{"9090"},
+		User:       "1000",
+		Network:    "acb_default_network",
+		Isolation:  "process",
+		CPUs:       "1.5",
+		Mounts:     []Mount{{Source: "home", Target: "/acb/home"}},
+		Envs:       []string{"HOME=/acb/home"},
+		WorkingDir: "/workspace",
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to run the container: %v", err)
+	}
+	if err := engine.CreateNetwork(ctx, &NetworkOptions{Name: "net", Driver: "bridge", IPv6: true}); err != nil {
+		t.Fatalf("failed to create the network: %v", err)
+	}
+	if err := engine.CreateVolume(ctx, "home"); err != nil {
+		t.Fatalf("failed to create the volume: %v", err)
+	}
+	if err := engine.Login(ctx, "myregistry.azurecr.io", "user", "pw"); err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	expectedBodies := map[string]string{
+		"/containers/create": `{
+			"Image": "alpine",
+			"Cmd": ["sh", "-c", "echo hello"],
+			"Entrypoint": ["/bin/sh"],
+			"Env": ["HOME=/acb/home"],
+			"WorkingDir": "/workspace",
+			"User": "1000",
+			"ExposedPorts": {"80/tcp": {}, "9090/tcp": {}},
+			"HostConfig": {
+				"Binds": ["home:/acb/home"],
+				"NetworkMode": "acb_default_network",
+				"Privileged": true,
+				"PortBindings": {"80/tcp": [{"HostIp": "", "HostPort": "8080"}]},
+				"Isolation": "process",
+				"NanoCpus": 1500000000
+			}
+		}`,
+		"/networks/create": `{"Name": "net", "Driver": "bridge", "EnableIPv6": true}`,
+		"/volumes/create":  `{"Name": "home"}`,
+		"/auth":            `{"username": "user", "password": "pw", "serveraddress": "myregistry.azurecr.io"}`,
+	}
+	for path, expectedBody := range expectedBodies {
+		var expected, actual interface{}
+		if err := json.Unmarshal([]byte(expectedBody), &expected); err != nil {
+			t.Fatalf("invalid expected body of %s: %v", path, err)
+		}
+		if err := json.Unmarshal(daemon.bodies[path], &actual); err != nil {
+			t.Errorf("invalid body of %s: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected the body of %s to be %v, got %v", path, expected, actual)
+		}
+	}
+}
+
+func TestNewContainerConfig_Envs(t *testing.T) {
+	tests := []struct {
+		envs        []string
+		expected    []string
+		shouldError bool
+	}{
+		{[]string{"HOME=/acb/home", `FOO="a b"`, "BAR='$x'"}, []string{"HOME=/acb/home", "FOO=a b", "BAR=$x"}, false},
+		{[]string{"FOO=a b"}, nil, true},
+		{[]string{"FOO=$HOME"}, nil, true},
+		{[]string{`FOO="unterminated`}, nil, true},
+	}
+	for _, test := range tests {
+		config, err := newContainerConfig(&RunOptions{Name: "step", Command: "alpine", Envs: test.envs})
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("expected envs %q to error but they didn't", test.envs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("envs %q shouldn't have errored, but they did; err: %v", test.envs, err)
+		} else if !reflect.DeepEqual(config.Env, test.expected) {
+			t.Errorf("expected envs %q to be normalized to %q, got %q", test.envs, test.expected, config.Env)
+		}
+	}
+}
+
+func TestEngine_Push(t *testing.T) {
+	engine, daemon := newTestEngine(t)
+	if err := engine.Login(context.Background(), "https://MyRegistry.azurecr.io", "user", "pw"); err != nil {
+		t.Fatalf("failed to login: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	err := engine.Push(context.Background(), "myregistry.azurecr.io/hello:v1", &stdout, &stdout)
+	if err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("expected the push to be denied, got %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Pushing") {
+		t.Errorf("expected the progress to be displayed, got %q", stdout.String())
+	}
+
+	expectedRequests := []string{"POST /auth", "POST /images/myregistry.azurecr.io/hello/push"}
+	if !reflect.DeepEqual(daemon.requests, expectedRequests) {
+		t.Errorf("expected requests %v, got %v", expectedRequests, daemon.requests)
+	}
+	authJSON, _ := base64.URLEncoding.DecodeString(daemon.auth)
+	var auth registryAuth
+	if err := json.Unmarshal(authJSON, &auth); err != nil || auth.Username != "user" || auth.Password != "pw" {
+		t.Errorf("expected the registry's credentials to be sent, got %s", authJSON)
+	}
+}
+
+func TestEngine_InspectImage(t *testing.T) {
+	engine, daemon := newTestEngine(t)
+	ctx := context.Background()
+
+	info, err := engine.InspectImage(ctx, "hello-world")
+	if err != nil {
+		t.Fatalf("failed to inspect the image: %v", err)
+	}
+	if info.ID != "sha256:d2c94e258dcb" {
+		t.Errorf("unexpected image: %+v", info)
+	}
+	if _, err := engine.InspectImage(ctx, "missing"); !isNotFound(err) {
+		t.Errorf("expected the image not to be found, got %v", err)
+	}
+
+	expectedRequests := []string{"GET /images/hello-world/json", "GET /images/missing/json"}
+	if !reflect.DeepEqual(daemon.requests, expectedRequests) {
+		t.Errorf("expected requests %v, got %v", expectedRequests, daemon.requests)
+	}
+}
+
+func TestEngine_Volumes(t *testing.T) {
+	engine, daemon := newTestEngine(t)
+	ctx := context.Background()
+
+	if err := engine.CreateVolume(ctx, "home"); err != nil {
+		t.Fatalf("failed to create the volume: %v", err)
+	}
+	info, err := engine.InspectVolume(ctx, "home")
+	if err != nil {
+		t.Fatalf("failed to inspect the volume: %v", err)
+	}
+	if info.Name != "home" || info.Driver != "local" {
+		t.Errorf("unexpected volume: %+v", info)
+	}
+	if _, err := engine.InspectVolume(ctx, "missing"); !isNotFound(err) || !strings.Contains(err.Error(), "no such volume") {
+		t.Errorf("expected the volume not to be found, got %v", err)
+	}
+	if err := engine.RemoveVolume(ctx, "home"); err != nil {
+		t.Fatalf("failed to remove the volume: %v", err)
+	}
+	if err := engine.CreateNetwork(ctx, &NetworkOptions{Name: "net"}); err != nil {
+		t.Fatalf("failed to create the network: %v", err)
+	}
+
+	expectedRequests := []string{
+		"POST /volumes/create",
+		"GET /volumes/home",
+		"GET /volumes/missing",
+		"DELETE /volumes/home",
+		"POST /networks/create",
+	}
+	if !reflect.DeepEqual(daemon.requests, expectedRequests) {
+		t.Errorf("expected requests %v, got %v", expectedRequests, daemon.requests)
+	}
+}
+
+func TestNewEngine(t *testing.T) {
+	tests := []struct {
+		host        string
+		shouldError bool
+	}{
+		{"unix:///var/run/docker.sock", false},
+		{"tcp://localhost:2375", false},
+		{"npipe:////./pipe/docker_engine", true},
+	}
+
+	for _, test := range tests {
+		if _, err := NewEngine(test.host); (err != nil) != test.shouldError {
+			t.Errorf("unexpected error creating an engine for %s: %v", test.host, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package containerruntime runs containers and manages the images, networks and volumes they use,
+// either by shelling out to a CLI compatible with the docker CLI, like podman or nerdctl, or by calling the Docker Engine API.
+//
+// The Engine calls the Docker Engine API over HTTP itself rather than through github.com/docker/docker/client:
+// the client depends on modules which aren't vendored, like github.com/docker/go-connections and
+// github.com/moby/docker-image-spec, for the handful of endpoints it needs. Its requests are made with
+// a pinned API version, whose schemas the tests check the bodies of the requests against.
+package containerruntime
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The supported ContainerRuntime implementations.
+const (
+	// DockerRuntime shells out to the docker CLI.
+	DockerRuntime = "docker"
+
+	// PodmanRuntime shells out to the podman CLI.
+	PodmanRuntime = "podman"
+
+	// NerdctlRuntime shells out to the nerdctl CLI.
+	NerdctlRuntime = "nerdctl"
+
+	// EngineRuntime calls the Docker Engine API of the daemon at DOCKER_HOST.
+	EngineRuntime = "engine"
+)
+
+// ContainerRuntime runs containers and manages the images, networks and volumes they use.
+// Implementations must be safe for concurrent use.
+type ContainerRuntime interface {
+	// Run runs a container and blocks until it exits, writing its output to stdout and stderr.
+	// Detached containers are only started. If the container exits with a non-zero code,
+	// the returned error implements ExitCode() int.
+	Run(ctx context.Context, opts *RunOptions, stdout io.Writer, stderr io.Writer) error
+
+	// Stop stops a running container, killing it if it doesn't exit within the timeout.
+	Stop(ctx context.Context, name string, timeout time.Duration) error
+
+	// Remove removes a container, killing it first if it's running.
+	Remove(ctx context.Context, name string) error
+
+	// Pull pulls an image, writing the progress to stdout.
+	Pull(ctx context.Context, image string, stdout io.Writer, stderr io.Writer) error
+
+	// Push pushes an image, writing the progress to stdout.
+	Push(ctx context.Context, image string, stdout io.Writer, stderr io.Writer) error
+
+	// InspectImage returns information about a local image.
+	InspectImage(ctx context.Context, image string) (*ImageInfo, error)
+
+	// Login authenticates the runtime's pulls and pushes to the registry.
+	Login(ctx context.Context, registry string, username string, password string) error
+
+	// CreateNetwork creates a network.
+	CreateNetwork(ctx context.Context, opts *NetworkOptions) error
+
+	// RemoveNetwork removes a network.
+	RemoveNetwork(ctx context.Context, name string) error
+
+	// CreateVolume creates a volume.
+	CreateVolume(ctx context.Context, name string) error
+
+	// InspectVolume returns information about a volume.
+	InspectVolume(ctx context.Context, name string) (*VolumeInfo, error)
+
+	// RemoveVolume removes a volume.
+	RemoveVolume(ctx context.Context, name string) error
+}
+
+// Mount is a volume or a host path mounted into a container.
+type Mount struct {
+	Source string
+	Target string
+}
+
+// RunOptions describe a container to run.
+type RunOptions struct {
+	Name string
+
+	// Command is the image to run followed by its args, as a command line which is split into words like a shell would.
+	Command string
+
+	// Entrypoint overrides the image's entrypoint if it's specified.
+	Entrypoint string
+
+	Remove     bool
+	Detach     bool
+	Privileged bool
+	Ports      []string
+	Expose     []string
+	User       string
+	Network    string
+	Isolation  string
+	CPUs       string
+	Mounts     []Mount
+
+	// Envs are the container's environment variables, later values overriding earlier ones.
+	Envs []string
+
+	// WorkingDir is the container's working directory, the image's if it's empty.
+	WorkingDir string
+}
+
+// NetworkOptions describe a network to create.
+type NetworkOptions struct {
+	Name   string
+	Driver string
+	IPv6   bool
+}
+
+// ImageInfo describes an image.
+type ImageInfo struct {
+	ID string `json:"Id"`
+}
+
+// VolumeInfo describes a volume.
+type VolumeInfo struct {
+	Name       string `json:"Name"`
+	Driver     string `json:"Driver"`
+	Mountpoint string `json:"Mountpoint"`
+}
+
+// ExitError is returned when a container exits with a non-zero code.
+type ExitError struct {
+	Code int
+}
+
+// Error implements error.
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the exit code of the container.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
@@ -0,0 +1,9 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+//go:build linux || darwin
+
+package containerruntime
+
+// dockerSocket is where the docker CLI expects the Docker socket.
+const dockerSocket = "/var/run/docker.sock"
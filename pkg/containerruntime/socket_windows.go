@@ -0,0 +1,7 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package containerruntime
+
+// dockerSocket is where the docker CLI expects the Docker named pipe.
+const dockerSocket = "\\\\.\\pipe\\docker_engine"
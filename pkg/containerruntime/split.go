@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package containerruntime
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errUnterminatedQuote    = errors.New("unterminated quote")
+	errUnsupportedExpansion = errors.New("variable and command expansions aren't supported, single-quote or escape $ and ` to use them verbatim")
+)
+
+// SplitCommandLine splits a command line into words like a POSIX shell would, honoring single quotes,
+// double quotes and backslash escapes. Variables, globs and other expansions aren't supported: since a shell
+// would expand them, unescaped $ and ` outside of single quotes are rejected rather than kept verbatim.
+func SplitCommandLine(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '$' || c == '`':
+			return nil, errUnsupportedExpansion
+		case c == '\\':
+			inWord = true
+			if i+1 < len(s) {
+				i++
+				word.WriteByte(s[i])
+			}
+		case c == '\'':
+			inWord = true
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errUnterminatedQuote
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			inWord = true
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				if s[i] == '$' || s[i] == '`' {
+					return nil, errUnsupportedExpansion
+				}
+				// Within double quotes, backslashes only escape the characters which are special there.
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, errUnterminatedQuote
+			}
+		default:
+			inWord = true
+			word.WriteByte(c)
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package containerruntime
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		s           string
+		expected    []string
+		shouldError bool
+	}{
+		{"", nil, false},
+		{"   ", nil, false},
+		{"alpine", []string{"alpine"}, false},
+		{"  alpine   echo  hello ", []string{"alpine", "echo", "hello"}, false},
+		{`bash -c "echo 'hello world' && ls"`, []string{"bash", "-c", "echo 'hello world' && ls"}, false},
+		{`bash -c 'echo "$HOME"'`, []string{"bash", "-c", `echo "$HOME"`}, false},
+		{`echo "a \"b\" \c"`, []string{"echo", `a "b" \c`}, false},
+		{`echo hello\ world \"`, []string{"echo", "hello world", `"`}, false},
+		{`echo ""  ''`, []string{"echo", "", ""}, false},
+		{`echo a"b"'c'`, []string{"echo", "abc"}, false},
+		{`echo "unterminated`, nil, true},
+		{`echo 'unterminated`, nil, true},
+		{`echo \$HOME '$HOME' "\$HOME"`, []string{"echo", "$HOME", "$HOME", "$HOME"}, false},
+		{`echo $HOME`, nil, true},
+		{`echo "${HOME}"`, nil, true},
+		{"echo `id`", nil, true},
+	}
+
+	for _, test := range tests {
+		actual, err := SplitCommandLine(test.s)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("expected an error splitting %q", test.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to split %q: %v", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected %q to be split into %q, got %q", test.s, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package execution describes the executions of the processes run for a task.
+package execution
+
+import "time"
+
+// Attempt describes a single execution of a process, including retries and repetitions.
+type Attempt struct {
+	// Repetition is the 0-based repetition the attempt belongs to.
+	Repetition int `json:"repetition"`
+	// Retry is the 0-based retry number within the repetition, 0 being the initial execution.
+	Retry     int       `json:"retry"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	ExitCode  int       `json:"exitCode"`
+	Error     string    `json:"error,omitempty"`
+}
@@ -15,14 +15,25 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Azure/acr-builder/pkg/execution"
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/Azure/acr-builder/pkg/util"
 )
 
+// AttemptFunc is invoked after every attempt made by RunWithRetries.
+type AttemptFunc func(attempt execution.Attempt)
+
+// RunFunc performs a single attempt of an operation retried by RunFuncWithRetryPolicy, writing its output to stdOut and stdErr.
+type RunFunc func(ctx context.Context, stdOut io.Writer, stdErr io.Writer) error
+
 // ProcManager is a wrapper for os.Process.
 type ProcManager struct {
 	DryRun    bool
 	mu        sync.Mutex
 	processes map[int]*os.Process
+
+	// redactor masks secrets in the output written to files, if it's set.
+	redactor *redact.Redactor
 }
 
 // NewProcManager creates a new ProcManager.
@@ -34,6 +45,21 @@ func NewProcManager(dryRun bool) *ProcManager {
 	}
 }
 
+// SetRedactor sets the redactor masking the secrets in the output of processes written to files, e.g. the console,
+// and in logged arguments. Output written to other writers is captured to be parsed, so it's left as is.
+func (pm *ProcManager) SetRedactor(redactor *redact.Redactor) {
+	pm.redactor = redactor
+}
+
+// redactFile returns a writer masking the secrets written to w if it's a file, along with a function flushing it.
+func (pm *ProcManager) redactFile(w io.Writer) (io.Writer, func()) {
+	if f, ok := w.(*os.File); ok && pm.redactor != nil {
+		rw := pm.redactor.NewWriter(f)
+		return rw, func() { _ = rw.Flush() }
+	}
+	return w, func() {}
+}
+
 // RunRepeatWithRetries performs a Run multiple times with retries.
 // If any error occurs during the repetition, all errors will be aggregated and returned.
 func (pm *ProcManager) RunRepeatWithRetries(
@@ -47,13 +73,60 @@ func (pm *ProcManager) RunRepeatWithRetries(
 	retryOnErrors []string,
 	retryDelay int,
 	containerName string,
-	repeat int) error {
+	repeat int,
+	onAttempt AttemptFunc) error {
+	policy := &RetryPolicy{
+		Retries:       retries,
+		RetryOnErrors: retryOnErrors,
+		Delay:         time.Duration(retryDelay) * time.Second,
+	}
+	return pm.RunRepeatWithRetryPolicy(ctx, args, stdIn, stdOut, stdErr, cmdDir, policy, containerName, repeat, onAttempt)
+}
+
+// RunRepeatWithRetryPolicy performs a Run multiple times, retrying according to the policy.
+// If any error occurs during the repetition, all errors will be aggregated and returned.
+func (pm *ProcManager) RunRepeatWithRetryPolicy(
+	ctx context.Context,
+	args []string,
+	stdIn io.Reader,
+	stdOut io.Writer,
+	stdErr io.Writer,
+	cmdDir string,
+	policy *RetryPolicy,
+	containerName string,
+	repeat int,
+	onAttempt AttemptFunc) error {
+	return pm.RunFuncRepeatWithRetryPolicy(ctx, pm.runFunc(args, stdIn, cmdDir), stdOut, stdErr, policy, containerName, repeat, onAttempt)
+}
+
+// RunFuncRepeatWithRetryPolicy performs run multiple times, retrying according to the policy.
+// If any error occurs during the repetition, all errors will be aggregated and returned.
+func (pm *ProcManager) RunFuncRepeatWithRetryPolicy(
+	ctx context.Context,
+	run RunFunc,
+	stdOut io.Writer,
+	stdErr io.Writer,
+	policy *RetryPolicy,
+	containerName string,
+	repeat int,
+	onAttempt AttemptFunc) error {
 	var aggErrors util.Errors
 	for i := 0; i <= repeat; i++ {
-		innerErr := pm.RunWithRetries(ctx, args, stdIn, stdOut, stdErr, cmdDir, retries, retryOnErrors, retryDelay, containerName)
+		repetition := i
+		var repeatOnAttempt AttemptFunc
+		if onAttempt != nil {
+			repeatOnAttempt = func(attempt execution.Attempt) {
+				attempt.Repetition = repetition
+				onAttempt(attempt)
+			}
+		}
+		innerErr := pm.RunFuncWithRetryPolicy(ctx, run, stdOut, stdErr, policy, containerName, repeatOnAttempt)
 		if innerErr != nil {
 			aggErrors = append(aggErrors, innerErr)
 		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
 	if len(aggErrors) > 0 {
 		return errors.New(aggErrors.String())
@@ -62,6 +135,7 @@ func (pm *ProcManager) RunRepeatWithRetries(
 }
 
 // RunWithRetries performs Run with retries.
+// If onAttempt is specified, it's invoked after each attempt.
 func (pm *ProcManager) RunWithRetries(
 	ctx context.Context,
 	args []string,
@@ -72,18 +146,56 @@ func (pm *ProcManager) RunWithRetries(
 	retries int,
 	retryOnErrors []string,
 	retryDelay int,
-	containerName string) error {
+	containerName string,
+	onAttempt AttemptFunc) error {
+	policy := &RetryPolicy{
+		Retries:       retries,
+		RetryOnErrors: retryOnErrors,
+		Delay:         time.Duration(retryDelay) * time.Second,
+	}
+	return pm.RunWithRetryPolicy(ctx, args, stdIn, stdOut, stdErr, cmdDir, policy, containerName, onAttempt)
+}
+
+// RunWithRetryPolicy performs Run, retrying failed attempts according to the policy.
+// If onAttempt is specified, it's invoked after each attempt.
+func (pm *ProcManager) RunWithRetryPolicy(
+	ctx context.Context,
+	args []string,
+	stdIn io.Reader,
+	stdOut io.Writer,
+	stdErr io.Writer,
+	cmdDir string,
+	policy *RetryPolicy,
+	containerName string,
+	onAttempt AttemptFunc) error {
+	return pm.RunFuncWithRetryPolicy(ctx, pm.runFunc(args, stdIn, cmdDir), stdOut, stdErr, policy, containerName, onAttempt)
+}
+
+// RunFuncWithRetryPolicy performs run, retrying failed attempts according to the policy.
+// If onAttempt is specified, it's invoked after each attempt.
+func (pm *ProcManager) RunFuncWithRetryPolicy(
+	ctx context.Context,
+	run RunFunc,
+	stdOut io.Writer,
+	stdErr io.Writer,
+	policy *RetryPolicy,
+	containerName string,
+	onAttempt AttemptFunc) error {
+	stdOut, flushStdOut := pm.redactFile(stdOut)
+	defer flushStdOut()
+	stdErr, flushStdErr := pm.redactFile(stdErr)
+	defer flushStdErr()
+
 	attempt := 0
 	var err error
-	for attempt <= retries {
+	var firstStartTime time.Time
+	for attempt <= policy.Retries {
 		log.Printf("Launching container with name: %s\n", containerName)
 
 		var stdOutBuf, stdErrBuf bytes.Buffer
 		var stdOutWriter, stdErrWriter io.Writer
 
-		needToCheckError := len(retryOnErrors) > 0
-
-		if needToCheckError {
+		if policy.needsOutput() {
 			stdOutWriter = io.MultiWriter(&stdOutBuf, stdOut)
 			stdErrWriter = io.MultiWriter(&stdErrBuf, stdErr)
 		} else {
@@ -91,18 +203,42 @@ func (pm *ProcManager) RunWithRetries(
 			stdErrWriter = stdErr
 		}
 
-		if err = pm.Run(ctx, args, stdIn, stdOutWriter, stdErrWriter, cmdDir); err == nil {
+		startTime := time.Now()
+		if attempt == 0 {
+			firstStartTime = startTime
+		}
+		err = run(ctx, stdOutWriter, stdErrWriter)
+		if onAttempt != nil {
+			attemptResult := execution.Attempt{
+				Retry:     attempt,
+				StartTime: startTime,
+				EndTime:   time.Now(),
+				ExitCode:  ExitCode(err),
+			}
+			if err != nil {
+				attemptResult.Error = err.Error()
+			}
+			onAttempt(attemptResult)
+		}
+		if err == nil {
 			log.Printf("Successfully executed container: %s\n", containerName)
 			break
 		}
 
 		attempt++
-		if attempt <= retries {
-			if !needToCheckError || containsAnyError(retryOnErrors, &stdOutBuf, &stdErrBuf) {
-				log.Printf("Container failed during run: %s, waiting %d seconds before retrying...\n", containerName, retryDelay)
-				time.Sleep(time.Duration(retryDelay) * time.Second)
-				continue
+		if attempt <= policy.Retries && ctx.Err() == nil && policy.shouldRetry(err, &stdOutBuf, &stdErrBuf) {
+			delay := policy.delay(attempt)
+			if policy.MaxRetryTime <= 0 || time.Since(firstStartTime)+delay < policy.MaxRetryTime {
+				log.Printf("Container failed during run: %s, waiting %v before retrying...\n", containerName, delay)
+				select {
+				case <-time.After(delay):
+					continue
+				case <-ctx.Done():
+					return err
+				}
 			}
+			log.Printf("Container failed during run: %s. The retry time budget of %v is exhausted.\n", containerName, policy.MaxRetryTime)
+			break
 		}
 
 		log.Printf("Container failed during run: %s. No retries remaining.\n", containerName)
@@ -111,6 +247,13 @@ func (pm *ProcManager) RunWithRetries(
 	return err
 }
 
+// runFunc returns a RunFunc performing Run with the specified args.
+func (pm *ProcManager) runFunc(args []string, stdIn io.Reader, cmdDir string) RunFunc {
+	return func(ctx context.Context, stdOut io.Writer, stdErr io.Writer) error {
+		return pm.Run(ctx, args, stdIn, stdOut, stdErr, cmdDir)
+	}
+}
+
 // Run runs an exec.Command based on the specified args.
 // stdIn, stdOut, stdErr, and cmdDir can be attached to the created exec.Command.
 func (pm *ProcManager) Run(
@@ -121,7 +264,7 @@ func (pm *ProcManager) Run(
 	stdErr io.Writer,
 	cmdDir string) error {
 	if pm.DryRun {
-		log.Printf("[DRY RUN] Args: %v\n", args)
+		log.Printf("[DRY RUN] Args: %v\n", pm.redactor.RedactArgs(args))
 		return nil
 	}
 
@@ -134,6 +277,11 @@ func (pm *ProcManager) Run(
 		cmd.Dir = cmdDir
 	}
 
+	stdOut, flushStdOut := pm.redactFile(stdOut)
+	defer flushStdOut()
+	stdErr, flushStdErr := pm.redactFile(stdErr)
+	defer flushStdErr()
+
 	cmd.Stdin = stdIn
 	cmd.Stdout = stdOut
 	cmd.Stderr = stdErr
@@ -161,7 +309,7 @@ func (pm *ProcManager) Run(
 	case <-ctx.Done():
 		go func() {
 			if err := cmd.Process.Kill(); err != nil {
-				log.Printf("Failed to kill process. Path: %s, Args: %v, Err: %v\n", cmd.Path, cmd.Args, err)
+				log.Printf("Failed to kill process. Path: %s, Args: %v, Err: %v\n", cmd.Path, pm.redactor.RedactArgs(cmd.Args), err)
 			}
 		}()
 
@@ -193,6 +341,20 @@ func (pm *ProcManager) Stop() util.Errors {
 	return errs
 }
 
+// ExitCode returns the exit code of a process based on the error returned by Run.
+// It returns 0 if err is nil and -1 if the error wasn't caused by the process exiting.
+// Errors of other operations can provide an exit code by implementing an ExitCode() int method, like *exec.ExitError.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
 func containsAnyError(errors []string, stdOutBuf, stdErrBuf *bytes.Buffer) bool {
 	stdOut := stdOutBuf.String()
 	stdErr := stdErrBuf.String()
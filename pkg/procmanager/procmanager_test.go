@@ -6,7 +6,14 @@ package procmanager
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/Azure/acr-builder/pkg/execution"
+	"github.com/Azure/acr-builder/pkg/redact"
 )
 
 func TestDryRun(t *testing.T) {
@@ -62,3 +69,63 @@ func TestContainsAnyError(t *testing.T) {
 		}
 	}
 }
+
+func TestExitCode(t *testing.T) {
+	if actual := ExitCode(nil); actual != 0 {
+		t.Errorf("expected exit code 0 for a nil error but got %d", actual)
+	}
+	if actual := ExitCode(errors.New("not an exit error")); actual != -1 {
+		t.Errorf("expected exit code -1 for a non exit error but got %d", actual)
+	}
+}
+
+func TestRunRepeatWithRetries_RecordsAttempts(t *testing.T) {
+	pm := NewProcManager(true)
+	var attempts []execution.Attempt
+	err := pm.RunRepeatWithRetries(context.Background(), []string{"foo"}, nil, nil, nil, "", 2, nil, 0, "foo", 1, func(attempt execution.Attempt) {
+		attempts = append(attempts, attempt)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts but got %d", len(attempts))
+	}
+	for i, attempt := range attempts {
+		if attempt.Repetition != i || attempt.Retry != 0 || attempt.ExitCode != 0 {
+			t.Errorf("unexpected attempt %d: %+v", i, attempt)
+		}
+	}
+}
+
+func TestRunFuncRedactsFileOutput(t *testing.T) {
+	pm := NewProcManager(false)
+	pm.SetRedactor(redact.NewRedactor("s3cr3t"))
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var captured bytes.Buffer
+	run := func(_ context.Context, stdOut io.Writer, stdErr io.Writer) error {
+		_, _ = io.WriteString(stdOut, "token: s3cr3t\npartial s3cr3t")
+		_, _ = io.WriteString(stdErr, "captured s3cr3t")
+		return nil
+	}
+	if err := pm.RunFuncWithRetryPolicy(context.Background(), run, f, &captured, &RetryPolicy{}, "foo", nil); err != nil {
+		t.Fatalf("Unexpected err: %v", err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "token: ***\npartial ***"; string(data) != expected {
+		t.Errorf("expected the file output to be %q but got %q", expected, string(data))
+	}
+	// Output captured in memory is parsed by the caller, so it isn't redacted.
+	if expected := "captured s3cr3t"; captured.String() != expected {
+		t.Errorf("expected the captured output to be %q but got %q", expected, captured.String())
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package procmanager
+
+import (
+	"bytes"
+	"math/rand"
+	"regexp"
+	"time"
+
+	"github.com/Azure/acr-builder/util"
+)
+
+// RetryPolicy determines which failed attempts are retried, and how long to wait before retrying them.
+type RetryPolicy struct {
+	// Retries is the maximum number of retries after the initial attempt.
+	Retries int
+
+	// RetryOnErrors, RetryOnPatterns and RetryOnExitCodes filter the failed attempts which are retried.
+	// An attempt is retried if its output contains one of the errors, matches one of the patterns,
+	// or if it exited with one of the exit codes. If none are specified, every failed attempt is retried.
+	RetryOnErrors    []string
+	RetryOnPatterns  []*regexp.Regexp
+	RetryOnExitCodes []int
+
+	// Delay is the time to wait before the first retry.
+	Delay time.Duration
+
+	// If Exponential is true, the delay doubles with every retry, up to MaxDelay.
+	Exponential bool
+	MaxDelay    time.Duration
+
+	// Jitter is the fraction, between 0 and 1, by which each delay is randomly reduced.
+	Jitter float64
+
+	// MaxRetryTime is the time after the initial attempt started past which no retry is started.
+	// 0 means there's no limit.
+	MaxRetryTime time.Duration
+}
+
+// needsOutput returns true if the policy needs the output of attempts to decide whether to retry them.
+func (p *RetryPolicy) needsOutput() bool {
+	return len(p.RetryOnErrors) > 0 || len(p.RetryOnPatterns) > 0
+}
+
+// shouldRetry returns true if the failed attempt matches the policy's filters.
+func (p *RetryPolicy) shouldRetry(err error, stdOutBuf, stdErrBuf *bytes.Buffer) bool {
+	if !p.needsOutput() && len(p.RetryOnExitCodes) == 0 {
+		return true
+	}
+	exitCode := ExitCode(err)
+	for _, code := range p.RetryOnExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	if containsAnyError(p.RetryOnErrors, stdOutBuf, stdErrBuf) {
+		return true
+	}
+	for _, pattern := range p.RetryOnPatterns {
+		if pattern.Match(stdOutBuf.Bytes()) || pattern.Match(stdErrBuf.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the time to wait before the specified 1-based retry.
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	delay := p.Delay
+	if p.Exponential {
+		delay = util.GetExponentialBackoffWithBounds(retry-1, p.Delay, p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay)) //#nosec G404
+	}
+	return delay
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package procmanager
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Azure/acr-builder/pkg/execution"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		policy   RetryPolicy
+		retry    int
+		expected time.Duration
+	}{
+		{RetryPolicy{Delay: time.Second}, 1, time.Second},
+		{RetryPolicy{Delay: time.Second}, 4, time.Second},
+		{RetryPolicy{Delay: time.Second, Exponential: true, MaxDelay: time.Minute}, 1, time.Second},
+		{RetryPolicy{Delay: time.Second, Exponential: true, MaxDelay: time.Minute}, 4, 8 * time.Second},
+		{RetryPolicy{Delay: time.Second, Exponential: true, MaxDelay: 5 * time.Second}, 4, 5 * time.Second},
+	}
+	for i, test := range tests {
+		if actual := test.policy.delay(test.retry); actual != test.expected {
+			t.Errorf("test %d: expected %v but got %v", i, test.expected, actual)
+		}
+	}
+
+	policy := RetryPolicy{Delay: time.Second, Jitter: 0.5}
+	for i := 0; i < 10; i++ {
+		if actual := policy.delay(1); actual < 500*time.Millisecond || actual > time.Second {
+			t.Errorf("expected the jittered delay to be between 0.5s and 1s but got %v", actual)
+		}
+	}
+}
+
+func TestRunWithRetryPolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	args := []string{"sh", "-c", "echo 'connection reset'; exit 3"}
+	tests := []struct {
+		name             string
+		policy           *RetryPolicy
+		expectedAttempts int
+	}{
+		{"no filters", &RetryPolicy{Retries: 2}, 3},
+		{"matching exit code", &RetryPolicy{Retries: 2, RetryOnExitCodes: []int{1, 3}}, 3},
+		{"other exit code", &RetryPolicy{Retries: 2, RetryOnExitCodes: []int{1}}, 1},
+		{"matching pattern", &RetryPolicy{Retries: 2, RetryOnPatterns: []*regexp.Regexp{regexp.MustCompile(`connection (reset|refused)`)}}, 3},
+		{"other pattern", &RetryPolicy{Retries: 2, RetryOnPatterns: []*regexp.Regexp{regexp.MustCompile(`timeout`)}}, 1},
+		{"exhausted budget", &RetryPolicy{Retries: 2, Delay: time.Second, MaxRetryTime: 500 * time.Millisecond}, 1},
+	}
+	for _, test := range tests {
+		pm := NewProcManager(false)
+		var attempts []execution.Attempt
+		err := pm.RunWithRetryPolicy(context.Background(), args, nil, io.Discard, io.Discard, "", test.policy, "test", func(attempt execution.Attempt) {
+			attempts = append(attempts, attempt)
+		})
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if len(attempts) != test.expectedAttempts {
+			t.Errorf("%s: expected %d attempts but got %d", test.name, test.expectedAttempts, len(attempts))
+		}
+		for i, attempt := range attempts {
+			if attempt.Retry != i || attempt.ExitCode != 3 {
+				t.Errorf("%s: unexpected attempt %d: %+v", test.name, i, attempt)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package redact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces the secret values in redacted output.
+const Mask = "***"
+
+// minLength is the minimum length of the values masked, along with their encoded forms and lines,
+// since shorter values would mask unrelated output, e.g. every "1" of the output for a secret of 1.
+const minLength = 4
+
+// maxBufferedLength is the length of output a Writer buffers while waiting for the end of a line.
+const maxBufferedLength = 64 * 1024
+
+// Redactor masks known secret values, along with their base64 and URL encoded forms.
+// It's safe for concurrent use, and secrets can be added while it's in use.
+// A nil Redactor doesn't redact anything.
+type Redactor struct {
+	mu       sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+
+	// warnedShort is whether a secret value too short to be masked was added already, which is only logged once.
+	warnedShort bool
+}
+
+// NewRedactor creates a Redactor masking the specified secret values.
+func NewRedactor(values ...string) *Redactor {
+	r := &Redactor{values: make(map[string]struct{})}
+	r.Add(values...)
+	return r
+}
+
+// Add adds secret values to mask. Empty values are ignored, and values shorter than 4 characters
+// aren't masked, which is logged the first time.
+func (r *Redactor) Add(values ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	added := false
+	for _, value := range values {
+		if trimmed := strings.TrimSpace(value); trimmed != "" && len(trimmed) < minLength {
+			if !r.warnedShort {
+				log.Printf("Secret values shorter than %d characters aren't masked in the output\n", minLength)
+				r.warnedShort = true
+			}
+			continue
+		}
+		for _, form := range getForms(value) {
+			if _, ok := r.values[form]; !ok {
+				r.values[form] = struct{}{}
+				added = true
+			}
+		}
+	}
+	if !added {
+		return
+	}
+	forms := make([]string, 0, len(r.values))
+	for form := range r.values {
+		forms = append(forms, form)
+	}
+	// Replace longer values first, in case a secret contains another one.
+	sort.Slice(forms, func(i, j int) bool {
+		if len(forms[i]) != len(forms[j]) {
+			return len(forms[i]) > len(forms[j])
+		}
+		return forms[i] < forms[j]
+	})
+	oldnew := make([]string, 0, 2*len(forms))
+	for _, form := range forms {
+		oldnew = append(oldnew, form, Mask)
+	}
+	r.replacer = strings.NewReplacer(oldnew...)
+}
+
+// getForms returns the forms of the value to mask: the value itself, without surrounding whitespace,
+// and its base64 and URL encodings, unless it's too short. The lines of multi-line values, such as PEM
+// encoded certificates and keys, are masked separately too since output is redacted line by line.
+func getForms(value string) []string {
+	value = strings.TrimSpace(value)
+	if len(value) < minLength {
+		return nil
+	}
+	b := []byte(value)
+	forms := []string{
+		value,
+		base64.StdEncoding.EncodeToString(b),
+		base64.RawStdEncoding.EncodeToString(b),
+		base64.URLEncoding.EncodeToString(b),
+		base64.RawURLEncoding.EncodeToString(b),
+		url.QueryEscape(value),
+		url.PathEscape(value),
+	}
+	if strings.Contains(value, "\n") {
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimSpace(line); len(line) >= minLength {
+				forms = append(forms, line)
+			}
+		}
+	}
+	return forms
+}
+
+// Redact returns s with the secret values masked.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.RLock()
+	replacer := r.replacer
+	r.mu.RUnlock()
+	if replacer == nil {
+		return s
+	}
+	return replacer.Replace(s)
+}
+
+// RedactArgs returns a copy of args with the secret values masked.
+func (r *Redactor) RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = r.Redact(arg)
+	}
+	return redacted
+}
+
+// RedactError returns an error with the message of err with the secret values masked, which unwraps to err.
+func (r *Redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := r.Redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, msg: msg}
+}
+
+// redactedError is an error whose message is redacted.
+type redactedError struct {
+	err error
+	msg string
+}
+
+// Error implements error.
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the original error.
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Writer masks the secret values written to an underlying writer. Secrets can only be masked within a line,
+// so output is buffered until the end of each line. Flush must be called once all the output has been written.
+type Writer struct {
+	mu  sync.Mutex
+	r   *Redactor
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter creates a Writer masking the secret values written to w.
+func (r *Redactor) NewWriter(w io.Writer) *Writer {
+	return &Writer{r: r, w: w}
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	end := bytes.LastIndexAny(w.buf, "\r\n") + 1
+	if end == 0 && len(w.buf) < maxBufferedLength {
+		return len(p), nil
+	}
+	if end == 0 {
+		end = len(w.buf)
+	}
+	if err := w.write(w.buf[:end]); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf[:0], w.buf[end:]...)
+	return len(p), nil
+}
+
+// Flush writes the buffered output which doesn't end with a line ending.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// write writes the redacted output to the underlying writer.
+func (w *Writer) write(p []byte) error {
+	_, err := io.WriteString(w.w, w.r.Redact(string(p)))
+	return err
+}
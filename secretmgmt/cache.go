@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SecretCache caches the values of resolved secrets for a TTL, so that secrets which are used
+// throughout a long run, e.g. registry credentials, can be re-resolved once they expire.
+type SecretCache struct {
+	resolver *SecretResolver
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+
+	// now returns the current time, and is overridden in tests.
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	value        string
+	resolvedTime time.Time
+}
+
+// NewSecretCache creates a cache of the secrets resolved by the resolver, which expire after the TTL.
+// A non-positive TTL never expires secrets.
+func NewSecretCache(resolver *SecretResolver, ttl time.Duration) *SecretCache {
+	return &SecretCache{
+		resolver: resolver,
+		ttl:      ttl,
+		entries:  make(map[string]cacheEntry),
+		now:      time.Now,
+	}
+}
+
+// NewCredentialCache creates the cache registry credentials are re-resolved through once they're older than the TTL,
+// resolving at most concurrency secrets at once. It returns nil if the TTL isn't positive, since they're never refreshed.
+func NewCredentialCache(ttl time.Duration, concurrency int) (*SecretCache, error) {
+	if ttl <= 0 {
+		return nil, nil
+	}
+	resolver, err := NewSecretResolver(nil, DefaultSecretResolveTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create secret resolver")
+	}
+	resolver.SetConcurrency(concurrency)
+	return NewSecretCache(resolver, ttl), nil
+}
+
+// TTL returns how long resolved secrets are cached for.
+func (c *SecretCache) TTL() time.Duration {
+	return c.ttl
+}
+
+// Expired returns true if the secret resolves from a vault and was resolved longer than the TTL ago, or never.
+// Secrets whose value is provided directly never expire.
+func (c *SecretCache) Expired(secret *Secret) bool {
+	if secret == nil || secret.ProviderName() == "" {
+		return false
+	}
+	if secret.ResolvedTime.IsZero() {
+		return true
+	}
+	return c.ttl > 0 && c.now().Sub(secret.ResolvedTime) >= c.ttl
+}
+
+// Resolve sets the values of the secrets from the cache, resolving the ones which aren't cached or expired.
+func (c *SecretCache) Resolve(ctx context.Context, secrets []*Secret) error {
+	var unresolved []*Secret
+	c.mu.Lock()
+	for _, secret := range secrets {
+		if secret == nil || secret.ProviderName() == "" {
+			continue
+		}
+		entry, ok := c.entries[getCacheKey(secret)]
+		if ok && (c.ttl <= 0 || c.now().Sub(entry.resolvedTime) < c.ttl) {
+			secret.ResolvedValue = entry.value
+			secret.ResolvedTime = entry.resolvedTime
+			continue
+		}
+		unresolved = append(unresolved, secret)
+	}
+	c.mu.Unlock()
+
+	if err := c.resolver.ResolveSecrets(ctx, unresolved); err != nil {
+		return err
+	}
+
+	resolvedTime := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, secret := range unresolved {
+		secret.ResolvedTime = resolvedTime
+		c.entries[getCacheKey(secret)] = cacheEntry{value: secret.ResolvedValue, resolvedTime: resolvedTime}
+	}
+	return nil
+}
+
+// Refresh resolves the secrets again, regardless of whether they're cached, e.g. before using rotated credentials.
+func (c *SecretCache) Refresh(ctx context.Context, secrets []*Secret) error {
+	c.mu.Lock()
+	for _, secret := range secrets {
+		if secret != nil {
+			delete(c.entries, getCacheKey(secret))
+		}
+	}
+	c.mu.Unlock()
+	return c.Resolve(ctx, secrets)
+}
+
+// getCacheKey returns the key of the secret in the cache, which identifies where it's resolved from,
+// so that secrets resolved from the same location share their entry.
+func getCacheKey(secret *Secret) string {
+	return strings.Join([]string{
+		secret.ProviderName(),
+		secret.KeyVault,
+		secret.MsiClientID,
+		secret.AadResourceID,
+		secret.Path,
+		secret.Key,
+		secret.Format,
+	}, "\x00")
+}
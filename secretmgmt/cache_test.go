@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingResolver resolves secrets to their ID followed by the number of times they were resolved.
+type countingResolver struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (r *countingResolver) resolve(_ context.Context, secret *Secret, _ chan error) {
+	r.mu.Lock()
+	r.counts[secret.ID]++
+	secret.ResolvedValue = fmt.Sprintf("%s-%d", secret.ID, r.counts[secret.ID])
+	r.mu.Unlock()
+	secret.ResolvedChan <- true
+}
+
+func TestSecretCache(t *testing.T) {
+	counter := &countingResolver{counts: map[string]int{}}
+	resolver, err := NewSecretResolver(counter.resolve, DefaultSecretResolveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cache := NewSecretCache(resolver, time.Hour)
+	cache.now = func() time.Time { return now }
+
+	token := &Secret{ID: "token", AadResourceID: "https://management.azure.com/"}
+	opaque := &Secret{ID: "opaque", ResolvedValue: "value"}
+	if !cache.Expired(token) || cache.Expired(opaque) {
+		t.Fatal("expected only the unresolved vault secret to be expired")
+	}
+	if err := cache.Resolve(context.Background(), []*Secret{token, opaque}); err != nil {
+		t.Fatalf("failed to resolve secrets: %v", err)
+	}
+	if token.ResolvedValue != "token-1" || opaque.ResolvedValue != "value" {
+		t.Fatalf("unexpected values: %s, %s", token.ResolvedValue, opaque.ResolvedValue)
+	}
+
+	// Secrets resolved from the same location share their cache entry.
+	other := &Secret{ID: "token", AadResourceID: "https://management.azure.com/"}
+	if err := cache.Resolve(context.Background(), []*Secret{other}); err != nil {
+		t.Fatal(err)
+	}
+	if other.ResolvedValue != "token-1" {
+		t.Errorf("expected the cached value but got %s", other.ResolvedValue)
+	}
+
+	now = now.Add(time.Hour)
+	if !cache.Expired(token) {
+		t.Fatal("expected the secret to expire after the TTL")
+	}
+	if err := cache.Resolve(context.Background(), []*Secret{token}); err != nil {
+		t.Fatal(err)
+	}
+	if token.ResolvedValue != "token-2" {
+		t.Errorf("expected the expired secret to be resolved again, got %s", token.ResolvedValue)
+	}
+
+	// Refreshing resolves secrets again even if they didn't expire.
+	if err := cache.Refresh(context.Background(), []*Secret{token}); err != nil {
+		t.Fatal(err)
+	}
+	if token.ResolvedValue != "token-3" {
+		t.Errorf("expected the refreshed secret to be resolved again, got %s", token.ResolvedValue)
+	}
+}
+
+func TestSecretCacheWithoutTTL(t *testing.T) {
+	cache := NewSecretCache(nil, 0)
+	secret := &Secret{ID: "a", KeyVault: "b", ResolvedValue: "c", ResolvedTime: time.Now().Add(-24 * time.Hour)}
+	if cache.Expired(secret) {
+		t.Error("expected resolved secrets never to expire without a TTL")
+	}
+}
+
+func TestNewCredentialCache(t *testing.T) {
+	tests := []struct {
+		ttl                 time.Duration
+		concurrency         int
+		expectedConcurrency int
+	}{
+		{0, 10, 0},
+		{-time.Second, 10, 0},
+		{time.Hour, 10, 10},
+		{time.Hour, 0, DefaultSecretResolveConcurrency},
+	}
+	for _, test := range tests {
+		cache, err := NewCredentialCache(test.ttl, test.concurrency)
+		if err != nil {
+			t.Fatalf("failed to create the cache with TTL %v: %v", test.ttl, err)
+		}
+		if test.expectedConcurrency == 0 {
+			if cache != nil {
+				t.Errorf("expected no cache with TTL %v", test.ttl)
+			}
+			continue
+		}
+		if cache == nil {
+			t.Fatalf("expected a cache with TTL %v", test.ttl)
+		}
+		if cache.TTL() != test.ttl {
+			t.Errorf("expected TTL %v but got %v", test.ttl, cache.TTL())
+		}
+		if cache.resolver.concurrency != test.expectedConcurrency {
+			t.Errorf("expected concurrency %d but got %d", test.expectedConcurrency, cache.resolver.concurrency)
+		}
+	}
+}
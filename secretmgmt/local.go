@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LocalSecrets maps secret IDs to their values, for running tasks locally without access to their vaults.
+type LocalSecrets map[string]string
+
+// LoadLocalSecretsFile loads the secrets of a YAML file mapping secret IDs to their values.
+func LoadLocalSecretsFile(path string) (LocalSecrets, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the secrets file")
+	}
+	secrets := LocalSecrets{}
+	if err := yaml.UnmarshalStrict(b, &secrets); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the secrets file, expected a mapping of secret IDs to values")
+	}
+	return secrets, nil
+}
+
+// ParseLocalSecrets parses secrets in the id=value format.
+func ParseLocalSecrets(pairs []string) (LocalSecrets, error) {
+	secrets := LocalSecrets{}
+	for _, pair := range pairs {
+		id, value, ok := strings.Cut(pair, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid secret %q, expected id=value", pair)
+		}
+		secrets[id] = value
+	}
+	return secrets, nil
+}
+
+// Merge returns the secrets overridden by the other secrets.
+func (s LocalSecrets) Merge(other LocalSecrets) LocalSecrets {
+	merged := LocalSecrets{}
+	for id, value := range s {
+		merged[id] = value
+	}
+	for id, value := range other {
+		merged[id] = value
+	}
+	return merged
+}
+
+// ResolveSecretFunc returns a ResolveSecretFunc resolving secrets to their local values instead of resolving
+// them from their vaults. Secrets without a local value fail to resolve.
+func (s LocalSecrets) ResolveSecretFunc() ResolveSecretFunc {
+	return func(_ context.Context, secret *Secret, errorChan chan error) {
+		if secret == nil {
+			errorChan <- errors.New("secret cannot be nil")
+			return
+		}
+		value, ok := s[secret.ID]
+		if !ok {
+			errorChan <- fmt.Errorf("no local value was provided for secret %s", secret.ID)
+			return
+		}
+		secret.ResolvedValue = value
+		secret.ResolvedChan <- true
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLocalSecrets(t *testing.T) {
+	tests := []struct {
+		pairs       []string
+		shouldError bool
+		expected    LocalSecrets
+	}{
+		{nil, false, LocalSecrets{}},
+		{[]string{"a=b", "c=d=e", "empty="}, false, LocalSecrets{"a": "b", "c": "d=e", "empty": ""}},
+		{[]string{"a"}, true, nil},
+		{[]string{"=b"}, true, nil},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseLocalSecrets(test.pairs)
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("expected %v to error but it didn't", test.pairs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error parsing %v: %v", test.pairs, err)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected %v but got %v", test.expected, actual)
+		}
+	}
+}
+
+func TestLoadLocalSecretsFile(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "secrets.yaml")
+	if err := os.WriteFile(valid, []byte("username: admin\npassword: \"p@ss: word\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("- a\n- b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := LoadLocalSecretsFile(valid)
+	if err != nil {
+		t.Fatalf("failed to load the secrets file: %v", err)
+	}
+	expected := LocalSecrets{"username": "admin", "password": "p@ss: word"}
+	if !reflect.DeepEqual(secrets, expected) {
+		t.Errorf("expected %v but got %v", expected, secrets)
+	}
+
+	if _, err := LoadLocalSecretsFile(invalid); err == nil {
+		t.Error("expected an error loading a file which isn't a mapping")
+	}
+	if _, err := LoadLocalSecretsFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("expected an error loading a missing file")
+	}
+}
+
+func TestLocalSecretsResolveSecretFunc(t *testing.T) {
+	local := LocalSecrets{"a": "file"}.Merge(LocalSecrets{"a": "flag", "b": "other"})
+	resolver, err := NewSecretResolver(local.ResolveSecretFunc(), DefaultSecretResolveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Local values are used regardless of the secrets' vaults.
+	secrets := []*Secret{
+		{ID: "a", KeyVault: "https://myvault.vault.azure.net/secrets/a"},
+		{ID: "b", AadResourceID: "https://management.azure.com/"},
+	}
+	if err := resolver.ResolveSecrets(context.Background(), secrets); err != nil {
+		t.Fatalf("failed to resolve secrets: %v", err)
+	}
+	if secrets[0].ResolvedValue != "flag" || secrets[1].ResolvedValue != "other" {
+		t.Errorf("unexpected resolved values: %s, %s", secrets[0].ResolvedValue, secrets[1].ResolvedValue)
+	}
+
+	if err := resolver.ResolveSecrets(context.Background(), []*Secret{{ID: "missing", KeyVault: "b"}}); err == nil {
+		t.Error("expected an error resolving a secret without a local value")
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/acr-builder/tokenutil"
+	"github.com/Azure/acr-builder/vaults"
+	"github.com/pkg/errors"
+)
+
+// The names of the built-in vault providers.
+const (
+	// AzureKeyVaultProvider resolves secrets from Azure Key Vault using MSI.
+	AzureKeyVaultProvider = "akv"
+
+	// MsiProvider resolves secrets to registry refresh tokens of a managed identity.
+	MsiProvider = "msi"
+
+	// HashiCorpVaultProvider resolves secrets from a HashiCorp Vault KV version 2 secrets engine.
+	HashiCorpVaultProvider = "hashicorp"
+
+	// FileProvider resolves secrets from local files, for local development.
+	FileProvider = "file"
+
+	// EnvProvider resolves secrets from environment variables, for local development.
+	EnvProvider = "env"
+)
+
+// VaultProvider resolves secrets stored in a vault.
+type VaultProvider interface {
+	// Validate returns an error if the secret is missing properties the provider requires.
+	Validate(secret *Secret) error
+
+	// GetValue returns the value of the secret.
+	GetValue(ctx context.Context, secret *Secret) (string, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]VaultProvider{
+		AzureKeyVaultProvider:  &akvProvider{},
+		MsiProvider:            &msiProvider{},
+		HashiCorpVaultProvider: &hashiCorpProvider{},
+		FileProvider:           &fileProvider{},
+		EnvProvider:            &envProvider{},
+	}
+)
+
+// RegisterVaultProvider registers a provider under the specified name, replacing any provider already registered with it.
+func RegisterVaultProvider(name string, provider VaultProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = provider
+}
+
+// GetVaultProvider returns the provider registered with the specified name.
+func GetVaultProvider(name string) (VaultProvider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	if provider, ok := providers[name]; ok {
+		return provider, nil
+	}
+	names := make([]string, 0, len(providers))
+	for n := range providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown secret provider: %s, must be one of: %s", name, strings.Join(names, ", "))
+}
+
+// akvProvider resolves secrets, certificates and keys from Azure Key Vault, identified by their keyvault URL.
+type akvProvider struct{}
+
+// Validate implements VaultProvider.
+func (p *akvProvider) Validate(secret *Secret) error {
+	if secret.KeyVault == "" {
+		return errors.New("keyvault is required for Azure Key Vault secrets")
+	}
+	if secret.Format == "" {
+		return nil
+	}
+	secretConfig, err := vaults.NewAKVSecretConfig(secret.KeyVault, secret.MsiClientID)
+	if err != nil {
+		return err
+	}
+	return secretConfig.SetFormat(secret.Format)
+}
+
+// GetValue implements VaultProvider.
+func (p *akvProvider) GetValue(ctx context.Context, secret *Secret) (string, error) {
+	secretConfig, err := vaults.NewAKVSecretConfig(secret.KeyVault, secret.MsiClientID)
+	if err != nil {
+		return "", err
+	}
+	if err := secretConfig.SetFormat(secret.Format); err != nil {
+		return "", err
+	}
+	return secretConfig.GetValue(ctx)
+}
+
+// msiProvider resolves secrets to the registry refresh token of a managed identity.
+type msiProvider struct{}
+
+// Validate implements VaultProvider.
+func (p *msiProvider) Validate(secret *Secret) error {
+	if secret.AadResourceID == "" {
+		return errors.New("an AAD resource ID is required for MSI secrets")
+	}
+	return nil
+}
+
+// GetValue implements VaultProvider.
+func (p *msiProvider) GetValue(_ context.Context, secret *Secret) (string, error) {
+	return tokenutil.GetRegistryRefreshToken(secret.ID, secret.AadResourceID, secret.MsiClientID)
+}
+
+// hashiCorpProvider resolves secrets from HashiCorp Vault, identified by their path and key.
+type hashiCorpProvider struct{}
+
+// Validate implements VaultProvider.
+func (p *hashiCorpProvider) Validate(secret *Secret) error {
+	if secret.Path == "" {
+		return errors.New("path is required for HashiCorp Vault secrets")
+	}
+	return nil
+}
+
+// GetValue implements VaultProvider.
+func (p *hashiCorpProvider) GetValue(ctx context.Context, secret *Secret) (string, error) {
+	secretConfig, err := vaults.NewHashiCorpKVSecretConfig(secret.Path, secret.Key)
+	if err != nil {
+		return "", err
+	}
+	return secretConfig.GetValue(ctx)
+}
+
+// fileProvider resolves secrets from the file at their path.
+type fileProvider struct{}
+
+// Validate implements VaultProvider.
+func (p *fileProvider) Validate(secret *Secret) error {
+	if secret.Path == "" {
+		return errors.New("path is required for file secrets")
+	}
+	return nil
+}
+
+// GetValue implements VaultProvider.
+func (p *fileProvider) GetValue(ctx context.Context, secret *Secret) (string, error) {
+	return (&vaults.FileSecretConfig{Path: secret.Path}).GetValue(ctx)
+}
+
+// envProvider resolves secrets from the environment variable named by their path.
+type envProvider struct{}
+
+// Validate implements VaultProvider.
+func (p *envProvider) Validate(secret *Secret) error {
+	if secret.Path == "" {
+		return errors.New("path is required for environment variable secrets")
+	}
+	return nil
+}
+
+// GetValue implements VaultProvider.
+func (p *envProvider) GetValue(ctx context.Context, secret *Secret) (string, error) {
+	return (&vaults.EnvSecretConfig{Name: secret.Path}).GetValue(ctx)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package secretmgmt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type staticProvider struct {
+	values map[string]string
+}
+
+func (p *staticProvider) Validate(secret *Secret) error {
+	if secret.Path == "" {
+		return errors.New("path is required")
+	}
+	return nil
+}
+
+func (p *staticProvider) GetValue(_ context.Context, secret *Secret) (string, error) {
+	if value, ok := p.values[secret.Path]; ok {
+		return value, nil
+	}
+	return "", errors.Errorf("no value for %s", secret.Path)
+}
+
+func TestProviderName(t *testing.T) {
+	tests := []struct {
+		secret   *Secret
+		expected string
+	}{
+		{nil, ""},
+		{&Secret{ID: "a"}, ""},
+		{&Secret{ID: "a", KeyVault: "b"}, AzureKeyVaultProvider},
+		{&Secret{ID: "a", AadResourceID: "b"}, MsiProvider},
+		{&Secret{ID: "a", KeyVault: "b", Provider: FileProvider}, FileProvider},
+	}
+
+	for _, test := range tests {
+		if actual := test.secret.ProviderName(); actual != test.expected {
+			t.Errorf("expected provider %q for secret %v, got %q", test.expected, test.secret, actual)
+		}
+	}
+}
+
+func TestGetVaultProvider(t *testing.T) {
+	for _, name := range []string{AzureKeyVaultProvider, MsiProvider, HashiCorpVaultProvider, FileProvider, EnvProvider} {
+		if _, err := GetVaultProvider(name); err != nil {
+			t.Errorf("expected the built-in provider %s to be registered, got err: %v", name, err)
+		}
+	}
+	if _, err := GetVaultProvider("unknown"); err == nil {
+		t.Error("expected an error getting an unknown provider")
+	}
+}
+
+func TestResolveSecretsWithRegisteredProvider(t *testing.T) {
+	RegisterVaultProvider("static", &staticProvider{values: map[string]string{"a": "foo", "b": "bar"}})
+
+	secrets := []*Secret{
+		{ID: "first", Provider: "static", Path: "a"},
+		{ID: "second", Provider: "static", Path: "b"},
+	}
+	for _, secret := range secrets {
+		if err := secret.Validate(); err != nil {
+			t.Fatalf("unexpected validation error: %v", err)
+		}
+	}
+
+	resolver, err := NewSecretResolver(nil, DefaultSecretResolveTimeout)
+	if err != nil {
+		t.Fatalf("failed to create the secret resolver: %v", err)
+	}
+	if err := resolver.ResolveSecrets(context.Background(), secrets); err != nil {
+		t.Fatalf("failed to resolve secrets: %v", err)
+	}
+	if secrets[0].ResolvedValue != "foo" || secrets[1].ResolvedValue != "bar" {
+		t.Errorf("unexpected resolved values: %s, %s", secrets[0].ResolvedValue, secrets[1].ResolvedValue)
+	}
+
+	if err := resolver.ResolveSecrets(context.Background(), []*Secret{{ID: "missing", Provider: "static", Path: "c"}}); err == nil {
+		t.Error("expected an error resolving a secret the provider doesn't have")
+	}
+}
+
+func TestLocalProviders(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(file, []byte("file-value\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ACB_TEST_SECRET", "env-value")
+
+	tests := []struct {
+		secret   *Secret
+		expected string
+	}{
+		{&Secret{ID: "a", Provider: FileProvider, Path: file}, "file-value"},
+		{&Secret{ID: "b", Provider: EnvProvider, Path: "ACB_TEST_SECRET"}, "env-value"},
+	}
+
+	for _, test := range tests {
+		provider, err := GetVaultProvider(test.secret.Provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := provider.GetValue(context.Background(), test.secret)
+		if err != nil {
+			t.Fatalf("failed to get the value of secret %s: %v", test.secret.ID, err)
+		}
+		if actual != test.expected {
+			t.Errorf("expected %s but got %s", test.expected, actual)
+		}
+	}
+
+	provider, _ := GetVaultProvider(EnvProvider)
+	if _, err := provider.GetValue(context.Background(), &Secret{ID: "c", Provider: EnvProvider, Path: "ACB_TEST_UNSET_SECRET"}); err == nil {
+		t.Error("expected an error for an unset environment variable")
+	}
+}
@@ -4,13 +4,15 @@
 package secretmgmt
 
 import (
+	"time"
+
 	"github.com/Azure/acr-builder/util"
 	"github.com/pkg/errors"
 )
 
 var (
 	errMissingSecretIDs      = errors.New("secret is missing an ID as well as auto-generated ID")
-	errMissingSecretProps    = errors.New("secret should contain either keyvault property for vault secret, or msi clientID/aadResourceId for msi authentication")
+	errMissingSecretProps    = errors.New("secret should contain either a provider, keyvault property for vault secret, or msi clientID/aadResourceId for msi authentication")
 	errSecretIDContainsSpace = errors.New("secret ID cannot contain spaces")
 	errInvalidUUID           = errors.New("msi client ID is not a valid guid")
 )
@@ -21,9 +23,26 @@ type Secret struct {
 	KeyVault    string `yaml:"keyvault,omitempty"`
 	MsiClientID string `yaml:"clientID,omitempty"`
 
+	// Provider is the name of the VaultProvider resolving the secret. If it's empty, keyvault secrets
+	// are resolved from Azure Key Vault and the others with MSI.
+	Provider string `yaml:"provider,omitempty"`
+
+	// Path locates the secret in the provider's store, e.g. a HashiCorp Vault path, a file or an environment variable.
+	Path string `yaml:"path,omitempty"`
+
+	// Key is the key of the secret's data to use, for providers storing several values per secret.
+	Key string `yaml:"key,omitempty"`
+
+	// Format is the format of Azure Key Vault certificates and keys, e.g. pem, pfx or cer for certificates.
+	// If it's empty, certificates and keys are resolved to PEM.
+	Format string `yaml:"format,omitempty"`
+
 	// After the Secret is resolved, the value can be found here.
 	ResolvedValue string
 
+	// ResolvedTime is when the Secret was last resolved, which determines when it expires in a SecretCache.
+	ResolvedTime time.Time `yaml:"-"`
+
 	// AadResourceID is used to fetch ARM token from a TokenServer for an identity
 	AadResourceID string
 
@@ -48,14 +67,35 @@ func (s *Secret) Validate() error {
 	if util.ContainsSpace(s.ID) {
 		return errSecretIDContainsSpace
 	}
-	if !s.IsKeyVaultSecret() && !s.IsMsiSecret() {
-		return errMissingSecretProps
-	}
 	if s.MsiClientID != "" && !util.IsValidUUID(s.MsiClientID) {
 		return errInvalidUUID
 	}
+	providerName := s.ProviderName()
+	if providerName == "" {
+		return errMissingSecretProps
+	}
+	provider, err := GetVaultProvider(providerName)
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(provider.Validate(s), "invalid secret %s", s.ID)
+}
 
-	return nil
+// ProviderName returns the name of the VaultProvider resolving the secret, or an empty string
+// if it has none and isn't a key vault or MSI secret either.
+func (s *Secret) ProviderName() string {
+	switch {
+	case s == nil:
+		return ""
+	case s.Provider != "":
+		return s.Provider
+	case s.IsKeyVaultSecret():
+		return AzureKeyVaultProvider
+	case s.IsMsiSecret():
+		return MsiProvider
+	default:
+		return ""
+	}
 }
 
 // IsKeyVaultSecret returns true if a Secret is a key vault, false otherwise.
@@ -86,5 +126,9 @@ func (s *Secret) Equals(t *Secret) bool {
 	return s.ID == t.ID &&
 		s.KeyVault == t.KeyVault &&
 		s.MsiClientID == t.MsiClientID &&
-		s.AadResourceID == t.AadResourceID
+		s.AadResourceID == t.AadResourceID &&
+		s.Provider == t.Provider &&
+		s.Path == t.Path &&
+		s.Key == t.Key &&
+		s.Format == t.Format
 }
@@ -57,6 +57,66 @@ func TestValidateSecret(t *testing.T) {
 			},
 			false,
 		},
+		{
+			&Secret{
+				ID:       "a",
+				KeyVault: "https://myvault.vault.azure.net/certificates/mycert",
+				Format:   "pfx",
+			},
+			false,
+		},
+		{
+			// Secrets have no format.
+			&Secret{
+				ID:       "a",
+				KeyVault: "https://myvault.vault.azure.net/secrets/mysecret",
+				Format:   "pem",
+			},
+			true,
+		},
+		{
+			// Keys can't be resolved to PFX.
+			&Secret{
+				ID:       "a",
+				KeyVault: "https://myvault.vault.azure.net/keys/mykey",
+				Format:   "pfx",
+			},
+			true,
+		},
+		{
+			&Secret{
+				ID:       "a",
+				Provider: "hashicorp",
+				Path:     "secret/myapp",
+				Key:      "password",
+			},
+			false,
+		},
+		{
+			// The HashiCorp Vault provider requires a path.
+			&Secret{
+				ID:       "a",
+				Provider: "hashicorp",
+			},
+			true,
+		},
+		{
+			&Secret{
+				ID:       "a",
+				Provider: "env",
+				Path:     "MY_SECRET",
+			},
+			false,
+		},
+		{
+			// Unknown provider
+			&Secret{
+				ID:       "a",
+				Provider: "unknown",
+				Path:     "b",
+			},
+			true,
+		},
 	}
 
 	for _, test := range tests {
@@ -169,6 +229,19 @@ func TestSecretEquals(t *testing.T) {
 			},
 			true,
 		},
+		{
+			&Secret{
+				ID:       "a",
+				KeyVault: "b",
+				Format:   "pem",
+			},
+			&Secret{
+				ID:       "a",
+				KeyVault: "b",
+				Format:   "pfx",
+			},
+			false,
+		},
 	}
 
 	for _, test := range tests {
@@ -8,20 +8,17 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/Azure/acr-builder/tokenutil"
-	"github.com/Azure/acr-builder/vaults"
 	"github.com/pkg/errors"
 )
 
 const (
 	// DefaultSecretResolveTimeout is the default timeout for resolving a secret which is 2 minute
 	DefaultSecretResolveTimeout time.Duration = time.Minute * 2
-)
 
-type secretResolveChannel struct {
-	resolvedChan chan bool
-	timeoutChan  func() <-chan struct{}
-}
+	// DefaultSecretResolveConcurrency is the default number of secrets resolved at once,
+	// kept low to avoid throttling errors on the vault providers.
+	DefaultSecretResolveConcurrency = 5
+)
 
 // ResolveSecretFunc is a function that resolves the secret to its value and sends through the ResolvedChan of the secret. Any errors during resolve are send through errorChan
 type ResolveSecretFunc func(ctx context.Context, secret *Secret, errorChan chan error)
@@ -30,6 +27,7 @@ type ResolveSecretFunc func(ctx context.Context, secret *Secret, errorChan chan
 type SecretResolver struct {
 	Resolve        ResolveSecretFunc
 	resolveTimeout time.Duration
+	concurrency    int
 }
 
 // NewSecretResolver creates a resolver with the given resolve function.
@@ -38,7 +36,15 @@ func NewSecretResolver(resolveFunc ResolveSecretFunc, resolveTimeout time.Durati
 		resolveFunc = resolveSecret
 	}
 
-	return &SecretResolver{Resolve: resolveFunc, resolveTimeout: resolveTimeout}, nil
+	return &SecretResolver{Resolve: resolveFunc, resolveTimeout: resolveTimeout, concurrency: DefaultSecretResolveConcurrency}, nil
+}
+
+// SetConcurrency sets the maximum number of secrets resolved at once. A non-positive value uses the default.
+func (secretResolver *SecretResolver) SetConcurrency(concurrency int) {
+	if concurrency <= 0 {
+		concurrency = DefaultSecretResolveConcurrency
+	}
+	secretResolver.concurrency = concurrency
 }
 
 // ResolveSecrets resolves all the Secrets, or returns an error if there is any failure in resolving a secret.
@@ -46,85 +52,92 @@ func (secretResolver *SecretResolver) ResolveSecrets(ctx context.Context, secret
 	if len(secrets) == 0 {
 		return nil
 	}
-	// We will resolve in batches of 5 to avoid throttling errors on the vault providers
-	batchSize := 5
-	errorChan := make(chan error)
-	for index := 0; index < len(secrets); index += batchSize {
-		endIndex := index + batchSize
-
-		if endIndex > len(secrets) {
-			endIndex = len(secrets)
-		}
-
-		var secretChannels []secretResolveChannel
-
-		for _, secret := range secrets[index:endIndex] {
-			if secret == nil {
-				continue
-			}
+	concurrency := secretResolver.concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultSecretResolveConcurrency
+	}
 
-			if secret.ResolvedChan == nil {
-				secret.ResolvedChan = make(chan bool)
-			}
-			ctxWithTimeout, cancel := context.WithTimeout(ctx, secretResolver.resolveTimeout)
-			defer cancel()
-			secretChannels = append(secretChannels, secretResolveChannel{secret.ResolvedChan, ctxWithTimeout.Done})
-			go secretResolver.Resolve(ctxWithTimeout, secret, errorChan)
+	// Stop resolving the remaining secrets once any of them fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, concurrency)
+	results := make(chan error, len(secrets))
+	count := 0
+	for _, secret := range secrets {
+		if secret == nil {
+			continue
 		}
-
-		// Block until either:
-		// - timeout in fetching any of the secrets.
-		// - The global context expires
-		// - Resolving a secret has error
-		// - All secrets are resolved successfully
-		for _, ch := range secretChannels {
+		if secret.ResolvedChan == nil {
+			secret.ResolvedChan = make(chan bool, 1)
+		}
+		count++
+		go func(secret *Secret) {
 			select {
-			case <-ch.timeoutChan():
-				return errors.New("timeout in fetching secrets. please check permissions are valid")
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
 			case <-ctx.Done():
-				return ctx.Err()
-			case <-ch.resolvedChan:
-			case err := <-errorChan:
-				return err
+				results <- ctx.Err()
+				return
 			}
-		}
+			results <- secretResolver.resolve(ctx, secret)
+		}(secret)
 	}
 
+	for i := 0; i < count; i++ {
+		if err := <-results; err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// resolve resolves the secret, blocking until either:
+// - The secret is resolved successfully
+// - Resolving the secret has error
+// - Resolving the secret times out
+// - The global context expires
+func (secretResolver *SecretResolver) resolve(ctx context.Context, secret *Secret) error {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, secretResolver.resolveTimeout)
+	defer cancel()
+	errorChan := make(chan error, 1)
+	go secretResolver.Resolve(ctxWithTimeout, secret, errorChan)
+
+	select {
+	case <-secret.ResolvedChan:
+		secret.ResolvedTime = time.Now()
+		return nil
+	case err := <-errorChan:
+		return err
+	case <-ctxWithTimeout.Done():
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.New("timeout in fetching secrets. please check permissions are valid")
+	}
+}
+
 func resolveSecret(ctx context.Context, secret *Secret, errorChan chan error) {
 	if secret == nil {
 		errorChan <- errors.New("secret cannot be nil")
 		return
 	}
 
-	if secret.IsKeyVaultSecret() {
-		secretConfig, err := vaults.NewAKVSecretConfig(secret.KeyVault, secret.MsiClientID)
-		if err != nil {
-			errorChan <- err
-			return
-		}
-
-		secretValue, err := secretConfig.GetValue(ctx)
-		if err != nil {
-			errorChan <- err
-			return
-		}
-
-		secret.ResolvedValue = secretValue
-		secret.ResolvedChan <- true
+	providerName := secret.ProviderName()
+	if providerName == "" {
+		errorChan <- fmt.Errorf("cannot resolve secret with ID: %s", secret.ID)
 		return
-	} else if secret.IsMsiSecret() {
-		secretValue, err := tokenutil.GetRegistryRefreshToken(secret.ID, secret.AadResourceID, secret.MsiClientID)
-		if err != nil {
-			errorChan <- err
-			return
-		}
-		secret.ResolvedValue = secretValue
-		secret.ResolvedChan <- true
+	}
+	provider, err := GetVaultProvider(providerName)
+	if err != nil {
+		errorChan <- err
 		return
 	}
 
-	errorChan <- fmt.Errorf("cannot resolve secret with ID: %s", secret.ID)
+	secretValue, err := provider.GetValue(ctx, secret)
+	if err != nil {
+		errorChan <- err
+		return
+	}
+	secret.ResolvedValue = secretValue
+	secret.ResolvedChan <- true
 }
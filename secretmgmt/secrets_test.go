@@ -6,6 +6,7 @@ package secretmgmt
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -214,3 +215,47 @@ func TestResolveSecretsWithTimeout(t *testing.T) {
 		t.Fatalf("Expected test to error but it didn't")
 	}
 }
+
+// TestResolveSecretsConcurrency tests that no more secrets than the resolver's concurrency are resolved at once.
+func TestResolveSecretsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	resolve := func(_ context.Context, secret *Secret, _ chan error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		secret.ResolvedValue = secret.ID
+		secret.ResolvedChan <- true
+	}
+
+	for _, concurrency := range []int{1, 3} {
+		maxRunning = 0
+		secretResolver, err := NewSecretResolver(resolve, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secretResolver.SetConcurrency(concurrency)
+		var secrets []*Secret
+		for i := 0; i < 8; i++ {
+			secrets = append(secrets, &Secret{ID: fmt.Sprint(i), KeyVault: "k"})
+		}
+		if err := secretResolver.ResolveSecrets(context.Background(), secrets); err != nil {
+			t.Fatalf("failed to resolve secrets: %v", err)
+		}
+		if maxRunning > concurrency {
+			t.Errorf("expected at most %d secrets resolved at once but got %d", concurrency, maxRunning)
+		}
+		for _, secret := range secrets {
+			if secret.ResolvedValue != secret.ID || secret.ResolvedTime.IsZero() {
+				t.Errorf("expected secret %s to be resolved, got %+v", secret.ID, secret)
+			}
+		}
+	}
+}
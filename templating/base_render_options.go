@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/Azure/acr-builder/graph"
+	"github.com/Azure/acr-builder/pkg/redact"
 	"github.com/Azure/acr-builder/secretmgmt"
 	"github.com/pkg/errors"
 )
@@ -75,8 +76,20 @@ type BaseRenderOptions struct {
 	// SecretResolveTimeout is the timeout for resolving a secret during rendering.
 	SecretResolveTimeout time.Duration
 
+	// SecretResolveConcurrency is the maximum number of secrets resolved at once during rendering.
+	// If unspecified, secretmgmt.DefaultSecretResolveConcurrency is used.
+	SecretResolveConcurrency int
+
 	// TaskName is the name of the Task executing this run
 	TaskName string
+
+	// ResolveSecretFunc overrides how secrets are resolved during rendering.
+	// If unspecified, secrets are resolved using their vault providers.
+	ResolveSecretFunc secretmgmt.ResolveSecretFunc
+
+	// Redactor, if specified, is given the values of the secrets resolved during rendering,
+	// so that they're masked in the output of the run.
+	Redactor *redact.Redactor
 }
 
 // OverrideValuesWithBuildInfo overrides the specified config's values and provides a default set of values.
@@ -158,10 +171,13 @@ func LoadAndRenderSteps(ctx context.Context, template *Template, opts *BaseRende
 	if mergedVals == nil {
 		return "", nil
 	}
+	// Step outputs and matrix values are only known once the task is created, so their references are kept as is.
+	mergedVals["Steps"] = stepOutputPlaceholders(template)
+	mergedVals["Matrix"] = matrixPlaceholders(template)
 
 	engine := NewEngine()
-	// we will pass nil for the secret resolve override so as to use the default resolve function.
-	secrets, err := renderAndResolveSecrets(ctx, template, engine, nil, opts, mergedVals)
+	// If no secret resolve override is specified, the default resolve function is used.
+	secrets, err := renderAndResolveSecrets(ctx, template, engine, opts.ResolveSecretFunc, opts, mergedVals)
 	if err != nil {
 		return "", fmt.Errorf("failed to resolve secrets in the task with error: %v", err)
 	}
@@ -260,6 +276,7 @@ func renderAndResolveSecrets(
 	if err != nil {
 		return result, errors.Wrap(err, "failed to create secret resolver")
 	}
+	secretResolver.SetConcurrency(opts.SecretResolveConcurrency)
 
 	err = secretResolver.ResolveSecrets(ctx, task.Secrets)
 	if err != nil {
@@ -267,10 +284,35 @@ func renderAndResolveSecrets(
 	}
 	for _, s := range task.Secrets {
 		result[s.ID] = s.ResolvedValue
+		opts.Redactor.Add(s.ResolvedValue)
 	}
 	return result, nil
 }
 
+// stepOutputPlaceholders returns the values used to render the step output references found in the template,
+// which render to the references themselves so that they can be resolved by the builder.
+func stepOutputPlaceholders(template *Template) Values {
+	steps := Values{}
+	for stepID, names := range graph.FindOutputReferences(string(template.GetData())) {
+		outputs := map[string]interface{}{}
+		for _, name := range names {
+			outputs[name] = graph.OutputReferencePlaceholder(stepID, name)
+		}
+		steps[stepID] = map[string]interface{}{"Outputs": outputs}
+	}
+	return steps
+}
+
+// matrixPlaceholders returns the values used to render the matrix value references found in the template,
+// which render to the references themselves so that they can be resolved for each instance of the matrix.
+func matrixPlaceholders(template *Template) Values {
+	matrix := Values{}
+	for _, key := range graph.FindMatrixReferences(string(template.GetData())) {
+		matrix[key] = graph.MatrixReferencePlaceholder(key)
+	}
+	return matrix
+}
+
 // parseValues receives a slice of values in key=val format
 // and serializes them into YAML. If a key is specified more
 // than once, the key will be overridden.
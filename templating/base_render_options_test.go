@@ -117,6 +117,29 @@ func TestLoadAndRenderSteps(t *testing.T) {
     when: ["puller"]`,
 		},
 		{"testdata/caching/empty.yaml", ""},
+		{
+			"testdata/outputs/outputs.yaml",
+			`steps:
+  - id: version
+    cmd: bash -c 'echo "version: 1.0.0"'
+    outputs:
+      - name: version
+        regex: 'version: (\S+)'
+
+  - id: build
+    build: -t app:{{.Steps.version.Outputs.version}} --build-arg ID= .
+`,
+		},
+		{
+			"testdata/matrix/matrix.yaml",
+			`steps:
+  - id: build
+    build: -t app:{{.Matrix.base}}-{{.Matrix.arch}} --platform linux/{{.Matrix.arch}} .
+    matrix:
+      base: ["3.18", "3.19"]
+      arch: [amd64, arm64]
+`,
+		},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,45 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package templating
+
+import (
+	"context"
+	"log"
+
+	"github.com/Azure/acr-builder/graph"
+	"github.com/pkg/errors"
+)
+
+const aliasMinimumVersion = "v1.1.0"
+
+// LoadAndRenderTask renders a Task template the same way as LoadAndRenderSteps, but first
+// replaces any aliases if the Task's version supports them.
+// The returned Alias is nil if the Task's version doesn't support aliases.
+func LoadAndRenderTask(ctx context.Context, template *Template, opts *BaseRenderOptions) (string, *graph.Alias, error) {
+	var alias *graph.Alias
+	if graph.FindVersion(template.GetData()) >= aliasMinimumVersion {
+		log.Printf("Alias support enabled for version >= 1.1.0, please see https://aka.ms/acr/tasks/task-aliases for more information.")
+		// separate alias and remaining data from the Task
+		aliasData, taskData := graph.SeparateAliasFromRest(template.GetData())
+
+		// render alias data
+		renderedAlias, err := LoadAndRenderSteps(ctx, NewTemplate("aliasData", aliasData), opts)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "unable to render alias data")
+		}
+		// Preprocess the task to replace all aliases based on the alias sources.
+		processedTask, processedAlias, err := graph.SearchReplaceAlias(template.GetData(), []byte(renderedAlias), taskData)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "unable to search/replace aliases in task")
+		}
+		alias = processedAlias
+		template = &Template{Name: template.GetName(), Data: processedTask}
+	}
+
+	rendered, err := LoadAndRenderSteps(ctx, template, opts)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "unable to render task")
+	}
+	return rendered, alias, nil
+}
@@ -17,16 +17,22 @@ const (
 // GetExponentialBackoff returns a Duration that increases exponentially with
 // the number of attempts.
 func GetExponentialBackoff(attempt int) time.Duration {
+	return GetExponentialBackoffWithBounds(attempt, minBackoffDuration, maxBackoffDuration)
+}
+
+// GetExponentialBackoffWithBounds returns a Duration that increases exponentially with
+// the number of attempts, starting from minDelay and capped at maxDelay.
+func GetExponentialBackoffWithBounds(attempt int, minDelay time.Duration, maxDelay time.Duration) time.Duration {
 	if attempt <= 0 {
-		return minBackoffDuration
+		return minDelay
 	}
-	durationf := float64(minBackoffDuration) * math.Pow(base, float64(attempt))
+	durationf := float64(minDelay) * math.Pow(base, float64(attempt))
 	if durationf > math.MaxInt64 {
-		return maxBackoffDuration
+		return maxDelay
 	}
 	duration := time.Duration(durationf)
-	if duration > maxBackoffDuration {
-		return maxBackoffDuration
+	if duration > maxDelay {
+		return maxDelay
 	}
 	return duration
 }
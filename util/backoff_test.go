@@ -23,6 +23,13 @@ func TestGetExponentialBackoff(t *testing.T) {
 	equal(t, GetExponentialBackoff(math.MaxInt64), maxBackoffDuration)
 }
 
+func TestGetExponentialBackoffWithBounds(t *testing.T) {
+	equal(t, GetExponentialBackoffWithBounds(0, time.Second, time.Minute), time.Second)
+	equal(t, GetExponentialBackoffWithBounds(3, time.Second, time.Minute), 8*time.Second)
+	equal(t, GetExponentialBackoffWithBounds(6, time.Second, time.Minute), time.Minute)
+	equal(t, GetExponentialBackoffWithBounds(math.MaxInt64, time.Second, time.Minute), time.Minute)
+}
+
 func equal(t *testing.T, i, j interface{}) {
 	if !reflect.DeepEqual(i, j) {
 		t.Errorf("Expected %v, but got %v", j, i)
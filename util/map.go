@@ -8,3 +8,16 @@ func IsInterfaceMap(v interface{}) bool {
 	_, ok := v.(map[string]interface{})
 	return ok
 }
+
+// StringMapEquals determines whether or not two string maps are equivalent.
+func StringMapEquals(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if other, ok := b[k]; !ok || other != v {
+			return false
+		}
+	}
+	return true
+}
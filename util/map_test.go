@@ -21,3 +21,24 @@ func TestIsInterfaceMap(t *testing.T) {
 		}
 	}
 }
+
+func TestStringMapEquals(t *testing.T) {
+	tests := []struct {
+		a        map[string]string
+		b        map[string]string
+		expected bool
+	}{
+		{nil, nil, true},
+		{nil, map[string]string{}, true},
+		{map[string]string{"a": "b"}, map[string]string{"a": "b"}, true},
+		{map[string]string{"a": "b"}, map[string]string{"a": "c"}, false},
+		{map[string]string{"a": "b"}, map[string]string{"c": "b"}, false},
+		{map[string]string{"a": "b"}, nil, false},
+	}
+
+	for _, test := range tests {
+		if actual := StringMapEquals(test.a, test.b); actual != test.expected {
+			t.Errorf("Expected %v and %v to be equal to %v but got %v", test.a, test.b, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package util
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NewSignalContext returns a copy of the parent context which is cancelled once the process receives
+// an interrupt or a termination signal. Further signals have their default behavior, so that sending
+// the signal again terminates the process immediately. The returned stop function releases the resources.
+func NewSignalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Printf("Received signal: %v, cancelling the run. Send it again to exit immediately\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -5,7 +5,15 @@ package vaults
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net/url"
 	"strings"
 
@@ -13,8 +21,44 @@ import (
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/pkcs12"
 )
 
+// The collections of Azure Key Vault objects which can be resolved.
+const (
+	SecretsCollection      = "secrets"
+	CertificatesCollection = "certificates"
+	KeysCollection         = "keys"
+)
+
+// The formats Azure Key Vault certificates and keys can be resolved to.
+const (
+	// FormatPEM is the PEM encoded certificate along with its private key, or the PEM encoded public key of a key.
+	FormatPEM = "pem"
+
+	// FormatPFX is the base64 encoded PKCS #12 archive of a certificate along with its private key.
+	FormatPFX = "pfx"
+
+	// FormatCER is the PEM encoded certificate, without its private key.
+	FormatCER = "cer"
+
+	// FormatJWK is the public key of a key as a JSON web key.
+	FormatJWK = "jwk"
+)
+
+// The content types of the secrets backing Azure Key Vault certificates.
+const (
+	pkcs12ContentType = "application/x-pkcs12"
+	pemContentType    = "application/x-pem-file"
+)
+
+// validFormats are the formats each collection can be resolved to, the first one being the default.
+var validFormats = map[string][]string{
+	SecretsCollection:      {""},
+	CertificatesCollection: {FormatPEM, FormatPFX, FormatCER},
+	KeysCollection:         {FormatPEM, FormatJWK},
+}
+
 // AKVSecretConfig provides the options to get secret from Azure keyvault using MSI.
 type AKVSecretConfig struct {
 	VaultURL       string
@@ -22,6 +66,35 @@ type AKVSecretConfig struct {
 	SecretVersion  string
 	MSIClientID    string
 	AADResourceURL string
+
+	// Collection is the collection of the object, either secrets, certificates or keys.
+	Collection string
+
+	// Format is the format of certificates and keys. Secrets have no format.
+	Format string
+}
+
+// SetFormat sets the format of the certificate or key, or its default format if format is empty.
+func (secretConfig *AKVSecretConfig) SetFormat(format string) error {
+	formats, ok := validFormats[secretConfig.Collection]
+	if !ok {
+		return fmt.Errorf("invalid azure keyvault collection: %s", secretConfig.Collection)
+	}
+	if format == "" {
+		secretConfig.Format = formats[0]
+		return nil
+	}
+	format = strings.ToLower(format)
+	for _, f := range formats {
+		if f != "" && f == format {
+			secretConfig.Format = format
+			return nil
+		}
+	}
+	if secretConfig.Collection == SecretsCollection {
+		return fmt.Errorf("invalid format %s, azure keyvault secrets have no format", format)
+	}
+	return fmt.Errorf("invalid format %s for azure keyvault %s, expected one of: %s", format, secretConfig.Collection, strings.Join(formats, ", "))
 }
 
 // GetValue gets the secret vaule as defined by the config from Azure key vault using MSI.
@@ -41,7 +114,14 @@ func (secretConfig *AKVSecretConfig) GetValue(ctx context.Context) (string, erro
 		return "", err
 	}
 
-	secretValue, err := keyClient.getSecret(ctx, secretConfig.SecretName, secretConfig.SecretVersion)
+	switch secretConfig.Collection {
+	case CertificatesCollection:
+		return keyClient.getCertificate(ctx, secretConfig.SecretName, secretConfig.SecretVersion, secretConfig.Format)
+	case KeysCollection:
+		return keyClient.getPublicKey(ctx, secretConfig.SecretName, secretConfig.SecretVersion, secretConfig.Format)
+	}
+
+	secretValue, _, err := keyClient.getSecret(ctx, secretConfig.SecretName, secretConfig.SecretVersion)
 	if err != nil {
 		return "", err
 	}
@@ -49,7 +129,8 @@ func (secretConfig *AKVSecretConfig) GetValue(ctx context.Context) (string, erro
 	return secretValue, nil
 }
 
-// NewAKVSecretConfig creates the Azure Key Vault config.
+// NewAKVSecretConfig creates the Azure Key Vault config of the secret, certificate or key at the URL,
+// using the default format of certificates and keys.
 func NewAKVSecretConfig(vaultURL, msiClientID string) (*AKVSecretConfig, error) {
 	if vaultURL == "" {
 		return nil, errors.New("missing azure keyvault URL")
@@ -72,8 +153,9 @@ func NewAKVSecretConfig(vaultURL, msiClientID string) (*AKVSecretConfig, error)
 		return nil, fmt.Errorf("invalid azure keyvault secret URL. Bad number of URL segments: %d", len(urlSegments))
 	}
 
-	if urlSegments[1] != "secrets" {
-		return nil, fmt.Errorf("invalid azure keyvault secret URL. Expected 'secrets' collection, but found: %s", urlSegments[1])
+	collection := urlSegments[1]
+	if _, ok := validFormats[collection]; !ok {
+		return nil, fmt.Errorf("invalid azure keyvault secret URL. Expected 'secrets', 'certificates' or 'keys' collection, but found: %s", collection)
 	}
 
 	secretVersion := ""
@@ -98,6 +180,8 @@ func NewAKVSecretConfig(vaultURL, msiClientID string) (*AKVSecretConfig, error)
 		SecretVersion:  secretVersion,
 		MSIClientID:    msiClientID,
 		AADResourceURL: vaultAADResourceURL,
+		Collection:     collection,
+		Format:         validFormats[collection][0],
 	}
 
 	return akvConfig, nil
@@ -127,12 +211,149 @@ func newKeyVaultClient(vaultURL, clientID, vaultAADResourceURL string) (*keyVaul
 	return k, nil
 }
 
-// getSecret retrieves a secret from keyvault
-func (k *keyVault) getSecret(ctx context.Context, secretName, secretVersion string) (string, error) {
+// getSecret retrieves a secret from keyvault, along with its content type
+func (k *keyVault) getSecret(ctx context.Context, secretName, secretVersion string) (string, string, error) {
 	secretBundle, err := k.client.GetSecret(ctx, k.vaultURL, secretName, secretVersion)
+	if err != nil {
+		return "", "", err
+	}
+	if secretBundle.Value == nil {
+		return "", "", fmt.Errorf("the secret %s has no value", secretName)
+	}
+	contentType := ""
+	if secretBundle.ContentType != nil {
+		contentType = *secretBundle.ContentType
+	}
+
+	return *secretBundle.Value, contentType, nil
+}
+
+// getCertificate retrieves a certificate from keyvault in the specified format
+func (k *keyVault) getCertificate(ctx context.Context, certificateName, certificateVersion, format string) (string, error) {
+	if format == FormatCER {
+		certificateBundle, err := k.client.GetCertificate(ctx, k.vaultURL, certificateName, certificateVersion)
+		if err != nil {
+			return "", err
+		}
+		if certificateBundle.Cer == nil {
+			return "", fmt.Errorf("the certificate %s has no contents", certificateName)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: *certificateBundle.Cer})), nil
+	}
+
+	// The certificate along with its private key is stored in the secret backing it, which has the same name and version.
+	value, contentType, err := k.getSecret(ctx, certificateName, certificateVersion)
 	if err != nil {
 		return "", err
 	}
+	return encodeCertificate(value, contentType, format)
+}
 
-	return *secretBundle.Value, nil
+// getPublicKey retrieves the public key of a key from keyvault in the specified format
+func (k *keyVault) getPublicKey(ctx context.Context, keyName, keyVersion, format string) (string, error) {
+	keyBundle, err := k.client.GetKey(ctx, k.vaultURL, keyName, keyVersion)
+	if err != nil {
+		return "", err
+	}
+	if keyBundle.Key == nil {
+		return "", fmt.Errorf("the key %s has no contents", keyName)
+	}
+	return encodePublicKey(keyBundle.Key, format)
+}
+
+// encodeCertificate encodes the value of the secret backing a certificate, of the specified content type, in the format.
+func encodeCertificate(value, contentType, format string) (string, error) {
+	switch {
+	case contentType == pemContentType && format == FormatPEM:
+		return value, nil
+	case contentType == pemContentType:
+		return "", fmt.Errorf("the certificate is stored as %s, it can only be resolved to %s or %s", pemContentType, FormatPEM, FormatCER)
+	case contentType == pkcs12ContentType && format == FormatPFX:
+		return value, nil
+	case contentType == pkcs12ContentType:
+		pfx, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to decode the certificate")
+		}
+		// Certificates exported from Key Vault have no password.
+		blocks, err := pkcs12.ToPEM(pfx, "")
+		if err != nil {
+			return "", errors.Wrap(err, "failed to convert the certificate to PEM")
+		}
+		var sb strings.Builder
+		for _, block := range blocks {
+			block.Headers = nil
+			sb.Write(pem.EncodeToMemory(block))
+		}
+		return sb.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported certificate content type: %s", contentType)
+	}
+}
+
+// encodePublicKey encodes the public portion of the JSON web key in the format.
+func encodePublicKey(key *keyvault.JSONWebKey, format string) (string, error) {
+	if format == FormatJWK {
+		publicKey := keyvault.JSONWebKey{Kid: key.Kid, Kty: key.Kty, KeyOps: key.KeyOps, N: key.N, E: key.E, Crv: key.Crv, X: key.X, Y: key.Y}
+		b, err := json.Marshal(publicKey)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+
+	var publicKey interface{}
+	switch key.Kty {
+	case keyvault.RSA, keyvault.RSAHSM:
+		n, err := decodeKeyParameter("n", key.N)
+		if err != nil {
+			return "", err
+		}
+		e, err := decodeKeyParameter("e", key.E)
+		if err != nil {
+			return "", err
+		}
+		publicKey = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case keyvault.EC, keyvault.ECHSM:
+		var curve elliptic.Curve
+		switch key.Crv {
+		case keyvault.P256:
+			curve = elliptic.P256()
+		case keyvault.P384:
+			curve = elliptic.P384()
+		case keyvault.P521:
+			curve = elliptic.P521()
+		default:
+			return "", fmt.Errorf("unsupported elliptic curve %s, use the %s format", key.Crv, FormatJWK)
+		}
+		x, err := decodeKeyParameter("x", key.X)
+		if err != nil {
+			return "", err
+		}
+		y, err := decodeKeyParameter("y", key.Y)
+		if err != nil {
+			return "", err
+		}
+		publicKey = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	default:
+		return "", fmt.Errorf("unsupported key type %s, use the %s format", key.Kty, FormatJWK)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode the public key")
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// decodeKeyParameter decodes a base64 URL encoded parameter of a JSON web key.
+func decodeKeyParameter(name string, value *string) (*big.Int, error) {
+	if value == nil {
+		return nil, fmt.Errorf("the key is missing its %s parameter", name)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*value, "="))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode the %s parameter of the key", name)
+	}
+	return new(big.Int).SetBytes(b), nil
 }
@@ -0,0 +1,151 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package vaults
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// HashiCorpVaultAddrEnvVar is the environment variable specifying the address of the HashiCorp Vault server.
+	HashiCorpVaultAddrEnvVar = "VAULT_ADDR"
+
+	// HashiCorpVaultTokenEnvVar is the environment variable specifying the token to authenticate to HashiCorp Vault with.
+	HashiCorpVaultTokenEnvVar = "VAULT_TOKEN"
+
+	// HashiCorpVaultNamespaceEnvVar is the environment variable specifying the HashiCorp Vault Enterprise namespace, if any.
+	HashiCorpVaultNamespaceEnvVar = "VAULT_NAMESPACE"
+)
+
+// HashiCorpKVSecretConfig provides the options to get a secret from a HashiCorp Vault KV version 2 secrets engine.
+type HashiCorpKVSecretConfig struct {
+	Address   string
+	Token     string
+	Namespace string
+
+	// Mount is the path the KV secrets engine is mounted at, e.g. secret.
+	Mount string
+
+	// Path is the path of the secret within the secrets engine.
+	Path string
+
+	// Key is the key of the secret's data to get. It can be omitted if the secret has a single key.
+	Key string
+
+	Client *http.Client
+}
+
+// NewHashiCorpKVSecretConfig creates the HashiCorp Vault config of the secret at the specified path, made of the mount
+// of the secrets engine followed by the path of the secret within it, e.g. secret/myapp. The address, token and namespace
+// of the server are read from the environment.
+func NewHashiCorpKVSecretConfig(path string, key string) (*HashiCorpKVSecretConfig, error) {
+	mount, secretPath, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok || mount == "" || secretPath == "" {
+		return nil, fmt.Errorf("invalid HashiCorp Vault secret path %s, expected <mount>/<path>", path)
+	}
+	address := os.Getenv(HashiCorpVaultAddrEnvVar)
+	if address == "" {
+		return nil, fmt.Errorf("missing HashiCorp Vault address, %s must be set", HashiCorpVaultAddrEnvVar)
+	}
+
+	return &HashiCorpKVSecretConfig{
+		Address:   address,
+		Token:     os.Getenv(HashiCorpVaultTokenEnvVar),
+		Namespace: os.Getenv(HashiCorpVaultNamespaceEnvVar),
+		Mount:     mount,
+		Path:      secretPath,
+		Key:       key,
+	}, nil
+}
+
+// GetValue gets the secret value as defined by the config from HashiCorp Vault.
+func (secretConfig *HashiCorpKVSecretConfig) GetValue(ctx context.Context) (string, error) {
+	if secretConfig == nil {
+		return "", errors.New("secret config is required")
+	}
+	if secretConfig.Address == "" || secretConfig.Mount == "" || secretConfig.Path == "" {
+		return "", errors.New("missing required properties Address, Mount, and Path")
+	}
+
+	secretURL := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimSuffix(secretConfig.Address, "/"), secretConfig.Mount, secretConfig.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if secretConfig.Token != "" {
+		req.Header.Set("X-Vault-Token", secretConfig.Token)
+	}
+	if secretConfig.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", secretConfig.Namespace)
+	}
+
+	client := secretConfig.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get the HashiCorp Vault secret %s/%s", secretConfig.Mount, secretConfig.Path)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read the HashiCorp Vault secret %s/%s", secretConfig.Mount, secretConfig.Path)
+	}
+
+	var result struct {
+		Errors []string `json:"errors"`
+		Data   struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return "", errors.Wrapf(err, "failed to parse the HashiCorp Vault secret %s/%s", secretConfig.Mount, secretConfig.Path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get the HashiCorp Vault secret %s/%s, status: %d, errors: %s",
+			secretConfig.Mount, secretConfig.Path, resp.StatusCode, strings.Join(result.Errors, "; "))
+	}
+
+	return getHashiCorpKVValue(result.Data.Data, secretConfig.Key)
+}
+
+// getHashiCorpKVValue returns the value of the key of a secret's data, or its only value if no key is specified.
+func getHashiCorpKVValue(data map[string]interface{}, key string) (string, error) {
+	if key == "" {
+		if len(data) != 1 {
+			keys := make([]string, 0, len(data))
+			for k := range data {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return "", fmt.Errorf("the secret has %d keys (%s), the key to use must be specified", len(data), strings.Join(keys, ", "))
+		}
+		for k := range data {
+			key = k
+		}
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("the secret has no key %s", key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	// Other JSON values are returned as JSON.
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
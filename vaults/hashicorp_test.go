@@ -0,0 +1,146 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package vaults
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeVault returns a stand-in for a HashiCorp Vault server with a KV version 2 secrets engine mounted at secret.
+func newFakeVault(t *testing.T, token string, secrets map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		data, ok := secrets[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     data,
+				"metadata": map[string]interface{}{"version": 1},
+			},
+		}); err != nil {
+			t.Errorf("failed to encode the secret: %v", err)
+		}
+	}))
+}
+
+func TestNewHashiCorpKVSecretConfig(t *testing.T) {
+	t.Setenv(HashiCorpVaultAddrEnvVar, "http://127.0.0.1:8200")
+	t.Setenv(HashiCorpVaultTokenEnvVar, "token")
+	t.Setenv(HashiCorpVaultNamespaceEnvVar, "")
+
+	tests := []struct {
+		path          string
+		shouldError   bool
+		expectedMount string
+		expectedPath  string
+	}{
+		{"", true, "", ""},
+		{"secret", true, "", ""},
+		{"secret/", true, "", ""},
+		{"secret/myapp", false, "secret", "myapp"},
+		{"/kv/team/myapp/", false, "kv", "team/myapp"},
+	}
+
+	for _, test := range tests {
+		config, err := NewHashiCorpKVSecretConfig(test.path, "key")
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("expected path %s to error but it didn't", test.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for path %s: %v", test.path, err)
+		}
+		if config.Mount != test.expectedMount || config.Path != test.expectedPath || config.Token != "token" {
+			t.Errorf("unexpected config for path %s: %+v", test.path, config)
+		}
+	}
+
+	t.Setenv(HashiCorpVaultAddrEnvVar, "")
+	if _, err := NewHashiCorpKVSecretConfig("secret/myapp", ""); err == nil {
+		t.Error("expected an error when the address isn't set")
+	}
+}
+
+func TestHashiCorpKVGetValue(t *testing.T) {
+	server := newFakeVault(t, "token", map[string]map[string]interface{}{
+		"/v1/secret/data/myapp":  {"username": "admin", "password": "s3cr3t"},
+		"/v1/secret/data/single": {"value": "only"},
+		"/v1/secret/data/nested": {"config": map[string]interface{}{"port": 80}},
+	})
+	defer server.Close()
+
+	tests := []struct {
+		token       string
+		path        string
+		key         string
+		shouldError bool
+		expected    string
+	}{
+		{"token", "myapp", "password", false, "s3cr3t"},
+		{"token", "myapp", "username", false, "admin"},
+		{"token", "single", "", false, "only"},
+		{"token", "nested", "config", false, `{"port":80}`},
+		// Several keys but none specified.
+		{"token", "myapp", "", true, ""},
+		{"token", "myapp", "missing", true, ""},
+		{"token", "missing", "key", true, ""},
+		{"wrong", "myapp", "password", true, ""},
+	}
+
+	for _, test := range tests {
+		config := &HashiCorpKVSecretConfig{
+			Address: server.URL,
+			Token:   test.token,
+			Mount:   "secret",
+			Path:    test.path,
+			Key:     test.key,
+			Client:  server.Client(),
+		}
+		actual, err := config.GetValue(context.Background())
+		if test.shouldError {
+			if err == nil {
+				t.Errorf("expected %s/%s to error but it didn't", test.path, test.key)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error getting %s/%s: %v", test.path, test.key, err)
+		}
+		if actual != test.expected {
+			t.Errorf("expected %s but got %s", test.expected, actual)
+		}
+	}
+}
+
+func TestHashiCorpKVGetValueSendsNamespace(t *testing.T) {
+	var namespace string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace = r.Header.Get("X-Vault-Namespace")
+		_, _ = w.Write([]byte(`{"data":{"data":{"value":"v"}}}`))
+	}))
+	defer server.Close()
+
+	config := &HashiCorpKVSecretConfig{Address: server.URL, Namespace: "team", Mount: "secret", Path: "myapp"}
+	if _, err := config.GetValue(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if namespace != "team" {
+		t.Errorf("expected the namespace team but got %q", namespace)
+	}
+}
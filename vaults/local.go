@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package vaults
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileSecretConfig provides the options to get a secret from a local file, for local development.
+type FileSecretConfig struct {
+	Path string
+}
+
+// GetValue gets the contents of the file, without its trailing newline.
+func (secretConfig *FileSecretConfig) GetValue(_ context.Context) (string, error) {
+	if secretConfig == nil || secretConfig.Path == "" {
+		return "", errors.New("missing required property Path")
+	}
+	b, err := os.ReadFile(secretConfig.Path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the secret file")
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r"), nil
+}
+
+// EnvSecretConfig provides the options to get a secret from an environment variable, for local development.
+type EnvSecretConfig struct {
+	Name string
+}
+
+// GetValue gets the value of the environment variable, which must be set.
+func (secretConfig *EnvSecretConfig) GetValue(_ context.Context) (string, error) {
+	if secretConfig == nil || secretConfig.Name == "" {
+		return "", errors.New("missing required property Name")
+	}
+	value, ok := os.LookupEnv(secretConfig.Name)
+	if !ok {
+		return "", fmt.Errorf("the environment variable %s isn't set", secretConfig.Name)
+	}
+	return value, nil
+}